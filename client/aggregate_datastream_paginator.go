@@ -0,0 +1,48 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+// AggregateDatastreamPaginator handles a paginated set of results for an object-aggregated Datastream interface.
+// It works exactly like DatastreamPaginator, but its pages are made of DatastreamAggregateValue. You should prefer
+// AggregateDatastreamPaginator rather than direct API calls if you expect your result set to be particularly large.
+type AggregateDatastreamPaginator struct {
+	paginator DatastreamPaginator
+}
+
+// Rewind rewinds the simulator to the first page. GetNextPage will then return the first page of the call.
+func (d *AggregateDatastreamPaginator) Rewind() {
+	d.paginator.Rewind()
+}
+
+// HasNextPage returns whether this paginator can return more pages
+func (d *AggregateDatastreamPaginator) HasNextPage() bool {
+	return d.paginator.HasNextPage()
+}
+
+// GetPageSize returns the page size for this paginator
+func (d *AggregateDatastreamPaginator) GetPageSize() int {
+	return d.paginator.GetPageSize()
+}
+
+// GetResultSetOrder returns the order in which samples are returned for this paginator
+func (d *AggregateDatastreamPaginator) GetResultSetOrder() ResultSetOrder {
+	return d.paginator.GetResultSetOrder()
+}
+
+// GetNextPage retrieves the next result page from the paginator. Returns the page as an array of DatastreamAggregateValue.
+// If no more results are available, HasNextPage will return false. GetNextPage throws an error if no more pages are available.
+func (d *AggregateDatastreamPaginator) GetNextPage() ([]DatastreamAggregateValue, error) {
+	return d.paginator.GetNextAggregatePage()
+}
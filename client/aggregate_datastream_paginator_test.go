@@ -0,0 +1,101 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func collectAggregatePages(t *testing.T, paginator AggregateDatastreamPaginator) []DatastreamAggregateValue {
+	result := []DatastreamAggregateValue{}
+	for paginator.HasNextPage() {
+		page, err := paginator.GetNextPage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) > paginator.GetPageSize() {
+			t.Errorf("page has %v samples, page size is %v", len(page), paginator.GetPageSize())
+		}
+		result = append(result, page...)
+	}
+	return result
+}
+
+func TestAggregateDatastreamsPaginatorAscending(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	paginator, err := client.AppEngine.GetAggregateDatastreamsPaginator(testRealmName, testDevices[0], AstarteDeviceID,
+		testAggregateInterfaceName, "/gps", AscendingOrder, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	samples := collectAggregatePages(t, paginator)
+	if len(samples) != 25 {
+		t.Fatalf("expected 25 samples, got %v", len(samples))
+	}
+	for i, sample := range samples {
+		if !sample.Timestamp.Equal(testAggregateSamplesStart.Add(time.Duration(i) * time.Minute)) {
+			t.Errorf("sample %v has timestamp %v", i, sample.Timestamp)
+		}
+		if latitude, _ := sample.Values.Get("latitude"); latitude != float64(i) {
+			t.Errorf("sample %v has latitude %v", i, latitude)
+		}
+	}
+}
+
+func TestAggregateDatastreamsTimeWindowPaginatorDescending(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	since := testAggregateSamplesStart.Add(5 * time.Minute)
+	to := testAggregateSamplesStart.Add(20 * time.Minute)
+	paginator, err := client.AppEngine.GetAggregateDatastreamsTimeWindowPaginator(testRealmName, testDevices[0], AstarteDeviceID,
+		testAggregateInterfaceName, "/gps", since, to, DescendingOrder, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	samples := collectAggregatePages(t, paginator)
+	if len(samples) != 15 {
+		t.Fatalf("expected 15 samples, got %v", len(samples))
+	}
+	for i, sample := range samples {
+		if !sample.Timestamp.Equal(to.Add(-time.Duration(i+1) * time.Minute)) {
+			t.Errorf("sample %v has timestamp %v", i, sample.Timestamp)
+		}
+	}
+
+	paginator.Rewind()
+	if len(collectAggregatePages(t, paginator)) != 15 {
+		t.Error("rewinding the paginator did not restart the iteration")
+	}
+}
+
+func TestAggregateDatastreamsPaginatorDefaultPageSize(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	paginator, err := client.AppEngine.GetAggregateDatastreamsPaginator(testRealmName, testDevices[0], AstarteDeviceID,
+		testAggregateInterfaceName, "/gps", AscendingOrder, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paginator.GetPageSize() != defaultPageSize {
+		t.Errorf("expected default page size, got %v", paginator.GetPageSize())
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"sync"
+)
+
+// AliasConflictError is returned when assigning an alias which already belongs to another Device, or to
+// another tag of the same Device. Aliases are unique across the whole Realm, regardless of their tag.
+type AliasConflictError struct {
+	Alias string
+	// DeviceID and Tag are the assignment which was refused
+	DeviceID string
+	Tag      string
+	// OwnerDeviceID and OwnerTag are the current owner of the alias
+	OwnerDeviceID string
+	OwnerTag      string
+}
+
+func (e *AliasConflictError) Error() string {
+	return fmt.Sprintf("alias %s for tag %s of device %s is already assigned to tag %s of device %s",
+		e.Alias, e.Tag, e.DeviceID, e.OwnerTag, e.OwnerDeviceID)
+}
+
+// AliasAssignment is the assignment of Alias to the tag Tag of a Device.
+type AliasAssignment struct {
+	DeviceID string
+	Tag      string
+	Alias    string
+}
+
+type aliasOwner struct {
+	deviceID string
+	tag      string
+}
+
+// AliasManager keeps a Realm-wide index of Device aliases, and uses it to look up Devices by alias and to check
+// alias assignments before sending them to AppEngine. The index is built by Refresh and kept up to date with the
+// changes made through the AliasManager, but not with changes made by other clients. An AliasManager can be used
+// concurrently.
+type AliasManager struct {
+	appEngine *AppEngineService
+	realm     string
+	pageSize  int
+
+	lock     sync.RWMutex
+	byDevice map[string]map[string]string
+	byAlias  map[string]aliasOwner
+}
+
+// NewAliasManager returns an AliasManager for the Devices of realm. If pageSize is <= 0, the default page size is
+// used to build the index. Refresh must be called before using the AliasManager.
+func (s *AppEngineService) NewAliasManager(realm string, pageSize int) *AliasManager {
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	return &AliasManager{appEngine: s, realm: realm, pageSize: pageSize,
+		byDevice: map[string]map[string]string{}, byAlias: map[string]aliasOwner{}}
+}
+
+// Refresh rebuilds the alias index from the Device list.
+func (m *AliasManager) Refresh() error {
+	paginator, err := m.appEngine.GetDeviceListPaginator(m.realm, m.pageSize, DeviceDetailsFormat)
+	if err != nil {
+		return err
+	}
+
+	byDevice := map[string]map[string]string{}
+	byAlias := map[string]aliasOwner{}
+	for paginator.HasNextPage() {
+		page := []DeviceDetails{}
+		if err := paginator.GetNextPage(&page); err != nil {
+			return err
+		}
+		for _, device := range page {
+			if len(device.Aliases) == 0 {
+				continue
+			}
+			byDevice[device.DeviceID] = device.Aliases
+			for tag, alias := range device.Aliases {
+				byAlias[alias] = aliasOwner{deviceID: device.DeviceID, tag: tag}
+			}
+		}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.byDevice = byDevice
+	m.byAlias = byAlias
+	return nil
+}
+
+// Lookup returns the Device ID and the tag of alias, and whether alias exists.
+func (m *AliasManager) Lookup(alias string) (string, string, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	owner, ok := m.byAlias[alias]
+	return owner.deviceID, owner.tag, ok
+}
+
+// LookupByTag returns the Device ID of the Device having alias with tag, and whether it exists.
+func (m *AliasManager) LookupByTag(tag, alias string) (string, bool) {
+	deviceID, ownerTag, ok := m.Lookup(alias)
+	if !ok || ownerTag != tag {
+		return "", false
+	}
+	return deviceID, true
+}
+
+// DeviceAliases returns the aliases of a Device, keyed by tag.
+func (m *AliasManager) DeviceAliases(deviceID string) map[string]string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	aliases := map[string]string{}
+	for tag, alias := range m.byDevice[deviceID] {
+		aliases[tag] = alias
+	}
+	return aliases
+}
+
+// TagAliases returns the aliases with tag, keyed by Device ID.
+func (m *AliasManager) TagAliases(tag string) map[string]string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	aliases := map[string]string{}
+	for deviceID, deviceAliases := range m.byDevice {
+		if alias, ok := deviceAliases[tag]; ok {
+			aliases[deviceID] = alias
+		}
+	}
+	return aliases
+}
+
+// CheckAssignments checks assignments against the index and against each other, and returns an AliasConflictError
+// for each assignment which would conflict. Assignments which would replace the alias of a tag are not conflicts.
+func (m *AliasManager) CheckAssignments(assignments []AliasAssignment) []*AliasConflictError {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	conflicts := []*AliasConflictError{}
+	assigned := map[string]aliasOwner{}
+	for _, a := range assignments {
+		owner, ok := assigned[a.Alias]
+		if !ok {
+			owner, ok = m.byAlias[a.Alias]
+		}
+		if ok && (owner.deviceID != a.DeviceID || owner.tag != a.Tag) {
+			conflicts = append(conflicts, &AliasConflictError{Alias: a.Alias, DeviceID: a.DeviceID, Tag: a.Tag,
+				OwnerDeviceID: owner.deviceID, OwnerTag: owner.tag})
+			continue
+		}
+		assigned[a.Alias] = aliasOwner{deviceID: a.DeviceID, tag: a.Tag}
+	}
+	return conflicts
+}
+
+// SetAlias assigns alias to tag of a Device, replacing the current alias of tag, if any. It returns an
+// AliasConflictError without contacting AppEngine if alias already belongs to another Device or tag.
+func (m *AliasManager) SetAlias(deviceID, tag, alias string) error {
+	if conflicts := m.CheckAssignments([]AliasAssignment{{DeviceID: deviceID, Tag: tag, Alias: alias}}); len(conflicts) > 0 {
+		return conflicts[0]
+	}
+	if err := m.appEngine.AddDeviceAlias(m.realm, deviceID, tag, alias); err != nil {
+		return err
+	}
+	m.indexAlias(deviceID, tag, alias)
+	return nil
+}
+
+// DeleteAlias deletes the alias with tag from a Device.
+func (m *AliasManager) DeleteAlias(deviceID, tag string) error {
+	if err := m.appEngine.DeleteDeviceAlias(m.realm, deviceID, tag); err != nil {
+		return err
+	}
+	m.unindexAlias(deviceID, tag)
+	return nil
+}
+
+// RenameTag renames the tag of all aliases with oldTag to newTag, keeping their values. Devices which already have
+// an alias with newTag are not changed, and are reported as failed. All Devices are processed even
+// if some of them fail: in that case, a DeviceErrors is returned, keyed by Device ID.
+func (m *AliasManager) RenameTag(oldTag, newTag string) error {
+	errs := DeviceErrors{}
+	for deviceID, alias := range m.TagAliases(oldTag) {
+		if _, ok := m.DeviceAliases(deviceID)[newTag]; ok {
+			errs[deviceID] = fmt.Errorf("device %s already has an alias with tag %s", deviceID, newTag)
+			continue
+		}
+		// Aliases are unique, so the old one must be deleted before adding the new one
+		if err := m.DeleteAlias(deviceID, oldTag); err != nil {
+			errs[deviceID] = err
+			continue
+		}
+		if err := m.SetAlias(deviceID, newTag, alias); err != nil {
+			errs[deviceID] = m.rollback(err, deviceID, oldTag, alias)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// MoveAlias moves the alias with tag from a Device to another one, replacing the alias of tag of the destination
+// Device, if any. If the alias cannot be assigned to the destination Device, it is assigned back to the source one.
+func (m *AliasManager) MoveAlias(tag, fromDeviceID, toDeviceID string) error {
+	alias, ok := m.DeviceAliases(fromDeviceID)[tag]
+	if !ok {
+		return fmt.Errorf("device %s has no alias with tag %s", fromDeviceID, tag)
+	}
+
+	if err := m.DeleteAlias(fromDeviceID, tag); err != nil {
+		return err
+	}
+	if err := m.SetAlias(toDeviceID, tag, alias); err != nil {
+		return m.rollback(err, fromDeviceID, tag, alias)
+	}
+	return nil
+}
+
+// rollback assigns alias back to tag of a Device after err, and reports whether that failed too
+func (m *AliasManager) rollback(err error, deviceID, tag, alias string) error {
+	if rollbackErr := m.SetAlias(deviceID, tag, alias); rollbackErr != nil {
+		return fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+	}
+	return err
+}
+
+func (m *AliasManager) indexAlias(deviceID, tag, alias string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if previous, ok := m.byDevice[deviceID][tag]; ok {
+		delete(m.byAlias, previous)
+	}
+	if m.byDevice[deviceID] == nil {
+		m.byDevice[deviceID] = map[string]string{}
+	}
+	m.byDevice[deviceID][tag] = alias
+	m.byAlias[alias] = aliasOwner{deviceID: deviceID, tag: tag}
+}
+
+func (m *AliasManager) unindexAlias(deviceID, tag string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if alias, ok := m.byDevice[deviceID][tag]; ok {
+		delete(m.byAlias, alias)
+		delete(m.byDevice[deviceID], tag)
+	}
+}
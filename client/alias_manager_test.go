@@ -0,0 +1,76 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"testing"
+)
+
+func TestAliasManager(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	manager := client.AppEngine.NewAliasManager(testRealmName, 2)
+	if err := manager.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if deviceID, tag, ok := manager.Lookup("sensor-a"); !ok || deviceID != testDevices[0] || tag != "name" {
+		t.Errorf("unexpected lookup %v %v %v", deviceID, tag, ok)
+	}
+
+	conflicts := manager.CheckAssignments([]AliasAssignment{
+		{DeviceID: testDevices[2], Tag: "name", Alias: "sensor-a"},
+		{DeviceID: testDevices[2], Tag: "name", Alias: "sensor-c"},
+		{DeviceID: testDevices[1], Tag: "serial", Alias: "sensor-c"},
+		{DeviceID: testDevices[1], Tag: "name", Alias: "sensor-b"},
+	})
+	if len(conflicts) != 2 || conflicts[0].OwnerDeviceID != testDevices[0] || conflicts[1].OwnerDeviceID != testDevices[2] {
+		t.Errorf("unexpected conflicts %v", conflicts)
+	}
+	if err := manager.SetAlias(testDevices[2], "name", "sensor-b"); err == nil {
+		t.Error("conflicting alias was assigned")
+	}
+	if requests := popTestWriteRequests(); len(requests) != 0 {
+		t.Errorf("unexpected requests %v", requests)
+	}
+
+	// Moving to an unknown device fails, and the alias is assigned back
+	if err := manager.MoveAlias("name", testDevices[0], "QwtRYCkWTmiuoQCtYjdA1g"); err == nil {
+		t.Error("alias was moved to an unknown device")
+	}
+	if requests := popTestWriteRequests(); len(requests) != 3 {
+		t.Errorf("unexpected requests %v", requests)
+	}
+	if deviceID, ok := manager.LookupByTag("name", "sensor-a"); !ok || deviceID != testDevices[0] {
+		t.Errorf("alias was not rolled back: %v", deviceID)
+	}
+
+	if err := manager.MoveAlias("name", testDevices[0], testDevices[2]); err != nil {
+		t.Fatal(err)
+	}
+	if deviceID, ok := manager.LookupByTag("name", "sensor-a"); !ok || deviceID != testDevices[2] {
+		t.Errorf("alias was not moved: %v", deviceID)
+	}
+
+	if err := manager.RenameTag("name", "label"); err != nil {
+		t.Fatal(err)
+	}
+	if aliases := manager.TagAliases("label"); len(aliases) != 2 || aliases[testDevices[1]] != "sensor-b" {
+		t.Errorf("unexpected aliases %v", aliases)
+	}
+	if aliases := manager.TagAliases("name"); len(aliases) != 0 {
+		t.Errorf("unexpected aliases %v", aliases)
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"net"
 	"time"
 
+	"github.com/astarte-platform/astarte-go/interfaces"
 	"github.com/iancoleman/orderedmap"
 )
 
@@ -104,6 +105,11 @@ type RealmDetails struct {
 	DatacenterReplicationFactors map[string]int   `json:"datacenter_replication_factors,omitempty"`
 }
 
+// RealmAuthConfig represents the authentication configuration of a Realm
+type RealmAuthConfig struct {
+	JwtPublicKeyPEM string `json:"jwt_public_key_pem"`
+}
+
 // DeviceInterfaceIntrospection represents a single entry in a Device Introspection array retrieved
 // from DeviceDetails
 type DeviceInterfaceIntrospection struct {
@@ -131,6 +137,8 @@ type DeviceDetails struct {
 	Aliases                  map[string]string                       `json:"aliases"`
 	PreviousInterfaces       []DeviceInterfaceIntrospection          `json:"previous_interfaces,omitempty"`
 	Attributes               map[string]string                       `json:"attributes,omitempty"`
+	Groups                   []string                                `json:"groups,omitempty"`
+	DeletionInProgress       bool                                    `json:"deletion_in_progress,omitempty"`
 }
 
 // DatastreamValue represent one single Datastream Value
@@ -142,8 +150,9 @@ type DatastreamValue struct {
 
 // DatastreamAggregateValue represent one single Datastream Value for an Aggregate
 type DatastreamAggregateValue struct {
-	Values    orderedmap.OrderedMap
-	Timestamp time.Time
+	Values             orderedmap.OrderedMap
+	Timestamp          time.Time
+	ReceptionTimestamp time.Time
 }
 
 // UnmarshalJSON unmarshals a quoted json string to a DatastreamAggregateValue
@@ -154,22 +163,19 @@ func (s *DatastreamAggregateValue) UnmarshalJSON(b []byte) error {
 		return err
 	}
 
-	timestampInterface, _ := j.Get("timestamp")
-	switch v := timestampInterface.(type) {
-	case time.Time:
-		s.Timestamp = v
-	case string:
-		var err error
-		s.Timestamp, err = time.Parse(time.RFC3339Nano, v)
-		if err != nil {
-			return err
-		}
-	}
-
-	j.Delete("timestamp")
-	s.Values = j
+	*s, err = parseAggregateDatastreamValue(j)
+	return err
+}
 
-	return nil
+// Decode decodes the aggregate values into v, which must be a pointer to a struct with `astarte` tags.
+// astarteInterface and interfacePath are the ones the aggregate was retrieved from, and are used
+// to convert values to the struct's types. See interfaces.UnmarshalAggregateMessage for details.
+func (s *DatastreamAggregateValue) Decode(astarteInterface interfaces.AstarteInterface, interfacePath string, v interface{}) error {
+	values := map[string]interface{}{}
+	for _, k := range s.Values.Keys() {
+		values[k], _ = s.Values.Get(k)
+	}
+	return interfaces.UnmarshalAggregateMessage(astarteInterface, interfacePath, values, v)
 }
 
 type DevicesStats struct {
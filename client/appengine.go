@@ -20,6 +20,7 @@ import (
 	"net/url"
 	"path"
 	"reflect"
+	"sync"
 	"time"
 
 	"github.com/astarte-platform/astarte-go/interfaces"
@@ -32,8 +33,11 @@ var invalidTime time.Time = time.Unix(0, 0)
 
 // AppEngineService is the API Client for AppEngine API
 type AppEngineService struct {
-	client       *Client
-	appEngineURL *url.URL
+	client         *Client
+	appEngineURL   *url.URL
+	aliasCache     deviceAliasCache
+	resolutionLock sync.RWMutex
+	resolution     DeviceIdentifierResolution
 }
 
 // GetProperties returns all the currently set Properties on a given Interface
@@ -61,20 +65,49 @@ func (s *AppEngineService) GetDatastreamSnapshot(realm string, deviceIdentifier
 // GetLastDatastreams returns all the last values on a path for a Datastream interface.
 // If limit is <= 0, it returns all existing datastreams. Consider using a GetDatastreamsPaginator in that case.
 func (s *AppEngineService) GetLastDatastreams(realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType, interfaceName, interfacePath string, limit int) ([]DatastreamValue, error) {
-	resolvedDeviceIdentifierType := resolveDeviceIdentifierType(deviceIdentifier, deviceIdentifierType)
-	return s.getDatastreamInternal(realm, deviceIdentifier, resolvedDeviceIdentifierType, interfaceName, interfacePath, invalidTime, invalidTime, limit, DescendingOrder)
+	return s.getDatastreamInternal(realm, deviceIdentifier, deviceIdentifierType, interfaceName, interfacePath, invalidTime, invalidTime, limit, DescendingOrder)
 }
 
 // GetDatastreamsPaginator returns a Paginator for all the values on a path for a Datastream interface.
 func (s *AppEngineService) GetDatastreamsPaginator(realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType, interfaceName, interfacePath string, resultSetOrder ResultSetOrder) (DatastreamPaginator, error) {
-	resolvedDeviceIdentifierType := resolveDeviceIdentifierType(deviceIdentifier, deviceIdentifierType)
-	return s.getDatastreamPaginatorInternal(realm, deviceIdentifier, resolvedDeviceIdentifierType, interfaceName, interfacePath, invalidTime, time.Now(), defaultPageSize, resultSetOrder)
+	deviceResourcePath, err := s.resolveDevicePath(realm, deviceIdentifier, deviceIdentifierType)
+	if err != nil {
+		return DatastreamPaginator{}, err
+	}
+	return s.getDatastreamPaginatorInternal(realm, deviceResourcePath, interfaceName, interfacePath, invalidTime, time.Now(), defaultPageSize, resultSetOrder)
 }
 
 // GetDatastreamsTimeWindowPaginator returns a Paginator for all the values on a path in a specified time window for a Datastream interface.
 func (s *AppEngineService) GetDatastreamsTimeWindowPaginator(realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType, interfaceName, interfacePath string, since, to time.Time, resultSetOrder ResultSetOrder) (DatastreamPaginator, error) {
-	resolvedDeviceIdentifierType := resolveDeviceIdentifierType(deviceIdentifier, deviceIdentifierType)
-	return s.getDatastreamPaginatorInternal(realm, deviceIdentifier, resolvedDeviceIdentifierType, interfaceName, interfacePath, since, to, defaultPageSize, resultSetOrder)
+	deviceResourcePath, err := s.resolveDevicePath(realm, deviceIdentifier, deviceIdentifierType)
+	if err != nil {
+		return DatastreamPaginator{}, err
+	}
+	return s.getDatastreamPaginatorInternal(realm, deviceResourcePath, interfaceName, interfacePath, since, to, defaultPageSize, resultSetOrder)
+}
+
+// GetAggregateDatastreamsPaginator returns a Paginator for all the values on a path for a Datastream aggregate interface.
+// If pageSize is <= 0, the default page size is used.
+func (s *AppEngineService) GetAggregateDatastreamsPaginator(realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType, interfaceName, interfacePath string, resultSetOrder ResultSetOrder, pageSize int) (AggregateDatastreamPaginator, error) {
+	return s.GetAggregateDatastreamsTimeWindowPaginator(realm, deviceIdentifier, deviceIdentifierType, interfaceName, interfacePath, invalidTime, time.Now(), resultSetOrder, pageSize)
+}
+
+// GetAggregateDatastreamsTimeWindowPaginator returns a Paginator for all the values on a path in a specified time window
+// for a Datastream aggregate interface. If pageSize is <= 0, the default page size is used.
+func (s *AppEngineService) GetAggregateDatastreamsTimeWindowPaginator(realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType, interfaceName, interfacePath string, since, to time.Time, resultSetOrder ResultSetOrder, pageSize int) (AggregateDatastreamPaginator, error) {
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	deviceResourcePath, err := s.resolveDevicePath(realm, deviceIdentifier, deviceIdentifierType)
+	if err != nil {
+		return AggregateDatastreamPaginator{}, err
+	}
+	datastreamPaginator, err := s.getDatastreamPaginatorInternal(realm, deviceResourcePath, interfaceName, interfacePath, since, to, pageSize, resultSetOrder)
+	if err != nil {
+		return AggregateDatastreamPaginator{}, err
+	}
+
+	return AggregateDatastreamPaginator{paginator: datastreamPaginator}, nil
 }
 
 // GetAggregateParametricDatastreamSnapshot returns the last value for a Parametric Datastream aggregate interface
@@ -127,10 +160,47 @@ func (s *AppEngineService) GetAggregateDatastreamsTimeWindow(realm, deviceIdenti
 // SendData sends data to the specified astarteInterface. It performs all validity checks on the Interface object before moving forward
 // with the operation, as such it is assumed that the operation will be always validated on the client side. If you have access to a native
 // Interface object, accessing this method rather than the lower level ones is advised.
-// payload must match a compatible type for the Interface path. In case of an aggregate interface, payload *must* be a
-// map[string]interface{}, and each payload will be individually checked
+// payload must match a compatible type for the Interface path. In case of an aggregate interface, payload *must* be either a
+// map[string]interface{}, and each payload will be individually checked, or a struct with `astarte` tags, which will be
+// encoded with interfaces.MarshalAggregateMessage
 func (s *AppEngineService) SendData(realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType,
 	astarteInterface interfaces.AstarteInterface, interfacePath string, payload interface{}) error {
+	return s.sendData(realm, deviceIdentifier, deviceIdentifierType, astarteInterface, interfacePath, payload, nil)
+}
+
+// SendDataWithTimestamp works like SendData, but sends timestamp as the explicit timestamp of a datastream. The
+// mapping of interfacePath, or the Interface for aggregates, must have explicit_timestamp set.
+func (s *AppEngineService) SendDataWithTimestamp(realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType,
+	astarteInterface interfaces.AstarteInterface, interfacePath string, payload interface{}, timestamp time.Time) error {
+	if err := checkExplicitTimestamp(astarteInterface, interfacePath); err != nil {
+		return err
+	}
+	return s.sendData(realm, deviceIdentifier, deviceIdentifierType, astarteInterface, interfacePath, payload, &timestamp)
+}
+
+// checkExplicitTimestamp returns an error if values sent on interfacePath can't have an explicit timestamp
+func checkExplicitTimestamp(astarteInterface interfaces.AstarteInterface, interfacePath string) error {
+	switch {
+	case astarteInterface.Type == interfaces.PropertiesType:
+		return errors.New("properties have no timestamp")
+	case astarteInterface.Aggregation == interfaces.ObjectAggregation:
+		if astarteInterface.ExplicitTimestamp || (len(astarteInterface.Mappings) > 0 && astarteInterface.Mappings[0].ExplicitTimestamp) {
+			return nil
+		}
+		return fmt.Errorf("interface %s has no explicit timestamp", astarteInterface.Name)
+	}
+	mapping, err := interfaces.InterfaceMappingFromPath(astarteInterface, interfacePath)
+	if err != nil {
+		return err
+	}
+	if !mapping.ExplicitTimestamp {
+		return fmt.Errorf("mapping %s has no explicit timestamp", mapping.Endpoint)
+	}
+	return nil
+}
+
+func (s *AppEngineService) sendData(realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType,
+	astarteInterface interfaces.AstarteInterface, interfacePath string, payload interface{}, timestamp *time.Time) error {
 	// Perform a set of checks depending on the interface structure
 	switch {
 	case astarteInterface.Ownership == interfaces.DeviceOwnership:
@@ -141,9 +211,17 @@ func (s *AppEngineService) SendData(realm, deviceIdentifier string, deviceIdenti
 			return err
 		}
 	case astarteInterface.Aggregation == interfaces.ObjectAggregation:
+		if isStructPayload(payload) {
+			aggregatePayload, err := interfaces.MarshalAggregateMessage(astarteInterface, interfacePath, payload)
+			if err != nil {
+				return err
+			}
+			payload = aggregatePayload
+			break
+		}
 		aggregatePayload, ok := payload.(map[string]interface{})
 		if !ok {
-			return errors.New("payload must be a map[string]interface{}")
+			return errors.New("payload must be a map[string]interface{} or a struct")
 		}
 		if err := interfaces.ValidateAggregateMessage(astarteInterface, interfacePath, aggregatePayload); err != nil {
 			return err
@@ -154,10 +232,10 @@ func (s *AppEngineService) SendData(realm, deviceIdentifier string, deviceIdenti
 	switch {
 	case astarteInterface.Type == interfaces.PropertiesType:
 		return s.SetProperty(realm, deviceIdentifier, deviceIdentifierType, astarteInterface.Name, interfacePath, payload)
-	case astarteInterface.Aggregation == interfaces.IndividualAggregation:
-		return s.SendDatastream(realm, deviceIdentifier, deviceIdentifierType, astarteInterface.Name, interfacePath, payload)
-	case astarteInterface.Aggregation == interfaces.ObjectAggregation:
-		return s.SendAggregateDatastream(realm, deviceIdentifier, deviceIdentifierType, astarteInterface.Name, interfacePath, payload)
+	case astarteInterface.Aggregation == interfaces.IndividualAggregation, astarteInterface.Aggregation == interfaces.ObjectAggregation:
+		// Payloads were validated already
+		return s.performSendRequest(realm, deviceIdentifier, deviceIdentifierType, astarteInterface.Name, interfacePath,
+			payload, "POST", timestamp)
 	}
 
 	// We should never get here
@@ -171,7 +249,7 @@ func (s *AppEngineService) SendDatastream(realm, deviceIdentifier string, device
 	if reflect.TypeOf(payload).Kind() == reflect.Map {
 		return errors.New("payload must not be a map")
 	}
-	return s.performSendRequest(realm, deviceIdentifier, deviceIdentifierType, interfaceName, interfacePath, payload, "POST")
+	return s.performSendRequest(realm, deviceIdentifier, deviceIdentifierType, interfaceName, interfacePath, payload, "POST", nil)
 }
 
 // SendAggregateDatastream sends an aggregate datastream to the given interface without additional checks.
@@ -181,20 +259,41 @@ func (s *AppEngineService) SendAggregateDatastream(realm, deviceIdentifier strin
 	if reflect.TypeOf(payload).Kind() != reflect.Map {
 		return errors.New("payload must be a map")
 	}
-	return s.performSendRequest(realm, deviceIdentifier, deviceIdentifierType, interfaceName, interfacePath, payload, "POST")
+	return s.performSendRequest(realm, deviceIdentifier, deviceIdentifierType, interfaceName, interfacePath, payload, "POST", nil)
 }
 
 // SetProperty sets a property on the given interface without additional checks. payload must be of a type
 // compatible with the interface's endpoint Any errors will be returned on the server side or
 // in payload marshaling. If you have a native AstarteInterface object, calling SendData is advised
 func (s *AppEngineService) SetProperty(realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType, interfaceName, interfacePath string, payload interface{}) error {
-	return s.performSendRequest(realm, deviceIdentifier, deviceIdentifierType, interfaceName, interfacePath, payload, "PUT")
+	return s.performSendRequest(realm, deviceIdentifier, deviceIdentifierType, interfaceName, interfacePath, payload, "PUT", nil)
+}
+
+// UnsetProperty unsets a property on the given interface without additional checks. The mapping of interfacePath
+// must allow unset. Any errors will be returned on the server side
+func (s *AppEngineService) UnsetProperty(realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType, interfaceName, interfacePath string) error {
+	return s.withDevicePath(realm, deviceIdentifier, deviceIdentifierType, func(deviceResourcePath string) error {
+		url, err := s.appengineGenericJSONDataAPIURL(interfaceName+interfacePath, realm, deviceResourcePath, "")
+		if err != nil {
+			return err
+		}
+
+		return s.client.genericJSONDataAPIDelete(url.String(), 204)
+	})
 }
 
 //////////
 // Private APIs: These abstract the real calls and do custom decoding of the different reply types
 //////////
 
+func isStructPayload(payload interface{}) bool {
+	payloadType := reflect.TypeOf(payload)
+	if payloadType != nil && payloadType.Kind() == reflect.Ptr {
+		payloadType = payloadType.Elem()
+	}
+	return payloadType != nil && payloadType.Kind() == reflect.Struct
+}
+
 func (s *AppEngineService) nestedIndividualQuery(urlPath, realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType, rawQuery string) (map[string]interface{}, error) {
 	ret := map[string]interface{}{}
 	err := s.appengineGenericJSONDataAPIGet(&ret, urlPath, realm, deviceIdentifier, deviceIdentifierType, rawQuery)
@@ -209,14 +308,15 @@ func (s *AppEngineService) aggregateDatastreamQuery(urlPath, realm, deviceIdenti
 	return ret, err
 }
 
-func (s *AppEngineService) appengineGenericJSONDataAPIURL(urlPath, realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType, rawQuery string) (*url.URL, error) {
-	resolvedDeviceIdentifierType := resolveDeviceIdentifierType(deviceIdentifier, deviceIdentifierType)
+// appengineGenericJSONDataAPIURL returns the URL of urlPath in the interfaces of a Device. deviceResourcePath
+// is the path of the Device, as returned by devicePath
+func (s *AppEngineService) appengineGenericJSONDataAPIURL(urlPath, realm, deviceResourcePath, rawQuery string) (*url.URL, error) {
 	callURL, err := url.Parse(s.appEngineURL.String())
 	if err != nil {
 		return nil, err
 	}
 	callURL.Path = path.Join(callURL.Path, fmt.Sprintf("/v1/%s/%s/interfaces/%s", realm,
-		devicePath(deviceIdentifier, resolvedDeviceIdentifierType), urlPath))
+		deviceResourcePath, urlPath))
 	if len(rawQuery) > 0 {
 		callURL.RawQuery = rawQuery
 	}
@@ -224,12 +324,14 @@ func (s *AppEngineService) appengineGenericJSONDataAPIURL(urlPath, realm, device
 }
 
 func (s *AppEngineService) appengineGenericJSONDataAPIGet(ret interface{}, urlPath, realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType, rawQuery string) error {
-	url, err := s.appengineGenericJSONDataAPIURL(urlPath, realm, deviceIdentifier, deviceIdentifierType, rawQuery)
-	if err != nil {
-		return err
-	}
+	return s.withDevicePath(realm, deviceIdentifier, deviceIdentifierType, func(deviceResourcePath string) error {
+		url, err := s.appengineGenericJSONDataAPIURL(urlPath, realm, deviceResourcePath, rawQuery)
+		if err != nil {
+			return err
+		}
 
-	return s.client.genericJSONDataAPIGET(ret, url.String(), 200)
+		return s.client.genericJSONDataAPIGET(ret, url.String(), 200)
+	})
 }
 
 func (s *AppEngineService) getDatastreamInternal(realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType, interfaceName, interfacePath string,
@@ -238,7 +340,11 @@ func (s *AppEngineService) getDatastreamInternal(realm, deviceIdentifier string,
 	if limit < 0 || limit > defaultPageSize {
 		realLimit = defaultPageSize
 	}
-	datastreamPaginator, err := s.getDatastreamPaginatorInternal(realm, deviceIdentifier, deviceIdentifierType, interfaceName, interfacePath,
+	deviceResourcePath, err := s.resolveDevicePath(realm, deviceIdentifier, deviceIdentifierType)
+	if err != nil {
+		return nil, err
+	}
+	datastreamPaginator, err := s.getDatastreamPaginatorInternal(realm, deviceResourcePath, interfaceName, interfacePath,
 		since, to, realLimit, resultSetOrder)
 	if err != nil {
 		return nil, err
@@ -268,9 +374,9 @@ func (s *AppEngineService) getDatastreamInternal(realm, deviceIdentifier string,
 	return resultSet, nil
 }
 
-func (s *AppEngineService) getDatastreamPaginatorInternal(realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType, interfaceName, interfacePath string,
+func (s *AppEngineService) getDatastreamPaginatorInternal(realm, deviceResourcePath, interfaceName, interfacePath string,
 	since, to time.Time, pageSize int, resultSetOrder ResultSetOrder) (DatastreamPaginator, error) {
-	url, err := s.appengineGenericJSONDataAPIURL(interfaceName+interfacePath, realm, deviceIdentifier, deviceIdentifierType, "")
+	url, err := s.appengineGenericJSONDataAPIURL(interfaceName+interfacePath, realm, deviceResourcePath, "")
 	if err != nil {
 		return DatastreamPaginator{}, err
 	}
@@ -288,12 +394,15 @@ func (s *AppEngineService) getDatastreamPaginatorInternal(realm, deviceIdentifie
 	return datastreamPaginator, nil
 }
 
-func (s *AppEngineService) performSendRequest(realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType, interfaceName, interfacePath string, payload interface{}, method string) error {
-	url, err := s.appengineGenericJSONDataAPIURL(interfaceName+interfacePath, realm, deviceIdentifier, deviceIdentifierType, "")
-	if err != nil {
-		return err
-	}
-
+func (s *AppEngineService) performSendRequest(realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType, interfaceName, interfacePath string, payload interface{}, method string, timestamp *time.Time) error {
 	// Normalize payload encoding bytes, given we're using JSON
-	return s.client.genericJSONDataAPIWriteNoResponse(method, url.String(), interfaces.NormalizePayload(payload, true), 200)
+	normalizedPayload := interfaces.NormalizePayload(payload, true)
+	return s.withDevicePath(realm, deviceIdentifier, deviceIdentifierType, func(deviceResourcePath string) error {
+		url, err := s.appengineGenericJSONDataAPIURL(interfaceName+interfacePath, realm, deviceResourcePath, "")
+		if err != nil {
+			return err
+		}
+
+		return s.client.genericJSONDataAPIWriteWithTimestamp(nil, method, url.String(), normalizedPayload, timestamp, "application/json", 200)
+	})
 }
@@ -91,45 +91,51 @@ func (s *AppEngineService) GetDeviceListPaginator(realm string, pageSize int, fo
 		return DeviceListPaginator{}, err
 	}
 	callURL.Path = path.Join(callURL.Path, fmt.Sprintf("/v1/%s/devices", realm))
-	query := url.Values{}
 
-	deviceListPaginator := DeviceListPaginator{
-		baseURL:     callURL,
-		nextQuery:   query,
-		format:      format,
-		pageSize:    pageSize,
-		client:      s.client,
-		hasNextPage: true,
-	}
-	return deviceListPaginator, nil
+	return newDeviceListPaginator(s.client, callURL, pageSize, format), nil
 }
 
 // GetDevice returns the DeviceDetails of a single Device in the Realm
 func (s *AppEngineService) GetDevice(realm string, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType) (DeviceDetails, error) {
-	resolvedDeviceIdentifierType := resolveDeviceIdentifierType(deviceIdentifier, deviceIdentifierType)
-	callURL, _ := url.Parse(s.appEngineURL.String())
-	callURL.Path = path.Join(callURL.Path, fmt.Sprintf("/v1/%s/%s", realm, devicePath(deviceIdentifier, resolvedDeviceIdentifierType)))
 	deviceDetails := DeviceDetails{}
-	err := s.client.genericJSONDataAPIGET(&deviceDetails, callURL.String(), 200)
+	err := s.withDevicePath(realm, deviceIdentifier, deviceIdentifierType, func(deviceResourcePath string) error {
+		callURL, _ := url.Parse(s.appEngineURL.String())
+		callURL.Path = path.Join(callURL.Path, fmt.Sprintf("/v1/%s/%s", realm, deviceResourcePath))
+		return s.client.genericJSONDataAPIGET(&deviceDetails, callURL.String(), 200)
+	})
+	if err == nil {
+		s.cacheDeviceAliases(realm, deviceDetails)
+	}
 
 	return deviceDetails, err
 }
 
 // GetDeviceIDFromDeviceIdentifier returns the DeviceID of a Device identified with a deviceIdentifier
-// of type deviceIdentifierType.
+// of type deviceIdentifierType. With DeviceIDWithAliasFallbackResolution, the Device is retrieved to check
+// whether a valid Device ID is actually an alias.
 func (s *AppEngineService) GetDeviceIDFromDeviceIdentifier(realm string, deviceIdentifier string,
 	deviceIdentifierType DeviceIdentifierType) (string, error) {
-	resolvedDeviceIdentifierType := resolveDeviceIdentifierType(deviceIdentifier, deviceIdentifierType)
-	switch resolvedDeviceIdentifierType {
-	case AstarteDeviceAlias:
+	resolvedDeviceIdentifierType, fallback := s.resolveDeviceIdentifierType(deviceIdentifier, deviceIdentifierType)
+	switch {
+	case resolvedDeviceIdentifierType == AstarteDeviceAlias:
 		return s.GetDeviceIDFromAlias(realm, deviceIdentifier)
+	case fallback:
+		deviceDetails, err := s.GetDevice(realm, deviceIdentifier, deviceIdentifierType)
+		if err != nil {
+			return "", err
+		}
+		return deviceDetails.DeviceID, nil
 	default:
 		return deviceIdentifier, nil
 	}
 }
 
-// GetDeviceIDFromAlias returns the Device ID of a device given one of its aliases
+// GetDeviceIDFromAlias returns the Device ID of a device given one of its aliases. Aliases which were
+// already resolved are not retrieved again.
 func (s *AppEngineService) GetDeviceIDFromAlias(realm string, deviceAlias string) (string, error) {
+	if deviceID, ok := s.aliasCache.get(realm, deviceAlias); ok {
+		return deviceID, nil
+	}
 	deviceDetails, err := s.GetDevice(realm, deviceAlias, AstarteDeviceAlias)
 	if err != nil {
 		return "", err
@@ -141,11 +147,12 @@ func (s *AppEngineService) GetDeviceIDFromAlias(realm string, deviceAlias string
 // ListDeviceInterfaces returns the list of Interfaces exposed by the Device's introspection
 func (s *AppEngineService) ListDeviceInterfaces(realm string, deviceIdentifier string,
 	deviceIdentifierType DeviceIdentifierType) ([]string, error) {
-	resolvedDeviceIdentifierType := resolveDeviceIdentifierType(deviceIdentifier, deviceIdentifierType)
-	callURL, _ := url.Parse(s.appEngineURL.String())
-	callURL.Path = path.Join(callURL.Path, fmt.Sprintf("/v1/%s/%s/interfaces", realm, devicePath(deviceIdentifier, resolvedDeviceIdentifierType)))
 	deviceInterfacesList := []string{}
-	err := s.client.genericJSONDataAPIGET(&deviceInterfacesList, callURL.String(), 200)
+	err := s.withDevicePath(realm, deviceIdentifier, deviceIdentifierType, func(deviceResourcePath string) error {
+		callURL, _ := url.Parse(s.appEngineURL.String())
+		callURL.Path = path.Join(callURL.Path, fmt.Sprintf("/v1/%s/%s/interfaces", realm, deviceResourcePath))
+		return s.client.genericJSONDataAPIGET(&deviceInterfacesList, callURL.String(), 200)
+	})
 
 	return deviceInterfacesList, err
 }
@@ -168,6 +175,9 @@ func (s *AppEngineService) AddDeviceAlias(realm string, deviceID string, aliasTa
 	if err != nil {
 		return err
 	}
+	// The previous alias of aliasTag, if any, is not known
+	s.aliasCache.evictDevice(realm, deviceID)
+	s.aliasCache.set(realm, deviceAlias, deviceID)
 
 	return nil
 }
@@ -183,6 +193,7 @@ func (s *AppEngineService) DeleteDeviceAlias(realm string, deviceID string, alia
 	if err != nil {
 		return err
 	}
+	s.aliasCache.evictDevice(realm, deviceID)
 
 	return nil
 }
@@ -190,11 +201,12 @@ func (s *AppEngineService) DeleteDeviceAlias(realm string, deviceID string, alia
 // InhibitDevice sets the Credentials Inhibition state of a Device
 func (s *AppEngineService) InhibitDevice(realm string, deviceIdentifier string,
 	deviceIdentifierType DeviceIdentifierType, inhibit bool) error {
-	resolvedDeviceIdentifierType := resolveDeviceIdentifierType(deviceIdentifier, deviceIdentifierType)
-	callURL, _ := url.Parse(s.appEngineURL.String())
-	callURL.Path = path.Join(callURL.Path, fmt.Sprintf("/v1/%s/%s", realm, devicePath(deviceIdentifier, resolvedDeviceIdentifierType)))
 	payload := map[string]bool{"credentials_inhibited": inhibit}
-	err := s.client.genericJSONDataAPIPatch(callURL.String(), payload, 200)
+	err := s.withDevicePath(realm, deviceIdentifier, deviceIdentifierType, func(deviceResourcePath string) error {
+		callURL, _ := url.Parse(s.appEngineURL.String())
+		callURL.Path = path.Join(callURL.Path, fmt.Sprintf("/v1/%s/%s", realm, deviceResourcePath))
+		return s.client.genericJSONDataAPIPatch(callURL.String(), payload, 200)
+	})
 	if err != nil {
 		return err
 	}
@@ -223,11 +235,12 @@ func (s *AppEngineService) ListDeviceAttributes(realm, deviceIdentifier string,
 
 // SetDeviceAttribute sets an Attribute key to a certain value for a Device
 func (s *AppEngineService) SetDeviceAttribute(realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType, attributeKey, attributeValue string) error {
-	resolvedDeviceIdentifierType := resolveDeviceIdentifierType(deviceIdentifier, deviceIdentifierType)
-	callURL, _ := url.Parse(s.appEngineURL.String())
-	callURL.Path = path.Join(callURL.Path, fmt.Sprintf("/v1/%s/%s", realm, devicePath(deviceIdentifier, resolvedDeviceIdentifierType)))
 	payload := map[string]map[string]string{"attributes": {attributeKey: attributeValue}}
-	err := s.client.genericJSONDataAPIPatch(callURL.String(), payload, 200)
+	err := s.withDevicePath(realm, deviceIdentifier, deviceIdentifierType, func(deviceResourcePath string) error {
+		callURL, _ := url.Parse(s.appEngineURL.String())
+		callURL.Path = path.Join(callURL.Path, fmt.Sprintf("/v1/%s/%s", realm, deviceResourcePath))
+		return s.client.genericJSONDataAPIPatch(callURL.String(), payload, 200)
+	})
 	if err != nil {
 		return err
 	}
@@ -237,13 +250,14 @@ func (s *AppEngineService) SetDeviceAttribute(realm, deviceIdentifier string, de
 
 // DeleteDeviceAttribute deletes an Attribute key and its value from a Device
 func (s *AppEngineService) DeleteDeviceAttribute(realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType, attributeKey string) error {
-	resolvedDeviceIdentifierType := resolveDeviceIdentifierType(deviceIdentifier, deviceIdentifierType)
-	callURL, _ := url.Parse(s.appEngineURL.String())
-	callURL.Path = path.Join(callURL.Path, fmt.Sprintf("/v1/%s/%s", realm, devicePath(deviceIdentifier, resolvedDeviceIdentifierType)))
 	// We're using map[string]interface{} rather than map[string]string since we want to have null
 	// rather than an empty string in the JSON payload, and this is the only way.
 	payload := map[string]map[string]interface{}{"attributes": {attributeKey: nil}}
-	err := s.client.genericJSONDataAPIPatch(callURL.String(), payload, 200)
+	err := s.withDevicePath(realm, deviceIdentifier, deviceIdentifierType, func(deviceResourcePath string) error {
+		callURL, _ := url.Parse(s.appEngineURL.String())
+		callURL.Path = path.Join(callURL.Path, fmt.Sprintf("/v1/%s/%s", realm, deviceResourcePath))
+		return s.client.genericJSONDataAPIPatch(callURL.String(), payload, 200)
+	})
 	if err != nil {
 		return err
 	}
@@ -18,8 +18,13 @@ import (
 	"fmt"
 	"net/url"
 	"path"
+	"sort"
+	"strings"
+	"sync"
 )
 
+const defaultGroupConcurrency = 8
+
 // This file contains all API Calls related to device group management
 
 // ListGroups lists the groups in a Realm
@@ -32,22 +37,18 @@ func (s *AppEngineService) ListGroups(realm string) ([]string, error) {
 	return groupsList, err
 }
 
-// CreateGroup creates a group with the given deviceIdentifierList in the Realm
+// CreateGroup creates a group with the given deviceIdentifierList in the Realm. Device aliases are resolved in bulk.
 func (s *AppEngineService) CreateGroup(realm string, groupName string, deviceIdentifierList []string,
 	deviceIdentifiersType DeviceIdentifierType) error {
 
-	deviceIDList := make([]string, len(deviceIdentifierList))
-	for i, deviceIdentifier := range deviceIdentifierList {
-		deviceID, err := s.GetDeviceIDFromDeviceIdentifier(realm, deviceIdentifier, deviceIdentifiersType)
-		if err != nil {
-			return err
-		}
-		deviceIDList[i] = deviceID
+	deviceIDList, err := s.resolveDeviceIDs(realm, deviceIdentifierList, deviceIdentifiersType)
+	if err != nil {
+		return err
 	}
 	callURL, _ := url.Parse(s.appEngineURL.String())
 	callURL.Path = path.Join(callURL.Path, fmt.Sprintf("/v1/%s/groups", realm))
 	payload := map[string]interface{}{"group_name": groupName, "devices": deviceIDList}
-	err := s.client.genericJSONDataAPIPost(callURL.String(), payload, 201)
+	err = s.client.genericJSONDataAPIPost(callURL.String(), payload, 201)
 	if err != nil {
 		return err
 	}
@@ -55,14 +56,62 @@ func (s *AppEngineService) CreateGroup(realm string, groupName string, deviceIde
 	return nil
 }
 
-// ListGroupDevices lists the devices that belong to a group
-func (s *AppEngineService) ListGroupDevices(realm string, groupName string) ([]string, error) {
+// DeleteGroup deletes a group from the Realm. The devices in the group are not affected.
+func (s *AppEngineService) DeleteGroup(realm string, groupName string) error {
 	callURL, _ := url.Parse(s.appEngineURL.String())
+	callURL.Path = path.Join(callURL.Path, fmt.Sprintf("/v1/%s/groups/%s", realm, url.PathEscape(groupName)))
+	return s.client.genericJSONDataAPIDelete(callURL.String(), 204)
+}
+
+// GetGroupDeviceListPaginator returns a Paginator for all the Devices in a group.
+// The paginator can return different result formats depending on the format
+// parameter.
+func (s *AppEngineService) GetGroupDeviceListPaginator(realm string, groupName string, pageSize int, format DeviceResultFormat) (DeviceListPaginator, error) {
+	callURL, err := url.Parse(s.appEngineURL.String())
+	if err != nil {
+		return DeviceListPaginator{}, err
+	}
 	callURL.Path = path.Join(callURL.Path, fmt.Sprintf("/v1/%s/groups/%s/devices", realm, url.PathEscape(groupName)))
+
+	return newDeviceListPaginator(s.client, callURL, pageSize, format), nil
+}
+
+// ListGroupDevices lists the devices that belong to a group
+func (s *AppEngineService) ListGroupDevices(realm string, groupName string) ([]string, error) {
+	paginator, err := s.GetGroupDeviceListPaginator(realm, groupName, defaultPageSize, DeviceIDFormat)
+	if err != nil {
+		return nil, err
+	}
+
 	groupDevicesList := []string{}
-	err := s.client.genericJSONDataAPIGET(&groupDevicesList, callURL.String(), 200)
+	for paginator.HasNextPage() {
+		page := []string{}
+		if err := paginator.GetNextPage(&page); err != nil {
+			return nil, err
+		}
+		groupDevicesList = append(groupDevicesList, page...)
+	}
 
-	return groupDevicesList, err
+	return groupDevicesList, nil
+}
+
+// ListGroupDevicesDetails lists the devices that belong to a group, with their details
+func (s *AppEngineService) ListGroupDevicesDetails(realm string, groupName string) ([]DeviceDetails, error) {
+	paginator, err := s.GetGroupDeviceListPaginator(realm, groupName, defaultPageSize, DeviceDetailsFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	groupDevicesList := []DeviceDetails{}
+	for paginator.HasNextPage() {
+		page := []DeviceDetails{}
+		if err := paginator.GetNextPage(&page); err != nil {
+			return nil, err
+		}
+		groupDevicesList = append(groupDevicesList, page...)
+	}
+
+	return groupDevicesList, nil
 }
 
 // AddDeviceToGroup adds a device to the group
@@ -99,3 +148,146 @@ func (s *AppEngineService) RemoveDeviceFromGroup(realm string, groupName string,
 
 	return nil
 }
+
+// AddDevicesToGroup adds many devices to the group, concurrently. Device aliases are resolved in bulk. All devices
+// are processed even if some of them fail: in that case, a DeviceErrors is returned, keyed by device identifier.
+func (s *AppEngineService) AddDevicesToGroup(realm string, groupName string, deviceIdentifierList []string,
+	deviceIdentifierType DeviceIdentifierType) error {
+	s.cacheDeviceAliasesInBulk(realm, deviceIdentifierList, deviceIdentifierType)
+	return forEachDevice(deviceIdentifierList, defaultGroupConcurrency, func(deviceIdentifier string) error {
+		return s.AddDeviceToGroup(realm, groupName, deviceIdentifier, deviceIdentifierType)
+	})
+}
+
+// RemoveDevicesFromGroup removes many devices from the group, concurrently. Device aliases are resolved in bulk.
+// All devices are processed even if some of them fail: in that case, a DeviceErrors is returned, keyed by device
+// identifier.
+func (s *AppEngineService) RemoveDevicesFromGroup(realm string, groupName string, deviceIdentifierList []string,
+	deviceIdentifierType DeviceIdentifierType) error {
+	s.cacheDeviceAliasesInBulk(realm, deviceIdentifierList, deviceIdentifierType)
+	return forEachDevice(deviceIdentifierList, defaultGroupConcurrency, func(deviceIdentifier string) error {
+		return s.RemoveDeviceFromGroup(realm, groupName, deviceIdentifier, deviceIdentifierType)
+	})
+}
+
+// DeviceErrors is returned by operations on many devices when some of them fail. It maps the identifier
+// of each failed device to its error.
+type DeviceErrors map[string]error
+
+func (e DeviceErrors) Error() string {
+	deviceIdentifiers := []string{}
+	for deviceIdentifier := range e {
+		deviceIdentifiers = append(deviceIdentifiers, deviceIdentifier)
+	}
+	sort.Strings(deviceIdentifiers)
+
+	errs := []string{}
+	for _, deviceIdentifier := range deviceIdentifiers {
+		errs = append(errs, fmt.Sprintf("%s: %v", deviceIdentifier, e[deviceIdentifier]))
+	}
+	return fmt.Sprintf("%d devices failed: %s", len(e), strings.Join(errs, "; "))
+}
+
+// forEachDevice calls f for each device identifier, with at most concurrency concurrent calls, and returns
+// a DeviceErrors if any call fails
+func forEachDevice(deviceIdentifierList []string, concurrency int, f func(string) error) error {
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	errs := DeviceErrors{}
+	semaphore := make(chan struct{}, concurrency)
+	for _, deviceIdentifier := range deviceIdentifierList {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(deviceIdentifier string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			if err := f(deviceIdentifier); err != nil {
+				lock.Lock()
+				errs[deviceIdentifier] = err
+				lock.Unlock()
+			}
+		}(deviceIdentifier)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// resolveDeviceIDs returns the Device IDs of deviceIdentifierList, in the same order. Aliases are resolved in bulk,
+// and the ones which are still missing concurrently.
+func (s *AppEngineService) resolveDeviceIDs(realm string, deviceIdentifierList []string, deviceIdentifierType DeviceIdentifierType) ([]string, error) {
+	s.cacheDeviceAliasesInBulk(realm, deviceIdentifierList, deviceIdentifierType)
+	deviceIDs := map[string]string{}
+	var lock sync.Mutex
+	err := forEachDevice(uniqueStrings(deviceIdentifierList), defaultGroupConcurrency, func(deviceIdentifier string) error {
+		deviceID, err := s.GetDeviceIDFromDeviceIdentifier(realm, deviceIdentifier, deviceIdentifierType)
+		if err != nil {
+			return err
+		}
+		lock.Lock()
+		deviceIDs[deviceIdentifier] = deviceID
+		lock.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	deviceIDList := make([]string, len(deviceIdentifierList))
+	for i, deviceIdentifier := range deviceIdentifierList {
+		deviceIDList[i] = deviceIDs[deviceIdentifier]
+	}
+	return deviceIDList, nil
+}
+
+func uniqueStrings(list []string) []string {
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			unique = append(unique, s)
+		}
+	}
+	return unique
+}
+
+// cacheDeviceAliasesInBulk adds the aliases among deviceIdentifierList to the alias cache, with a single sweep of the
+// Device list when more than one of them is not cached already. Aliases which are not found, or all of them if the
+// sweep fails, are left to be resolved one by one.
+func (s *AppEngineService) cacheDeviceAliasesInBulk(realm string, deviceIdentifierList []string, deviceIdentifierType DeviceIdentifierType) {
+	missing := map[string]bool{}
+	for _, deviceIdentifier := range deviceIdentifierList {
+		resolvedDeviceIdentifierType, fallback := s.resolveDeviceIdentifierType(deviceIdentifier, deviceIdentifierType)
+		if resolvedDeviceIdentifierType != AstarteDeviceAlias && !fallback {
+			continue
+		}
+		if _, ok := s.aliasCache.get(realm, deviceIdentifier); !ok {
+			missing[deviceIdentifier] = true
+		}
+	}
+	if len(missing) < 2 {
+		return
+	}
+
+	paginator, err := s.GetDeviceListPaginator(realm, defaultPageSize, DeviceDetailsFormat)
+	if err != nil {
+		return
+	}
+	for paginator.HasNextPage() {
+		page := []DeviceDetails{}
+		if err := paginator.GetNextPage(&page); err != nil {
+			return
+		}
+		for _, device := range page {
+			for _, alias := range device.Aliases {
+				if missing[alias] {
+					s.aliasCache.set(realm, alias, device.DeviceID)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCreateGroupResolvesAliases(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	err := client.AppEngine.CreateGroup(testRealmName, testGroupName, []string{"sensor-b", testDevices[2], "sensor-a", "sensor-b"}, AutodiscoverDeviceIdentifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := popTestWriteRequests()
+	if len(requests) != 1 {
+		t.Fatalf("unexpected requests %v", requests)
+	}
+	payload := requests[0].Data.(map[string]interface{})
+	expected := []interface{}{testDevices[1], testDevices[2], testDevices[0], testDevices[1]}
+	if payload["group_name"] != testGroupName || !reflect.DeepEqual(payload["devices"], expected) {
+		t.Errorf("unexpected payload %v", payload)
+	}
+
+	if err := client.AppEngine.CreateGroup(testRealmName, testGroupName, []string{"sensor-a", "missing"}, AstarteDeviceAlias); err == nil {
+		t.Error("group with an unknown alias was created")
+	}
+}
+
+func TestGroupDeviceListPaginator(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	paginator, err := client.AppEngine.GetGroupDeviceListPaginator(testRealmName, testGroupName, 2, DeviceDetailsFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices := []string{}
+	for paginator.HasNextPage() {
+		page := []DeviceDetails{}
+		if err := paginator.GetNextPage(&page); err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range page {
+			devices = append(devices, d.DeviceID)
+		}
+	}
+	if !reflect.DeepEqual(devices, testDevices) {
+		t.Errorf("unexpected devices %v", devices)
+	}
+
+	ids, err := client.AppEngine.ListGroupDevices(testRealmName, testGroupName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, testDevices) {
+		t.Errorf("unexpected devices %v", ids)
+	}
+}
+
+func TestBulkGroupMembership(t *testing.T) {
+	var lock sync.Mutex
+	aliasRequests, listRequests := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		switch {
+		case strings.Contains(req.URL.Path, "/devices-by-alias/"):
+			aliasRequests++
+		case req.URL.Path == fmt.Sprintf("/appengine/v1/%s/devices", testRealmName) && req.URL.Query().Get("from_token") == "":
+			listRequests++
+		}
+		lock.Unlock()
+		astarteAPIMock(w, req)
+	}))
+	defer server.Close()
+	client, err := NewClient(server.URL, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken(testTokenValue)
+	popTestWriteRequests()
+
+	// Aliases are resolved with a single sweep of the Device list, and only the missing one is retrieved by alias
+	err = client.AppEngine.AddDevicesToGroup(testRealmName, testGroupName, []string{"sensor-a", "sensor-b", "missing"}, AstarteDeviceAlias)
+	deviceErrors, ok := err.(DeviceErrors)
+	if !ok || len(deviceErrors) != 1 || deviceErrors["missing"] == nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if requests := popTestWriteRequests(); len(requests) != 2 {
+		t.Errorf("unexpected requests %v", requests)
+	}
+	if listRequests != 1 || aliasRequests != 1 {
+		t.Errorf("%d device list sweeps and %d alias requests", listRequests, aliasRequests)
+	}
+
+	if err := client.AppEngine.RemoveDevicesFromGroup(testRealmName, testGroupName, testDevices, AstarteDeviceID); err != nil {
+		t.Fatal(err)
+	}
+	if requests := popTestWriteRequests(); len(requests) != len(testDevices) {
+		t.Errorf("unexpected requests %v", requests)
+	}
+
+	if err := client.AppEngine.DeleteGroup(testRealmName, testGroupName); err != nil {
+		t.Fatal(err)
+	}
+	requests := popTestWriteRequests()
+	if len(requests) != 1 || requests[0].Method != "DELETE" || requests[0].Path != fmt.Sprintf("/appengine/v1/%s/groups/%s", testRealmName, testGroupName) {
+		t.Errorf("unexpected requests %v", requests)
+	}
+}
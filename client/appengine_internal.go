@@ -129,21 +129,38 @@ func parseAggregateDatastreamMap(aMap orderedmap.OrderedMap, completeKeyPath str
 
 func parseAggregateDatastreamValue(aMap orderedmap.OrderedMap) (DatastreamAggregateValue, error) {
 	// Ensure some type safety
-	var timestamp time.Time
 	timestampInterface, _ := aMap.Get("timestamp")
-	switch t := timestampInterface.(type) {
-	case time.Time:
-		timestamp = t
-	case string:
-		var err error
-		timestamp, err = time.Parse(time.RFC3339Nano, t)
-		if err != nil {
+	timestamp, err := parseTimestampValue(timestampInterface)
+	if err != nil {
+		return DatastreamAggregateValue{}, err
+	}
+	aMap.Delete("timestamp")
+
+	var receptionTimestamp time.Time
+	if receptionTimestampInterface, ok := aMap.Get("reception_timestamp"); ok {
+		if receptionTimestamp, err = parseTimestampValue(receptionTimestampInterface); err != nil {
 			return DatastreamAggregateValue{}, err
 		}
+		aMap.Delete("reception_timestamp")
 	}
 
-	aMap.Delete("timestamp")
-	return DatastreamAggregateValue{Values: aMap, Timestamp: timestamp}, nil
+	return DatastreamAggregateValue{Values: aMap, Timestamp: timestamp, ReceptionTimestamp: receptionTimestamp}, nil
+}
+
+// parseTimestampValue parses a timestamp returned by AppEngine, either as a RFC3339 string or as milliseconds
+// since the epoch. A nil timestamp is parsed as a zero time.
+func parseTimestampValue(timestampInterface interface{}) (time.Time, error) {
+	switch t := timestampInterface.(type) {
+	case nil:
+		return time.Time{}, nil
+	case time.Time:
+		return t, nil
+	case string:
+		return time.Parse(time.RFC3339Nano, t)
+	case float64:
+		return time.Unix(0, int64(t)*int64(time.Millisecond)).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %v", timestampInterface)
 }
 
 func parseDatastreamMap(aMap map[string]interface{}, completeKeyPath string) (map[string]DatastreamValue, error) {
@@ -18,7 +18,6 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -142,18 +141,30 @@ func NewClientWithIndividualURLs(individualURLs map[misc.AstarteService]string,
 	return c, nil
 }
 
-func errorFromJSONErrors(responseBody io.Reader) error {
+// APIError is returned when an Astarte API replies with an unexpected status code. Errors contains the
+// errors reported in the reply, if any.
+type APIError struct {
+	StatusCode int
+	Errors     map[string]interface{}
+	message    string
+}
+
+func (e *APIError) Error() string {
+	return e.message
+}
+
+func errorFromJSONErrors(statusCode int, responseBody io.Reader) error {
 	var errorBody struct {
 		Errors map[string]interface{} `json:"errors"`
 	}
 
 	err := json.NewDecoder(responseBody).Decode(&errorBody)
 	if err != nil {
-		return err
+		return &APIError{StatusCode: statusCode, message: err.Error()}
 	}
 
 	errJSON, _ := json.MarshalIndent(&errorBody, "", "  ")
-	return fmt.Errorf("%s", errJSON)
+	return &APIError{StatusCode: statusCode, Errors: errorBody.Errors, message: string(errJSON)}
 }
 
 // SetTokenFromPrivateKeyFile generates a token from the supplied private key file and uses it for the session.
@@ -229,6 +240,14 @@ func (c *Client) genericJSONDataAPIGET(ret interface{}, urlString string, expect
 }
 
 func (c *Client) genericJSONDataAPIGETWithLinks(ret interface{}, retLinks *Links, urlString string, expectedReturnCode int) error {
+	return c.genericJSONDataAPIGETWithEnvelope(ret, retLinks, nil, urlString, expectedReturnCode)
+}
+
+func (c *Client) genericJSONDataAPIGETWithMetadata(ret interface{}, retMetadata interface{}, urlString string, expectedReturnCode int) error {
+	return c.genericJSONDataAPIGETWithEnvelope(ret, nil, retMetadata, urlString, expectedReturnCode)
+}
+
+func (c *Client) genericJSONDataAPIGETWithEnvelope(ret interface{}, retLinks *Links, retMetadata interface{}, urlString string, expectedReturnCode int) error {
 	req, err := http.NewRequest("GET", urlString, nil)
 	if err != nil {
 		return err
@@ -237,7 +256,7 @@ func (c *Client) genericJSONDataAPIGETWithLinks(ret interface{}, retLinks *Links
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("User-Agent", c.UserAgent)
 
-	return c.doJSONAPIReqWithLinks(ret, retLinks, req, expectedReturnCode)
+	return c.doJSONAPIReqWithEnvelope(ret, retLinks, retMetadata, req, expectedReturnCode)
 }
 
 func (c *Client) genericJSONDataAPIPost(urlString string, dataPayload interface{}, expectedReturnCode int) error {
@@ -288,10 +307,19 @@ func (c *Client) genericJSONDataAPIWrite(ret interface{}, httpVerb string, urlSt
 
 func (c *Client) genericJSONDataAPIWriteWithContentType(ret interface{}, httpVerb string, urlString string, dataPayload interface{},
 	contentType string, expectedReturnCode int) error {
+	return c.genericJSONDataAPIWriteWithTimestamp(ret, httpVerb, urlString, dataPayload, nil, contentType, expectedReturnCode)
+}
+
+// genericJSONDataAPIWriteWithTimestamp works like genericJSONDataAPIWriteWithContentType, adding timestamp to the
+// request body if it is not nil
+func (c *Client) genericJSONDataAPIWriteWithTimestamp(ret interface{}, httpVerb string, urlString string, dataPayload interface{},
+	timestamp *time.Time, contentType string, expectedReturnCode int) error {
 	var requestBody struct {
-		Data interface{} `json:"data"`
+		Data      interface{} `json:"data"`
+		Timestamp *time.Time  `json:"timestamp,omitempty"`
 	}
 	requestBody.Data = dataPayload
+	requestBody.Timestamp = timestamp
 
 	b := new(bytes.Buffer)
 	err := json.NewEncoder(b).Encode(requestBody)
@@ -327,6 +355,10 @@ func (c *Client) doJSONAPIReq(ret interface{}, req *http.Request, expectedReturn
 }
 
 func (c *Client) doJSONAPIReqWithLinks(ret interface{}, retLinks *Links, req *http.Request, expectedReturnCode int) error {
+	return c.doJSONAPIReqWithEnvelope(ret, retLinks, nil, req, expectedReturnCode)
+}
+
+func (c *Client) doJSONAPIReqWithEnvelope(ret interface{}, retLinks *Links, retMetadata interface{}, req *http.Request, expectedReturnCode int) error {
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return err
@@ -334,7 +366,7 @@ func (c *Client) doJSONAPIReqWithLinks(ret interface{}, retLinks *Links, req *ht
 	defer resp.Body.Close()
 
 	if resp.StatusCode != expectedReturnCode {
-		return errorFromJSONErrors(resp.Body)
+		return errorFromJSONErrors(resp.StatusCode, resp.Body)
 	}
 
 	// If we don't want the reply, discard the body and return
@@ -344,34 +376,48 @@ func (c *Client) doJSONAPIReqWithLinks(ret interface{}, retLinks *Links, req *ht
 	}
 
 	// Parse the payload as we should. This means we have to look for the
-	// "data" enclosure for data and "links" for links.
-	decoder := json.NewDecoder(resp.Body)
-
-	foundData := false
-	// We initialize it like this so it's already true if retLinks is nil
-	foundLinks := retLinks == nil
-	// Iterate until we decode data and links (if we need them). If we find
-	// something wrong, return an error
+	// "data" enclosure for data, "links" for links and "metadata" for metadata.
+	targets := map[string]interface{}{"data": ret, "links": nil, "metadata": nil}
+	if retLinks != nil {
+		targets["links"] = retLinks
+	}
+	if retMetadata != nil {
+		targets["metadata"] = retMetadata
+	}
+	return decodeJSONEnvelope(resp.Body, targets)
+}
+
+// decodeJSONEnvelope decodes the members of the JSON object in body into the non-nil targets with the same key,
+// skipping the members with a nil target. All non-nil targets must be found.
+func decodeJSONEnvelope(body io.Reader, targets map[string]interface{}) error {
+	missing := map[string]bool{}
+	for key, target := range targets {
+		if target != nil {
+			missing[key] = true
+		}
+	}
+
+	decoder := json.NewDecoder(body)
+	// Iterate until we decode all targets. If we find something wrong, return an error
 	for t, err := decoder.Token(); err != io.EOF; t, err = decoder.Token() {
 		if err != nil {
 			// Errors in decoding, return
 			return err
 		}
-
-		switch t {
-		case "data":
-			if err = decoder.Decode(ret); err != nil {
-				return err
-			}
-			foundData = true
-		case "links", retLinks != nil:
-			if err = decoder.Decode(retLinks); err != nil {
-				return err
-			}
-			foundLinks = true
+		key, isString := t.(string)
+		target, isTarget := targets[key]
+		if !isString || !isTarget {
+			continue
 		}
-
-		if foundData && foundLinks {
+		if target == nil {
+			// Skip the member, so that its content is not mistaken for other members
+			target = &json.RawMessage{}
+		}
+		if err := decoder.Decode(target); err != nil {
+			return err
+		}
+		delete(missing, key)
+		if len(missing) == 0 {
 			// We're done
 			return nil
 		}
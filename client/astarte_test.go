@@ -19,7 +19,13 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 const (
@@ -96,8 +102,157 @@ var testInterfaces map[string]string = map[string]string{
 	}`,
 }
 
+var testTriggers map[string]string = map[string]string{
+	"test-trigger": `{
+		"name": "test-trigger",
+		"action": {"http_url": "https://example.com/hook", "http_method": "post", "ignore_ssl_errors": false},
+		"simple_triggers": [
+			{
+				"type": "data_trigger",
+				"on": "incoming_data",
+				"interface_name": "org.astarte-platform.genericsensors.Values",
+				"interface_major": 0,
+				"match_path": "/%{sensor_id}/value",
+				"value_match_operator": ">",
+				"known_value": 0
+			}
+		]
+	}`,
+}
+
+var testDeliveryPolicies map[string]string = map[string]string{
+	"test-policy": `{
+		"name": "test-policy",
+		"error_handlers": [
+			{"on": "server_error", "strategy": "retry"},
+			{"on": [404, 410], "strategy": "discard"}
+		],
+		"maximum_capacity": 100,
+		"retry_times": 3,
+		"event_ttl": 60
+	}`,
+}
+
+const testGroupName = "test-group"
+
 var testDevices []string = []string{"1vMeFtaJQF259nMsnis3sw", "t1J1uQSBQRi_1F3zIrjyYw", "V_pY-ZrLQzWz4iGjGu-NuQ"}
 
+// testDeviceDetails returns the details of testDevices: the first one is connected, the second one is disconnected
+// and has inhibited credentials, the third one never connected
+func testDeviceDetails() []DeviceDetails {
+	lastSeen := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	return []DeviceDetails{
+		{
+			DeviceID:              testDevices[0],
+			Connected:             true,
+			LastConnection:        lastSeen,
+			TotalReceivedMessages: 100,
+			TotalReceivedBytes:    4000,
+			Introspection: map[string]DeviceInterfaceIntrospection{
+				testAggregateInterfaceName: {Major: 0, Minor: 1, ExchangedMessages: 90, ExchangedBytes: 3600},
+			},
+			Attributes: map[string]string{"site": "north"},
+			Aliases:    map[string]string{"name": "sensor-a"},
+			Groups:     []string{testGroupName},
+		},
+		{
+			DeviceID:              testDevices[1],
+			CredentialsInhibited:  true,
+			LastConnection:        lastSeen.Add(-time.Hour),
+			LastDisconnection:     lastSeen,
+			TotalReceivedMessages: 200,
+			TotalReceivedBytes:    1000,
+			Introspection: map[string]DeviceInterfaceIntrospection{
+				testAggregateInterfaceName: {Major: 1, Minor: 0, ExchangedMessages: 200, ExchangedBytes: 1000},
+			},
+			Attributes: map[string]string{"site": "south"},
+			Aliases:    map[string]string{"name": "sensor-b"},
+		},
+		{
+			DeviceID:          testDevices[2],
+			FirstRegistration: lastSeen.Add(-24 * time.Hour),
+			Attributes:        map[string]string{"site": "north"},
+		},
+	}
+}
+
+const testAggregateInterfaceName = "org.astarte-platform.genericsensors.Geolocation"
+
+var testAggregateSamplesStart = time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+
+// testAggregateSamples returns count ascending samples, one every minute starting from testAggregateSamplesStart
+func testAggregateSamples(count int) []map[string]interface{} {
+	samples := []map[string]interface{}{}
+	for i := 0; i < count; i++ {
+		samples = append(samples, map[string]interface{}{
+			"latitude":            float64(i),
+			"longitude":           float64(-i),
+			"timestamp":           testAggregateSamplesStart.Add(time.Duration(i) * time.Minute).Format(time.RFC3339Nano),
+			"reception_timestamp": testAggregateSamplesStart.Add(time.Duration(i)*time.Minute + time.Second).Format(time.RFC3339Nano),
+		})
+	}
+	return samples
+}
+
+// aggregateDatastreamMock mimics AppEngine's windowing and paging semantics over testAggregateSamples
+func aggregateDatastreamMock(w http.ResponseWriter, req *http.Request) {
+	samples := testAggregateSamples(25)
+	query := req.URL.Query()
+	parseTime := func(key string) (time.Time, bool) {
+		if query.Get(key) == "" {
+			return time.Time{}, false
+		}
+		t, _ := time.Parse(time.RFC3339Nano, query.Get(key))
+		return t, true
+	}
+
+	filtered := []map[string]interface{}{}
+	for _, sample := range samples {
+		timestamp, _ := time.Parse(time.RFC3339Nano, sample["timestamp"].(string))
+		if since, ok := parseTime("since"); ok && timestamp.Before(since) {
+			continue
+		}
+		if sinceAfter, ok := parseTime("since_after"); ok && !timestamp.After(sinceAfter) {
+			continue
+		}
+		if to, ok := parseTime("to"); ok && !timestamp.Before(to) {
+			continue
+		}
+		filtered = append(filtered, sample)
+	}
+
+	if limit, err := strconv.Atoi(query.Get("limit")); err == nil {
+		// limit returns the newest samples, in descending order
+		sort.Slice(filtered, func(i, j int) bool { return filtered[i]["timestamp"].(string) > filtered[j]["timestamp"].(string) })
+		if len(filtered) > limit {
+			filtered = filtered[:limit]
+		}
+	} else if pageSize, err := strconv.Atoi(query.Get("page_size")); err == nil && len(filtered) > pageSize {
+		filtered = filtered[:pageSize]
+	}
+
+	switch query.Get("format") {
+	case "table":
+		header := []string{"timestamp", "latitude", "longitude"}
+		rows := [][]interface{}{}
+		for _, sample := range filtered {
+			rows = append(rows, []interface{}{sample["timestamp"], sample["latitude"], sample["longitude"]})
+		}
+		metadata := map[string]interface{}{"columns": map[string]int{"timestamp": 0, "latitude": 1, "longitude": 2}, "table_header": header}
+		json.NewEncoder(w).Encode(map[string]interface{}{"metadata": metadata, "data": rows})
+	case "disjoint_tables":
+		tables := map[string][][]interface{}{}
+		for _, sample := range filtered {
+			for _, leaf := range []string{"latitude", "longitude"} {
+				tables[leaf] = append(tables[leaf], []interface{}{sample[leaf], sample["timestamp"]})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": tables})
+	default:
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": filtered})
+	}
+}
+
 func astarteAPIMock(w http.ResponseWriter, req *http.Request) {
 	authorization := req.Header.Get("Authorization")
 	if len(authorization) <= 0 {
@@ -110,17 +265,189 @@ func astarteAPIMock(w http.ResponseWriter, req *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	// Process request
+	realmManagementInterfacesPath := fmt.Sprintf("/realmmanagement/v1/%s/interfaces", testRealmName)
+	realmManagementTriggersPath := fmt.Sprintf("/realmmanagement/v1/%s/triggers", testRealmName)
+	realmManagementPoliciesPath := fmt.Sprintf("/realmmanagement/v1/%s/policies", testRealmName)
 	switch {
+	case req.Method == http.MethodGet && req.URL.Path == realmManagementInterfacesPath:
+		names := []string{}
+		for name := range testInterfaces {
+			names = append(names, name)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": names})
+	case req.Method == http.MethodGet && strings.HasPrefix(req.URL.Path, realmManagementInterfacesPath+"/"):
+		realmManagementInterfaceMock(w, strings.TrimPrefix(req.URL.Path, realmManagementInterfacesPath+"/"))
+	case req.Method == http.MethodGet && strings.HasPrefix(req.URL.Path, realmManagementTriggersPath):
+		jsonResourceMock(w, testTriggers, strings.TrimPrefix(req.URL.Path, realmManagementTriggersPath))
+	case req.Method == http.MethodGet && strings.HasPrefix(req.URL.Path, realmManagementPoliciesPath):
+		jsonResourceMock(w, testDeliveryPolicies, strings.TrimPrefix(req.URL.Path, realmManagementPoliciesPath))
+	case req.Method == http.MethodPost || req.Method == http.MethodPut || req.Method == http.MethodPatch || req.Method == http.MethodDelete:
+		recordWriteRequest(w, req)
 	case req.URL.Path == fmt.Sprintf("/appengine/v1/%s/devices", testRealmName):
-		links := map[string]string{"self": fmt.Sprintf("/v1/%s/devices", testRealmName)}
-		reply := map[string]interface{}{"data": testDevices, "links": links}
-		json.NewEncoder(w).Encode(reply)
+		deviceListMock(w, req, fmt.Sprintf("/v1/%s/devices", testRealmName))
+	case req.URL.Path == fmt.Sprintf("/appengine/v1/%s/stats/devices", testRealmName):
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": DevicesStats{TotalDevices: int64(len(testDevices)), ConnectedDevices: 1}})
+	case req.URL.Path == fmt.Sprintf("/appengine/v1/%s/groups", testRealmName):
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": []string{testGroupName}})
+	case req.URL.Path == fmt.Sprintf("/appengine/v1/%s/groups/%s/devices", testRealmName, testGroupName):
+		deviceListMock(w, req, fmt.Sprintf("/v1/%s/groups/%s/devices", testRealmName, testGroupName))
+	case strings.HasPrefix(req.URL.Path, fmt.Sprintf("/appengine/v1/%s/devices-by-alias/", testRealmName)):
+		deviceDetailsMock(w, func(d DeviceDetails) bool { return d.Aliases["name"] == path.Base(req.URL.Path) })
+	case strings.HasPrefix(req.URL.Path, fmt.Sprintf("/appengine/v1/%s/devices/", testRealmName)) && strings.Count(req.URL.Path, "/") == 5:
+		deviceDetailsMock(w, func(d DeviceDetails) bool { return d.DeviceID == path.Base(req.URL.Path) })
+	case req.URL.Path == fmt.Sprintf("/appengine/v1/%s/devices/%s/interfaces/%s/gps", testRealmName, testDevices[0], testAggregateInterfaceName):
+		aggregateDatastreamMock(w, req)
+	}
+}
+
+// jsonResourceMock serves the names of resources if resourcePath is empty, or the resource named by resourcePath
+func jsonResourceMock(w http.ResponseWriter, resources map[string]string, resourcePath string) {
+	if resourcePath == "" {
+		names := []string{}
+		for name := range resources {
+			names = append(names, name)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": names})
+		return
 	}
+	resource, ok := resources[strings.TrimPrefix(resourcePath, "/")]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": map[string]string{"detail": "Not found"}})
+		return
+	}
+	fmt.Fprintf(w, `{"data": %s}`, resource)
+}
+
+// testDeviceListPageSize is the page size of deviceListMock when no limit is given, small enough to page testDevices
+const testDeviceListPageSize = 2
+
+// deviceListMock mimics AppEngine's paging over testDevices, using the index of the next device as token
+func deviceListMock(w http.ResponseWriter, req *http.Request, selfPath string) {
+	query := req.URL.Query()
+	from, _ := strconv.Atoi(query.Get("from_token"))
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil {
+		limit = testDeviceListPageSize
+	}
+	to := len(testDevices)
+	if from+limit < to {
+		to = from + limit
+	}
+
+	links := map[string]string{"self": selfPath}
+	if to < len(testDevices) {
+		query.Set("from_token", strconv.Itoa(to))
+		links["next"] = selfPath + "?" + query.Encode()
+	}
+	reply := map[string]interface{}{"data": testDevices[from:to], "links": links}
+	if query.Get("details") == "true" {
+		reply["data"] = testDeviceDetails()[from:to]
+	}
+	json.NewEncoder(w).Encode(reply)
+}
+
+func deviceDetailsMock(w http.ResponseWriter, match func(DeviceDetails) bool) {
+	for _, d := range testDeviceDetails() {
+		if match(d) {
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": d})
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(map[string]interface{}{"errors": map[string]string{"detail": "Device not found"}})
+}
+
+func realmManagementInterfaceMock(w http.ResponseWriter, interfacePath string) {
+	tokens := strings.Split(interfacePath, "/")
+	iface, ok := testInterfaces[tokens[0]]
+	switch {
+	case !ok:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": map[string]string{"detail": "Not found"}})
+	case len(tokens) == 1:
+		parsed := map[string]interface{}{}
+		json.Unmarshal([]byte(iface), &parsed)
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": []interface{}{parsed["version_major"]}})
+	default:
+		fmt.Fprintf(w, `{"data": %s}`, iface)
+	}
+}
+
+// testWriteRequest is a write request received by the mock
+type testWriteRequest struct {
+	Method    string
+	Path      string
+	Data      interface{}
+	Timestamp string
+}
+
+var (
+	testWriteRequests      []testWriteRequest
+	testWriteRequestsMutex sync.Mutex
+)
+
+func recordWriteRequest(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Data      interface{} `json:"data"`
+		Timestamp string      `json:"timestamp"`
+	}
+	json.NewDecoder(req.Body).Decode(&body)
+	testWriteRequestsMutex.Lock()
+	testWriteRequests = append(testWriteRequests, testWriteRequest{Method: req.Method, Path: req.URL.Path, Data: body.Data,
+		Timestamp: body.Timestamp})
+	testWriteRequestsMutex.Unlock()
+
+	switch {
+	case req.Method == http.MethodPatch && !isTestDevicePath(req.URL.Path):
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": map[string]string{"detail": "Device not found"}})
+	case req.Method == http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+	case req.Method == http.MethodPut && strings.HasPrefix(req.URL.Path, "/realmmanagement"):
+		// RealmManagement updates return no content
+		w.WriteHeader(http.StatusNoContent)
+	case req.Method == http.MethodPost:
+		if strings.HasPrefix(req.URL.Path, "/appengine") && strings.Contains(req.URL.Path, "/interfaces/") {
+			// Sending datastreams returns 200
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": body.Data})
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": body.Data})
+	default:
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": body.Data})
+	}
+}
+
+// isTestDevicePath returns whether urlPath is the path of one of testDevices, by Device ID or alias
+func isTestDevicePath(urlPath string) bool {
+	for _, deviceDetails := range testDeviceDetails() {
+		if urlPath == fmt.Sprintf("/appengine/v1/%s/devices/%s", testRealmName, deviceDetails.DeviceID) {
+			return true
+		}
+		for _, alias := range deviceDetails.Aliases {
+			if urlPath == fmt.Sprintf("/appengine/v1/%s/devices-by-alias/%s", testRealmName, alias) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// popTestWriteRequests returns all write requests received by the mock, and resets them
+func popTestWriteRequests() []testWriteRequest {
+	testWriteRequestsMutex.Lock()
+	defer testWriteRequestsMutex.Unlock()
+	requests := testWriteRequests
+	testWriteRequests = nil
+	return requests
 }
 
 func getTestContext(t *testing.T) (*Client, *httptest.Server) {
 	// Start a local HTTP server
 	server := httptest.NewServer(http.HandlerFunc(astarteAPIMock))
+	popTestWriteRequests()
 
 	// Use Client & URL from our local test server
 	client, err := NewClient(server.URL, server.Client())
@@ -0,0 +1,407 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/astarte-platform/astarte-go/interfaces"
+)
+
+const defaultImportConcurrency = 8
+
+// DataImportFormat represents the input format of a data import.
+type DataImportFormat int
+
+const (
+	// JSONLinesImportFormat reads one JSON ImportRecord per line.
+	JSONLinesImportFormat DataImportFormat = iota
+	// CSVImportFormat reads CSV records with a header containing the device, interface, path, value and,
+	// optionally, timestamp columns. Aggregates and arrays must be JSON-encoded in the value column.
+	CSVImportFormat
+)
+
+// ImportRecord represents a single value to be imported into a server-owned Interface.
+type ImportRecord struct {
+	// Device is the identifier of the Device, either its Device ID or one of its aliases.
+	Device    string      `json:"device"`
+	Interface string      `json:"interface"`
+	Path      string      `json:"path"`
+	Value     interface{} `json:"value"`
+	// Timestamp is the original timestamp of the record, sent as its explicit timestamp. It can only be set for
+	// datastreams with explicit_timestamp: other values are stamped by AppEngine when they are received. Records
+	// are sent in input order for each Device.
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+}
+
+// ImportRecordResult is the outcome of the import of a single record. Line is the line (for JSON Lines) or
+// the record number (for CSV) of the record in the input, starting from 1.
+type ImportRecordResult struct {
+	Line   int
+	Record ImportRecord
+	Err    error
+}
+
+// DataImportReport summarizes a data import. Failures contains the results of all failed records, ordered by Line.
+type DataImportReport struct {
+	Total     int
+	Succeeded int
+	Failed    int
+	Failures  []ImportRecordResult
+}
+
+// DataImportOptions configures a data import.
+type DataImportOptions struct {
+	// Format is the input format.
+	Format DataImportFormat
+	// Concurrency is the maximum number of concurrent requests. Records of the same Device are always
+	// sent sequentially. If <= 0, a default concurrency is used.
+	Concurrency int
+	// InterfaceMajors selects the major version of each Interface to validate records against. If an
+	// Interface is missing, its latest major version installed in the Realm is used.
+	InterfaceMajors map[string]int
+	// ResumeFile, if set, is the file where all failed records are written in JSON Lines format, so that
+	// they can be imported again once the cause of the failure is fixed.
+	ResumeFile string
+}
+
+type importJob struct {
+	line             int
+	record           ImportRecord
+	astarteInterface interfaces.AstarteInterface
+	payload          interface{}
+}
+
+// ImportData reads records from r and sends them to the Realm with SendData, or SendDataWithTimestamp if they have a
+// Timestamp. Each record is validated against its Interface, which is retrieved from RealmManagement, before being
+// sent. A failure on a single record does not stop the import, and is reported in the returned DataImportReport; an
+// error is returned only if the input or the resume file cannot be processed.
+func (s *AppEngineService) ImportData(r io.Reader, realm string, options DataImportOptions) (DataImportReport, error) {
+	if s.client.RealmManagement == nil {
+		return DataImportReport{}, errors.New("ImportData requires a RealmManagement service")
+	}
+	if options.Concurrency <= 0 {
+		options.Concurrency = defaultImportConcurrency
+	}
+
+	results := make(chan ImportRecordResult)
+	reports := collectImportResults(results)
+	workers := s.startImportWorkers(realm, options.Concurrency, results)
+	resolver := &importInterfaceResolver{realmManagement: s.client.RealmManagement, realm: realm, majors: options.InterfaceMajors,
+		interfaces: map[string]interfaces.AstarteInterface{}, errors: map[string]error{}}
+	readErr := readImportRecords(r, options.Format, func(line int, record ImportRecord, err error) {
+		if err == nil {
+			var job importJob
+			if job, err = resolver.prepareJob(line, record); err == nil {
+				workers.dispatch(job)
+				return
+			}
+		}
+		results <- ImportRecordResult{Line: line, Record: record, Err: err}
+	})
+	workers.close()
+	close(results)
+	report := <-reports
+
+	if readErr != nil {
+		return report, readErr
+	}
+	if options.ResumeFile != "" {
+		if err := writeImportResumeFile(options.ResumeFile, report.Failures); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// collectImportResults summarizes results, until the channel is closed, in the report sent on the returned channel
+func collectImportResults(results <-chan ImportRecordResult) <-chan DataImportReport {
+	reports := make(chan DataImportReport, 1)
+	go func() {
+		report := DataImportReport{}
+		for result := range results {
+			report.Total++
+			if result.Err != nil {
+				report.Failed++
+				report.Failures = append(report.Failures, result)
+			} else {
+				report.Succeeded++
+			}
+		}
+		sort.Slice(report.Failures, func(i, j int) bool { return report.Failures[i].Line < report.Failures[j].Line })
+		reports <- report
+	}()
+	return reports
+}
+
+// importWorkers send import jobs, sharded by Device so that records of the same Device are sent in order
+type importWorkers struct {
+	jobs []chan importJob
+	wg   sync.WaitGroup
+}
+
+func (s *AppEngineService) startImportWorkers(realm string, concurrency int, results chan<- ImportRecordResult) *importWorkers {
+	workers := &importWorkers{jobs: make([]chan importJob, concurrency)}
+	for i := range workers.jobs {
+		workers.jobs[i] = make(chan importJob)
+		workers.wg.Add(1)
+		go func(jobs <-chan importJob) {
+			defer workers.wg.Done()
+			for job := range jobs {
+				err := s.sendData(realm, job.record.Device, AutodiscoverDeviceIdentifier, job.astarteInterface, job.record.Path,
+					job.payload, job.record.Timestamp)
+				results <- ImportRecordResult{Line: job.line, Record: job.record, Err: err}
+			}
+		}(workers.jobs[i])
+	}
+	return workers
+}
+
+func (w *importWorkers) dispatch(job importJob) {
+	shard := fnv.New32a()
+	_, _ = shard.Write([]byte(job.record.Device))
+	w.jobs[shard.Sum32()%uint32(len(w.jobs))] <- job
+}
+
+// close waits for all dispatched jobs to be sent
+func (w *importWorkers) close() {
+	for _, jobs := range w.jobs {
+		close(jobs)
+	}
+	w.wg.Wait()
+}
+
+type importInterfaceResolver struct {
+	realmManagement *RealmManagementService
+	realm           string
+	majors          map[string]int
+	interfaces      map[string]interfaces.AstarteInterface
+	errors          map[string]error
+}
+
+func (r *importInterfaceResolver) getInterface(interfaceName string) (interfaces.AstarteInterface, error) {
+	if iface, ok := r.interfaces[interfaceName]; ok {
+		return iface, nil
+	}
+	if err, ok := r.errors[interfaceName]; ok {
+		return interfaces.AstarteInterface{}, err
+	}
+
+	iface, err := r.fetchInterface(interfaceName)
+	if err != nil {
+		r.errors[interfaceName] = err
+		return iface, err
+	}
+	r.interfaces[interfaceName] = iface
+	return iface, nil
+}
+
+func (r *importInterfaceResolver) fetchInterface(interfaceName string) (interfaces.AstarteInterface, error) {
+	major, ok := r.majors[interfaceName]
+	if !ok {
+		majors, err := r.realmManagement.ListInterfaceMajorVersions(r.realm, interfaceName)
+		if err != nil {
+			return interfaces.AstarteInterface{}, err
+		}
+		if len(majors) == 0 {
+			return interfaces.AstarteInterface{}, fmt.Errorf("interface %s is not installed", interfaceName)
+		}
+		sort.Ints(majors)
+		major = majors[len(majors)-1]
+	}
+	return r.realmManagement.GetInterface(r.realm, interfaceName, major)
+}
+
+// prepareJob validates record and converts its value to a payload suitable for SendData
+func (r *importInterfaceResolver) prepareJob(line int, record ImportRecord) (importJob, error) {
+	iface, err := r.getInterface(record.Interface)
+	if err != nil {
+		return importJob{}, err
+	}
+	if iface.Ownership != interfaces.ServerOwnership {
+		return importJob{}, fmt.Errorf("interface %s is not server-owned", iface.Name)
+	}
+	if record.Timestamp != nil {
+		if err := checkExplicitTimestamp(iface, record.Path); err != nil {
+			return importJob{}, fmt.Errorf("record has a timestamp, but %w", err)
+		}
+	}
+
+	var payload interface{}
+	if iface.Type == interfaces.DatastreamType && iface.Aggregation == interfaces.ObjectAggregation {
+		payload, err = coerceAggregateValue(iface, record.Path, record.Value)
+	} else {
+		var mapping interfaces.AstarteInterfaceMapping
+		mapping, err = interfaces.InterfaceMappingFromPath(iface, record.Path)
+		if err != nil {
+			return importJob{}, err
+		}
+		payload, err = interfaces.CoerceValue(mapping.Type, record.Value)
+	}
+	if err != nil {
+		return importJob{}, err
+	}
+
+	return importJob{line: line, record: record, astarteInterface: iface, payload: payload}, nil
+}
+
+func coerceAggregateValue(iface interfaces.AstarteInterface, interfacePath string, value interface{}) (map[string]interface{}, error) {
+	if s, ok := value.(string); ok {
+		decoded := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(s), &decoded); err != nil {
+			return nil, fmt.Errorf("cannot parse aggregate value: %w", err)
+		}
+		value = decoded
+	}
+	aggregate, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("aggregate value must be an object, got %T", value)
+	}
+
+	payload := map[string]interface{}{}
+	for k, v := range aggregate {
+		mapping, err := interfaces.InterfaceMappingFromPath(iface, path.Join(interfacePath, k))
+		if err != nil {
+			return nil, err
+		}
+		if payload[k], err = interfaces.CoerceValue(mapping.Type, v); err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+	}
+	return payload, nil
+}
+
+// readImportRecords reads all records from r and calls handler for each of them. Records which cannot be parsed
+// are passed to handler with a non-nil error. An error is returned only if r cannot be read.
+func readImportRecords(r io.Reader, format DataImportFormat, handler func(int, ImportRecord, error)) error {
+	switch format {
+	case JSONLinesImportFormat:
+		return readJSONLinesImportRecords(r, handler)
+	case CSVImportFormat:
+		return readCSVImportRecords(r, handler)
+	}
+	return errors.New("invalid import format")
+}
+
+func readJSONLinesImportRecords(r io.Reader, handler func(int, ImportRecord, error)) error {
+	scanner := bufio.NewScanner(r)
+	// Aggregates and arrays can make for long lines
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		record, err := parseJSONLinesImportRecord(scanner.Bytes())
+		handler(line, record, err)
+	}
+	return scanner.Err()
+}
+
+// parseJSONLinesImportRecord parses a single JSON Lines record. Numbers are kept as json.Number, so that long
+// integers do not lose precision before being coerced to their mapping type.
+func parseJSONLinesImportRecord(b []byte) (ImportRecord, error) {
+	record := ImportRecord{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&record); err != nil {
+		return ImportRecord{}, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return ImportRecord{}, errors.New("invalid data after the record")
+	}
+	return record, nil
+}
+
+func readCSVImportRecords(r io.Reader, handler func(int, ImportRecord, error)) error {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return err
+	}
+	columns := map[string]int{}
+	for i, column := range header {
+		columns[column] = i
+	}
+	for _, column := range []string{"device", "interface", "path", "value"} {
+		if _, ok := columns[column]; !ok {
+			return fmt.Errorf("missing %s column", column)
+		}
+	}
+
+	line := 0
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		line++
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); ok {
+				handler(line, ImportRecord{}, err)
+				continue
+			}
+			return err
+		}
+
+		record := ImportRecord{
+			Device:    fields[columns["device"]],
+			Interface: fields[columns["interface"]],
+			Path:      fields[columns["path"]],
+			Value:     fields[columns["value"]],
+		}
+		if i, ok := columns["timestamp"]; ok && fields[i] != "" {
+			timestamp, err := time.Parse(time.RFC3339Nano, fields[i])
+			if err != nil {
+				handler(line, record, err)
+				continue
+			}
+			record.Timestamp = &timestamp
+		}
+		handler(line, record, nil)
+	}
+}
+
+func writeImportResumeFile(fileName string, failures []ImportRecordResult) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	for _, failure := range failures {
+		if failure.Record.Device == "" && failure.Record.Interface == "" {
+			// The record could not be parsed, there is nothing to resume
+			continue
+		}
+		if err := encoder.Encode(failure.Record); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
@@ -0,0 +1,167 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportDataCSV(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	tmpDir, err := ioutil.TempDir("", "astarte-import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	samplingRate := "org.astarte-platform.genericsensors.SamplingRate"
+	input := strings.Join([]string{
+		"device,interface,path,value,timestamp",
+		fmt.Sprintf("%s,%s,/sensor1/samplingPeriod,10,", testDevices[0], samplingRate),
+		fmt.Sprintf("%s,%s,/sensor1/enable,true,", testDevices[1], samplingRate),
+		// Wrong type
+		fmt.Sprintf("%s,%s,/sensor1/samplingPeriod,fast,", testDevices[0], samplingRate),
+		// Device-owned interface
+		fmt.Sprintf("%s,org.astarte-platform.genericsensors.Values,/sensor1/value,1.5,", testDevices[0]),
+		// Missing interface
+		fmt.Sprintf("%s,org.astarte-platform.Missing,/value,1,", testDevices[0]),
+		// Properties have no timestamp
+		fmt.Sprintf("%s,%s,/sensor1/samplingPeriod,10,2021-03-01T10:00:00Z", testDevices[0], samplingRate),
+	}, "\n")
+
+	resumeFile := filepath.Join(tmpDir, "resume.jsonl")
+	report, err := client.AppEngine.ImportData(strings.NewReader(input), testRealmName,
+		DataImportOptions{Format: CSVImportFormat, Concurrency: 2, ResumeFile: resumeFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Total != 6 || report.Succeeded != 2 || report.Failed != 4 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	for i, line := range []int{3, 4, 5, 6} {
+		if report.Failures[i].Line != line {
+			t.Errorf("expected failure on line %v, got %+v", line, report.Failures[i])
+		}
+	}
+
+	requests := popTestWriteRequests()
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 requests, got %v", requests)
+	}
+	for _, request := range requests {
+		if request.Method != "PUT" {
+			t.Errorf("properties were set with %s", request.Method)
+		}
+		if strings.HasSuffix(request.Path, "/samplingPeriod") && request.Data != 10.0 {
+			t.Errorf("unexpected sampling period %v", request.Data)
+		}
+	}
+
+	resume, err := ioutil.ReadFile(resumeFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(resume)), "\n"); len(lines) != 4 {
+		t.Errorf("unexpected resume file %s", resume)
+	}
+}
+
+func TestImportDataTimestamps(t *testing.T) {
+	const commands = "org.example.Commands"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case fmt.Sprintf("/realmmanagement/v1/%s/interfaces/%s", testRealmName, commands):
+			fmt.Fprint(w, `{"data": [0]}`)
+		case fmt.Sprintf("/realmmanagement/v1/%s/interfaces/%s/0", testRealmName, commands):
+			fmt.Fprintf(w, `{"data": {"interface_name": "%s", "version_major": 0, "version_minor": 1, "type": "datastream",
+				"ownership": "server", "mappings": [{"endpoint": "/%%{id}/setpoint", "type": "double", "explicit_timestamp": true},
+				{"endpoint": "/%%{id}/note", "type": "string"}]}}`, commands)
+		default:
+			astarteAPIMock(w, req)
+		}
+	}))
+	defer server.Close()
+	client, err := NewClient(server.URL, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken(testTokenValue)
+
+	input := strings.Join([]string{
+		fmt.Sprintf(`{"device": "%s", "interface": "%s", "path": "/a/setpoint", "value": 21.5, "timestamp": "2021-03-01T10:00:00Z"}`,
+			testDevices[0], commands),
+		fmt.Sprintf(`{"device": "%s", "interface": "%s", "path": "/a/setpoint", "value": 22}`, testDevices[0], commands),
+		fmt.Sprintf(`{"device": "%s", "interface": "%s", "path": "/a/note", "value": "x", "timestamp": "2021-03-01T10:00:00Z"}`,
+			testDevices[0], commands),
+	}, "\n")
+	report, err := client.AppEngine.ImportData(strings.NewReader(input), testRealmName, DataImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Succeeded != 2 || report.Failed != 1 || report.Failures[0].Line != 3 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	requests := popTestWriteRequests()
+	if len(requests) != 2 || requests[0].Timestamp != "2021-03-01T10:00:00Z" || requests[1].Timestamp != "" {
+		t.Errorf("unexpected requests %+v", requests)
+	}
+}
+
+func TestImportDataLongInteger(t *testing.T) {
+	const counters = "org.example.Counters"
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case fmt.Sprintf("/realmmanagement/v1/%s/interfaces/%s", testRealmName, counters):
+			fmt.Fprint(w, `{"data": [0]}`)
+		case fmt.Sprintf("/realmmanagement/v1/%s/interfaces/%s/0", testRealmName, counters):
+			fmt.Fprintf(w, `{"data": {"interface_name": "%s", "version_major": 0, "version_minor": 1, "type": "datastream",
+				"ownership": "server", "mappings": [{"endpoint": "/%%{id}/total", "type": "longinteger"}]}}`, counters)
+		case fmt.Sprintf("/appengine/v1/%s/devices/%s/interfaces/%s/a/total", testRealmName, testDevices[0], counters):
+			b, _ := ioutil.ReadAll(req.Body)
+			body = string(b)
+		default:
+			astarteAPIMock(w, req)
+		}
+	}))
+	defer server.Close()
+	client, err := NewClient(server.URL, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken(testTokenValue)
+
+	// 2^53 + 1 cannot be represented as a float64
+	input := fmt.Sprintf(`{"device": "%s", "interface": "%s", "path": "/a/total", "value": 9007199254740993}`,
+		testDevices[0], counters)
+	report, err := client.AppEngine.ImportData(strings.NewReader(input), testRealmName, DataImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Succeeded != 1 || !strings.Contains(body, "9007199254740993") {
+		t.Errorf("unexpected report %+v with body %s", report, body)
+	}
+}
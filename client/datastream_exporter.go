@@ -0,0 +1,385 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/astarte-platform/astarte-go/interfaces"
+)
+
+// DatastreamExportFormat represents the output format of a Datastream export.
+type DatastreamExportFormat int
+
+const (
+	// JSONLinesExportFormat writes one JSON object per sample, one per line, with device_id, path, timestamp,
+	// reception_timestamp and either value, for individual Interfaces, or values, an object holding the
+	// endpoint leaves, for aggregates.
+	JSONLinesExportFormat DatastreamExportFormat = iota
+	// CSVExportFormat writes one CSV record per sample, with a header derived from the Interface mappings.
+	// Aggregates having an endpoint leaf named like another column cannot be exported in this format.
+	CSVExportFormat
+)
+
+// DatastreamExportOptions configures a Datastream export.
+type DatastreamExportOptions struct {
+	// DeviceIDs are the Devices to export samples of.
+	DeviceIDs []string
+	// Paths are the paths to export for each Device. For individual Interfaces, they must be complete
+	// endpoint paths. For aggregate Interfaces, they must be paths of the aggregates.
+	Paths []string
+	// Since is the beginning of the time window. If zero, all samples up to To are exported.
+	Since time.Time
+	// To is the end of the time window. If zero, the time at which the export starts is used.
+	To time.Time
+	// Format is the output format of the export.
+	Format DatastreamExportFormat
+	// PageSize is the page size used to retrieve samples. If <= 0, the default page size is used.
+	PageSize int
+	// CheckpointFile, if set, is the file in which the export progress is saved after each page. If the
+	// file already exists, the export resumes where it stopped: in that case, the output should be appended
+	// to the output of the interrupted export. Time window and format must not change between runs.
+	CheckpointFile string
+}
+
+type exportCheckpointEntry struct {
+	LastTimestamp time.Time `json:"last_timestamp"`
+	Completed     bool      `json:"completed"`
+}
+
+type exportCheckpoint struct {
+	Since   time.Time                                   `json:"since"`
+	To      time.Time                                   `json:"to"`
+	Format  DatastreamExportFormat                      `json:"format"`
+	Devices map[string]map[string]exportCheckpointEntry `json:"devices"`
+}
+
+type datastreamExport struct {
+	appEngine        *AppEngineService
+	realm            string
+	astarteInterface interfaces.AstarteInterface
+	options          DatastreamExportOptions
+	checkpoint       exportCheckpoint
+	columns          []string
+	csvWriter        *csv.Writer
+	jsonEncoder      *json.Encoder
+}
+
+// ExportDatastreams exports all samples of astarteInterface for a set of Devices and paths in a time window, and writes
+// them to w in the chosen format. Samples are retrieved in ascending order with a paginator for each Device and path.
+// When a CheckpointFile is given, an interrupted export can be resumed by calling ExportDatastreams again with the
+// same options; samples belonging to the last page written before the interruption might be written twice.
+func (s *AppEngineService) ExportDatastreams(w io.Writer, realm string, astarteInterface interfaces.AstarteInterface,
+	options DatastreamExportOptions) error {
+	if astarteInterface.Type != interfaces.DatastreamType {
+		return fmt.Errorf("%s is not a datastream interface", astarteInterface.Name)
+	}
+	for _, interfacePath := range options.Paths {
+		if err := validateExportPath(astarteInterface, interfacePath); err != nil {
+			return err
+		}
+	}
+	if options.PageSize <= 0 {
+		options.PageSize = defaultPageSize
+	}
+	if options.Since.IsZero() {
+		options.Since = invalidTime
+	}
+	toRequested := !options.To.IsZero()
+	if !toRequested {
+		options.To = time.Now()
+	}
+
+	e := &datastreamExport{
+		appEngine:        s,
+		realm:            realm,
+		astarteInterface: astarteInterface,
+		options:          options,
+		checkpoint: exportCheckpoint{
+			Since:   options.Since,
+			To:      options.To,
+			Format:  options.Format,
+			Devices: map[string]map[string]exportCheckpointEntry{},
+		},
+		columns: exportColumns(astarteInterface),
+	}
+	resuming, err := e.loadCheckpoint(toRequested)
+	if err != nil {
+		return err
+	}
+
+	if err := e.setupWriter(w, resuming); err != nil {
+		return err
+	}
+
+	for _, deviceID := range options.DeviceIDs {
+		for _, interfacePath := range options.Paths {
+			if err := e.exportPath(deviceID, interfacePath); err != nil {
+				return fmt.Errorf("could not export %s on device %s: %w", interfacePath, deviceID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// setupWriter prepares the writer for the export format, writing the CSV header unless resuming
+func (e *datastreamExport) setupWriter(w io.Writer, resuming bool) error {
+	switch e.options.Format {
+	case JSONLinesExportFormat:
+		e.jsonEncoder = json.NewEncoder(w)
+	case CSVExportFormat:
+		if err := checkCSVExportColumns(e.astarteInterface); err != nil {
+			return err
+		}
+		e.csvWriter = csv.NewWriter(w)
+		if !resuming {
+			return e.csvWriter.Write(append([]string{"device_id", "path"}, e.columns...))
+		}
+	default:
+		return errors.New("invalid export format")
+	}
+	return nil
+}
+
+func validateExportPath(astarteInterface interfaces.AstarteInterface, interfacePath string) error {
+	if astarteInterface.Aggregation == interfaces.ObjectAggregation {
+		return interfaces.ValidateQuery(astarteInterface, interfacePath)
+	}
+	return interfaces.ValidateInterfacePath(astarteInterface, interfacePath)
+}
+
+// exportColumns returns the value columns for astarteInterface: the value and its timestamps for individual interfaces,
+// the timestamps and all the endpoint leaves, in mapping order, for aggregates
+func exportColumns(astarteInterface interfaces.AstarteInterface) []string {
+	if astarteInterface.Aggregation != interfaces.ObjectAggregation {
+		return []string{"timestamp", "reception_timestamp", "value"}
+	}
+
+	columns := []string{"timestamp", "reception_timestamp"}
+	seen := map[string]bool{}
+	for _, m := range astarteInterface.Mappings {
+		leaf := path.Base(m.Endpoint)
+		if !seen[leaf] {
+			seen[leaf] = true
+			columns = append(columns, leaf)
+		}
+	}
+	return columns
+}
+
+// checkCSVExportColumns checks that no endpoint leaf of an aggregate is named like one of the other CSV columns
+func checkCSVExportColumns(astarteInterface interfaces.AstarteInterface) error {
+	if astarteInterface.Aggregation != interfaces.ObjectAggregation {
+		return nil
+	}
+	reserved := map[string]bool{"device_id": true, "path": true, "timestamp": true, "reception_timestamp": true}
+	for _, m := range astarteInterface.Mappings {
+		if leaf := path.Base(m.Endpoint); reserved[leaf] {
+			return fmt.Errorf("endpoint %s clashes with the %s CSV column", m.Endpoint, leaf)
+		}
+	}
+	return nil
+}
+
+func (e *datastreamExport) exportPath(deviceID, interfacePath string) error {
+	entry := e.checkpoint.Devices[deviceID][interfacePath]
+	if entry.Completed {
+		return nil
+	}
+
+	paginator, err := e.appEngine.getDatastreamPaginatorInternal(e.realm, devicePath(deviceID, AstarteDeviceID), e.astarteInterface.Name, interfacePath,
+		e.options.Since, e.options.To, e.options.PageSize, AscendingOrder)
+	if err != nil {
+		return err
+	}
+	if !entry.LastTimestamp.IsZero() {
+		// Resume after the last exported sample
+		paginator.nextWindow = entry.LastTimestamp
+	}
+
+	for paginator.HasNextPage() {
+		lastTimestamp, err := e.exportPage(&paginator, deviceID, interfacePath)
+		if err != nil {
+			return err
+		}
+		if !lastTimestamp.IsZero() {
+			entry.LastTimestamp = lastTimestamp
+		}
+		entry.Completed = !paginator.HasNextPage()
+		if err := e.saveCheckpoint(deviceID, interfacePath, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// exportPage writes the next page of paginator and returns the timestamp of the last written sample
+func (e *datastreamExport) exportPage(paginator *DatastreamPaginator, deviceID, interfacePath string) (time.Time, error) {
+	lastTimestamp := time.Time{}
+	if e.astarteInterface.Aggregation == interfaces.ObjectAggregation {
+		page, err := paginator.GetNextAggregatePage()
+		if err != nil {
+			return lastTimestamp, err
+		}
+		for _, sample := range page {
+			values := map[string]interface{}{}
+			for _, k := range sample.Values.Keys() {
+				values[k], _ = sample.Values.Get(k)
+			}
+			if err := e.writeSample(deviceID, interfacePath, sample.Timestamp, sample.ReceptionTimestamp, "values", values); err != nil {
+				return lastTimestamp, err
+			}
+			lastTimestamp = sample.Timestamp
+		}
+	} else {
+		page, err := paginator.GetNextPage()
+		if err != nil {
+			return lastTimestamp, err
+		}
+		for _, sample := range page {
+			if err := e.writeSample(deviceID, interfacePath, sample.Timestamp, sample.ReceptionTimestamp, "value", sample.Value); err != nil {
+				return lastTimestamp, err
+			}
+			lastTimestamp = sample.Timestamp
+		}
+	}
+
+	if e.csvWriter != nil {
+		e.csvWriter.Flush()
+		return lastTimestamp, e.csvWriter.Error()
+	}
+	return lastTimestamp, nil
+}
+
+// writeSample writes a sample, whose value is stored in valueKey: value for individual Interfaces, values, holding
+// the endpoint leaves, for aggregates
+func (e *datastreamExport) writeSample(deviceID, interfacePath string, timestamp, receptionTimestamp time.Time,
+	valueKey string, value interface{}) error {
+	fields := map[string]interface{}{"device_id": deviceID, "path": interfacePath, "timestamp": timestamp.UTC(), valueKey: value}
+	if !receptionTimestamp.IsZero() {
+		fields["reception_timestamp"] = receptionTimestamp.UTC()
+	}
+	if e.jsonEncoder != nil {
+		return e.jsonEncoder.Encode(fields)
+	}
+
+	leaves, _ := value.(map[string]interface{})
+	record := []string{deviceID, interfacePath}
+	for _, column := range e.columns {
+		columnValue, ok := fields[column]
+		if !ok {
+			columnValue = leaves[column]
+		}
+		record = append(record, csvValue(columnValue))
+	}
+	return e.csvWriter.Write(record)
+}
+
+func csvValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	default:
+		// Arrays and anything else are encoded as JSON
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(encoded)
+	}
+}
+
+// loadCheckpoint loads the checkpoint file, if any, and returns whether the export is being resumed
+func (e *datastreamExport) loadCheckpoint(toRequested bool) (bool, error) {
+	if e.options.CheckpointFile == "" {
+		return false, nil
+	}
+	b, err := ioutil.ReadFile(e.options.CheckpointFile)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	checkpoint := exportCheckpoint{}
+	if err := json.Unmarshal(b, &checkpoint); err != nil {
+		return false, err
+	}
+	if !checkpoint.Since.Equal(e.checkpoint.Since) || checkpoint.Format != e.checkpoint.Format ||
+		(toRequested && !checkpoint.To.Equal(e.checkpoint.To)) {
+		return false, errors.New("checkpoint file does not match the export options")
+	}
+	// If no end of the window was requested, it was computed when the export started: restore it
+	e.options.To = checkpoint.To
+	e.checkpoint = checkpoint
+	if e.checkpoint.Devices == nil {
+		e.checkpoint.Devices = map[string]map[string]exportCheckpointEntry{}
+	}
+	return true, nil
+}
+
+func (e *datastreamExport) saveCheckpoint(deviceID, interfacePath string, entry exportCheckpointEntry) error {
+	if _, ok := e.checkpoint.Devices[deviceID]; !ok {
+		e.checkpoint.Devices[deviceID] = map[string]exportCheckpointEntry{}
+	}
+	e.checkpoint.Devices[deviceID][interfacePath] = entry
+	if e.options.CheckpointFile == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(e.checkpoint)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(e.options.CheckpointFile, b)
+}
+
+// writeFileAtomically replaces fileName with data, so that an interruption never leaves a partially written file
+func writeFileAtomically(fileName string, data []byte) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(fileName), filepath.Base(fileName)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	return os.Rename(tmpFile.Name(), fileName)
+}
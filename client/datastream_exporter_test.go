@@ -0,0 +1,176 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/astarte-platform/astarte-go/interfaces"
+)
+
+const testAggregateInterface = `{
+	"interface_name": "org.astarte-platform.genericsensors.Geolocation",
+	"version_major": 1,
+	"version_minor": 0,
+	"type": "datastream",
+	"ownership": "device",
+	"aggregation": "object",
+	"mappings": [
+		{"endpoint": "/gps/latitude", "type": "double"},
+		{"endpoint": "/gps/longitude", "type": "double"}
+	]
+}`
+
+// failingWriter fails all writes after the first failAfter ones
+type failingWriter struct {
+	buffer    bytes.Buffer
+	failAfter int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.failAfter <= 0 {
+		return 0, errors.New("write failed")
+	}
+	w.failAfter--
+	return w.buffer.Write(p)
+}
+
+func TestExportDatastreamsCSVWithCheckpoint(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	iface, err := interfaces.ParseInterfaceFromString(testAggregateInterface)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpDir, err := ioutil.TempDir("", "astarte-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	options := DatastreamExportOptions{
+		DeviceIDs:      []string{testDevices[0]},
+		Paths:          []string{"/gps"},
+		To:             testAggregateSamplesStart.Add(time.Hour),
+		Format:         CSVExportFormat,
+		PageSize:       10,
+		CheckpointFile: filepath.Join(tmpDir, "checkpoint.json"),
+	}
+
+	// Interrupt the export after the first page
+	interrupted := &failingWriter{failAfter: 1}
+	if err := client.AppEngine.ExportDatastreams(interrupted, testRealmName, iface, options); err == nil {
+		t.Fatal("the export was not interrupted")
+	}
+
+	resumed := &bytes.Buffer{}
+	if err := client.AppEngine.ExportDatastreams(resumed, testRealmName, iface, options); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(append(interrupted.buffer.Bytes(), resumed.Bytes()...))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 26 {
+		t.Fatalf("expected a header and 25 records, got %v records", len(records))
+	}
+	expectedHeader := []string{"device_id", "path", "timestamp", "reception_timestamp", "latitude", "longitude"}
+	for i, column := range expectedHeader {
+		if records[0][i] != column {
+			t.Errorf("unexpected header %v", records[0])
+		}
+	}
+	if records[25][2] != testAggregateSamplesStart.Add(24*time.Minute).Format(time.RFC3339Nano) || records[25][4] != "24" {
+		t.Errorf("unexpected last record %v", records[25])
+	}
+
+	// The export is complete: running it again does not write anything
+	completed := &bytes.Buffer{}
+	if err := client.AppEngine.ExportDatastreams(completed, testRealmName, iface, options); err != nil {
+		t.Fatal(err)
+	}
+	if completed.Len() != 0 {
+		t.Errorf("a completed export wrote %v", completed.String())
+	}
+}
+
+func TestExportDatastreamsJSONLines(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	// A leaf named timestamp must not clash with the timestamp of the sample
+	iface, err := interfaces.ParseInterfaceFromString(strings.Replace(testAggregateInterface, "/gps/longitude", "/gps/timestamp", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := DatastreamExportOptions{DeviceIDs: []string{testDevices[0]}, Paths: []string{"/gps"}}
+	output := &bytes.Buffer{}
+	if err := client.AppEngine.ExportDatastreams(output, testRealmName, iface, options); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 25 {
+		t.Fatalf("unexpected number of lines %v", len(lines))
+	}
+	var line struct {
+		DeviceID  string                 `json:"device_id"`
+		Timestamp time.Time              `json:"timestamp"`
+		Values    map[string]interface{} `json:"values"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &line); err != nil {
+		t.Fatal(err)
+	}
+	if line.DeviceID != testDevices[0] || !line.Timestamp.Equal(testAggregateSamplesStart.Add(time.Minute)) ||
+		line.Values["latitude"] != 1.0 {
+		t.Errorf("unexpected line %s", lines[1])
+	}
+
+	options.Format = CSVExportFormat
+	if err := client.AppEngine.ExportDatastreams(&bytes.Buffer{}, testRealmName, iface, options); err == nil {
+		t.Error("clashing columns were exported to CSV")
+	}
+}
+
+func TestCheckCSVExportColumns(t *testing.T) {
+	iface := interfaces.AstarteInterface{
+		Name:        "org.example.Samples",
+		Type:        interfaces.DatastreamType,
+		Aggregation: interfaces.ObjectAggregation,
+		Mappings: []interfaces.AstarteInterfaceMapping{
+			{Endpoint: "/%{sensor_id}/value", Type: interfaces.Double},
+			{Endpoint: "/%{sensor_id}/path", Type: interfaces.String},
+		},
+	}
+	err := checkCSVExportColumns(iface)
+	if err == nil || err.Error() != "endpoint /%{sensor_id}/path clashes with the path CSV column" {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	iface.Mappings = iface.Mappings[:1]
+	if err := checkCSVExportColumns(iface); err != nil {
+		t.Error(err)
+	}
+}
@@ -27,7 +27,7 @@ type ResultSetOrder int
 const (
 	// AscendingOrder means the Paginator will return results starting from the oldest.
 	AscendingOrder ResultSetOrder = iota
-	// DescendingOrder means the Paginator will return results starting from the oldest.
+	// DescendingOrder means the Paginator will return results starting from the newest.
 	DescendingOrder
 )
 
@@ -81,6 +81,10 @@ func (d *DatastreamPaginator) GetNextPage() ([]DatastreamValue, error) {
 		return nil, err
 	}
 
+	if len(page) == 0 {
+		d.computePageState(0, invalidTime)
+		return page, nil
+	}
 	d.computePageState(len(page), page[len(page)-1].Timestamp)
 
 	return page, nil
@@ -102,6 +106,10 @@ func (d *DatastreamPaginator) GetNextAggregatePage() ([]DatastreamAggregateValue
 		return nil, err
 	}
 
+	if len(page) == 0 {
+		d.computePageState(0, invalidTime)
+		return page, nil
+	}
 	d.computePageState(len(page), page[len(page)-1].Timestamp)
 
 	return page, nil
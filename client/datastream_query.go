@@ -0,0 +1,224 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// DatastreamQueryOptions configures a Datastream query. Zero values are not sent to AppEngine.
+type DatastreamQueryOptions struct {
+	// Since only returns samples with a timestamp greater or equal than Since.
+	Since time.Time
+	// SinceAfter only returns samples with a timestamp strictly greater than SinceAfter.
+	SinceAfter time.Time
+	// To only returns samples with a timestamp strictly lower than To.
+	To time.Time
+	// Limit returns at most Limit samples, starting from the newest one.
+	Limit int
+	// KeepMilliseconds asks AppEngine to keep the milliseconds in the returned timestamps.
+	KeepMilliseconds bool
+}
+
+func (o DatastreamQueryOptions) query(format string) url.Values {
+	query := url.Values{}
+	if format != "" {
+		query.Set("format", format)
+	}
+	if !o.Since.IsZero() {
+		query.Set("since", o.Since.UTC().Format(time.RFC3339Nano))
+	}
+	if !o.SinceAfter.IsZero() {
+		query.Set("since_after", o.SinceAfter.UTC().Format(time.RFC3339Nano))
+	}
+	if !o.To.IsZero() {
+		query.Set("to", o.To.UTC().Format(time.RFC3339Nano))
+	}
+	if o.Limit > 0 {
+		query.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.KeepMilliseconds {
+		query.Set("keep_milliseconds", "true")
+	}
+	return query
+}
+
+// DatastreamTable is the result of a Datastream query in table format, pivoted by column: all slices are indexed
+// by sample. Values holds a slice for each column, in the order of Columns, which contains the endpoint leaves for
+// aggregates and "value" for individual Datastreams. ReceptionTimestamps is empty unless AppEngine returns them.
+type DatastreamTable struct {
+	Columns             []string
+	Timestamps          []time.Time
+	ReceptionTimestamps []time.Time
+	Values              [][]interface{}
+}
+
+// Column returns the values of column name, and whether the column exists.
+func (t DatastreamTable) Column(name string) ([]interface{}, bool) {
+	for i, column := range t.Columns {
+		if column == name {
+			return t.Values[i], true
+		}
+	}
+	return nil, false
+}
+
+// DatastreamColumn is a single column of a Datastream query in disjoint tables format. Values and Timestamps
+// are indexed by sample.
+type DatastreamColumn struct {
+	Values     []interface{}
+	Timestamps []time.Time
+}
+
+type datastreamTableMetadata struct {
+	Columns     map[string]int `json:"columns"`
+	TableHeader []string       `json:"table_header"`
+}
+
+// GetDatastreamTable queries a path of a Datastream interface, either individual or aggregate, using AppEngine's
+// table format, and returns its results by column.
+func (s *AppEngineService) GetDatastreamTable(realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType,
+	interfaceName, interfacePath string, options DatastreamQueryOptions) (DatastreamTable, error) {
+	rows := [][]interface{}{}
+	metadata := datastreamTableMetadata{}
+	err := s.withDevicePath(realm, deviceIdentifier, deviceIdentifierType, func(deviceResourcePath string) error {
+		callURL, err := s.appengineGenericJSONDataAPIURL(interfaceName+interfacePath, realm, deviceResourcePath,
+			options.query("table").Encode())
+		if err != nil {
+			return err
+		}
+		return s.client.genericJSONDataAPIGETWithMetadata(&rows, &metadata, callURL.String(), 200)
+	})
+	if err != nil {
+		return DatastreamTable{}, err
+	}
+
+	return parseDatastreamTable(rows, metadata)
+}
+
+// GetDatastreamDisjointTables queries a path of a Datastream interface, either individual or aggregate, using AppEngine's
+// disjoint tables format, and returns a DatastreamColumn for each endpoint leaf ("value" for individual Datastreams).
+func (s *AppEngineService) GetDatastreamDisjointTables(realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType,
+	interfaceName, interfacePath string, options DatastreamQueryOptions) (map[string]DatastreamColumn, error) {
+	tables := map[string][][]interface{}{}
+	err := s.withDevicePath(realm, deviceIdentifier, deviceIdentifierType, func(deviceResourcePath string) error {
+		callURL, err := s.appengineGenericJSONDataAPIURL(interfaceName+interfacePath, realm, deviceResourcePath,
+			options.query("disjoint_tables").Encode())
+		if err != nil {
+			return err
+		}
+		return s.client.genericJSONDataAPIGET(&tables, callURL.String(), 200)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ret := map[string]DatastreamColumn{}
+	for name, rows := range tables {
+		column := DatastreamColumn{Values: make([]interface{}, 0, len(rows)), Timestamps: make([]time.Time, 0, len(rows))}
+		for _, row := range rows {
+			if len(row) != 2 {
+				return nil, ErrMalformedPayload
+			}
+			timestamp, err := parseTimestampValue(row[1])
+			if err != nil {
+				return nil, err
+			}
+			column.Values = append(column.Values, row[0])
+			column.Timestamps = append(column.Timestamps, timestamp)
+		}
+		ret[name] = column
+	}
+	return ret, nil
+}
+
+func parseDatastreamTable(rows [][]interface{}, metadata datastreamTableMetadata) (DatastreamTable, error) {
+	header, err := datastreamTableHeader(metadata)
+	if err != nil {
+		return DatastreamTable{}, err
+	}
+
+	table := DatastreamTable{}
+	timestampColumn, receptionTimestampColumn := -1, -1
+	valueColumns := []int{}
+	for i, name := range header {
+		switch name {
+		case "timestamp":
+			timestampColumn = i
+		case "reception_timestamp":
+			receptionTimestampColumn = i
+		default:
+			table.Columns = append(table.Columns, name)
+			valueColumns = append(valueColumns, i)
+		}
+	}
+	if timestampColumn < 0 {
+		return DatastreamTable{}, fmt.Errorf("%w: missing timestamp column", ErrMalformedPayload)
+	}
+
+	table.Values = make([][]interface{}, len(valueColumns))
+	for i := range table.Values {
+		table.Values[i] = make([]interface{}, 0, len(rows))
+	}
+	for _, row := range rows {
+		if len(row) != len(header) {
+			return DatastreamTable{}, ErrMalformedPayload
+		}
+		if err := table.appendRow(row, timestampColumn, receptionTimestampColumn, valueColumns); err != nil {
+			return DatastreamTable{}, err
+		}
+	}
+
+	return table, nil
+}
+
+// datastreamTableHeader returns the table header of metadata, rebuilding it from the column indexes if needed
+func datastreamTableHeader(metadata datastreamTableMetadata) ([]string, error) {
+	if len(metadata.TableHeader) > 0 {
+		return metadata.TableHeader, nil
+	}
+	header := make([]string, len(metadata.Columns))
+	for name, i := range metadata.Columns {
+		if i < 0 || i >= len(header) {
+			return nil, ErrMalformedPayload
+		}
+		header[i] = name
+	}
+	return header, nil
+}
+
+// appendRow appends the timestamps and values of row to the table. receptionTimestampColumn is negative if the table
+// has no reception timestamps.
+func (t *DatastreamTable) appendRow(row []interface{}, timestampColumn, receptionTimestampColumn int, valueColumns []int) error {
+	timestamp, err := parseTimestampValue(row[timestampColumn])
+	if err != nil {
+		return err
+	}
+	t.Timestamps = append(t.Timestamps, timestamp)
+	if receptionTimestampColumn >= 0 {
+		receptionTimestamp, err := parseTimestampValue(row[receptionTimestampColumn])
+		if err != nil {
+			return err
+		}
+		t.ReceptionTimestamps = append(t.ReceptionTimestamps, receptionTimestamp)
+	}
+	for i, column := range valueColumns {
+		t.Values[i] = append(t.Values[i], row[column])
+	}
+	return nil
+}
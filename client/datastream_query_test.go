@@ -0,0 +1,103 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetDatastreamTable(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	options := DatastreamQueryOptions{Since: testAggregateSamplesStart.Add(10 * time.Minute), To: testAggregateSamplesStart.Add(15 * time.Minute)}
+	table, err := client.AppEngine.GetDatastreamTable(testRealmName, testDevices[0], AstarteDeviceID, testAggregateInterfaceName, "/gps", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(table.Columns) != 2 || table.Columns[0] != "latitude" || table.Columns[1] != "longitude" {
+		t.Fatalf("unexpected columns %v", table.Columns)
+	}
+	latitudes, ok := table.Column("latitude")
+	if !ok || len(latitudes) != 5 || len(table.Timestamps) != 5 {
+		t.Fatalf("unexpected table %+v", table)
+	}
+	for i, latitude := range latitudes {
+		if latitude != float64(10+i) || !table.Timestamps[i].Equal(testAggregateSamplesStart.Add(time.Duration(10+i)*time.Minute)) {
+			t.Errorf("unexpected sample %v: %v at %v", i, latitude, table.Timestamps[i])
+		}
+	}
+}
+
+func TestGetDatastreamDisjointTables(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	options := DatastreamQueryOptions{Since: testAggregateSamplesStart.Add(20 * time.Minute)}
+	tables, err := client.AppEngine.GetDatastreamDisjointTables(testRealmName, testDevices[0], AstarteDeviceID, testAggregateInterfaceName, "/gps", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	longitudes, ok := tables["longitude"]
+	if !ok || len(tables) != 2 || len(longitudes.Values) != 5 {
+		t.Fatalf("unexpected tables %+v", tables)
+	}
+	for i, longitude := range longitudes.Values {
+		if longitude != float64(-20-i) || !longitudes.Timestamps[i].Equal(testAggregateSamplesStart.Add(time.Duration(20+i)*time.Minute)) {
+			t.Errorf("unexpected sample %v: %v at %v", i, longitude, longitudes.Timestamps[i])
+		}
+	}
+}
+
+func TestAggregateReceptionTimestamp(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	values, err := client.AppEngine.GetLastAggregateDatastreams(testRealmName, testDevices[0], AstarteDeviceID, testAggregateInterfaceName, "/gps", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 {
+		t.Fatalf("unexpected values %v", values)
+	}
+	if !values[0].ReceptionTimestamp.Equal(testAggregateSamplesStart.Add(24*time.Minute + time.Second)) {
+		t.Errorf("unexpected reception timestamp %v", values[0].ReceptionTimestamp)
+	}
+	if _, ok := values[0].Values.Get("reception_timestamp"); ok {
+		t.Errorf("reception_timestamp was left in values")
+	}
+}
+
+func TestDecodeJSONEnvelope(t *testing.T) {
+	// Members without a target are skipped, even if they contain keys of other members
+	body := `{"metadata": {"data": "wrong"}, "links": {"next": "/next"}, "data": {"value": 1}}`
+	data := map[string]interface{}{}
+	links := Links{}
+	if err := decodeJSONEnvelope(strings.NewReader(body), map[string]interface{}{"data": &data, "links": &links, "metadata": nil}); err != nil {
+		t.Fatal(err)
+	}
+	if data["value"] != 1.0 || links.Next != "/next" {
+		t.Errorf("unexpected envelope %v %v", data, links)
+	}
+
+	metadata := map[string]interface{}{}
+	if err := decodeJSONEnvelope(strings.NewReader(`{"data": {}}`), map[string]interface{}{"data": &data, "metadata": &metadata}); err != ErrMalformedPayload {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultWatchInterval   = 10 * time.Second
+	defaultWatchMaxBackoff = 5 * time.Minute
+)
+
+// WatchCursorStore persists the cursor of a Datastream watcher, i.e. the timestamp of the last delivered sample,
+// so that a watcher can be restarted without replaying or missing samples.
+type WatchCursorStore interface {
+	// LoadCursor returns the saved cursor, or a zero time if no cursor was saved.
+	LoadCursor() (time.Time, error)
+	// SaveCursor saves the cursor.
+	SaveCursor(cursor time.Time) error
+}
+
+// FileWatchCursorStore is a WatchCursorStore which saves the cursor in a file.
+type FileWatchCursorStore struct {
+	FileName string
+}
+
+// LoadCursor returns the cursor saved in the file, or a zero time if the file does not exist.
+func (f FileWatchCursorStore) LoadCursor() (time.Time, error) {
+	b, err := ioutil.ReadFile(f.FileName)
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, strings.TrimSpace(string(b)))
+}
+
+// SaveCursor atomically replaces the content of the file with the cursor.
+func (f FileWatchCursorStore) SaveCursor(cursor time.Time) error {
+	return writeFileAtomically(f.FileName, []byte(cursor.UTC().Format(time.RFC3339Nano)))
+}
+
+// DatastreamWatchOptions configures a Datastream watcher.
+type DatastreamWatchOptions struct {
+	// Interval is the polling interval. If <= 0, a default interval is used.
+	Interval time.Duration
+	// MaxBackoff is the maximum time to wait before polling again after consecutive errors. The wait time
+	// doubles at each error, starting from Interval. If <= 0, a default maximum is used.
+	MaxBackoff time.Duration
+	// Since is the timestamp after which samples are delivered, when CursorStore is nil or holds no cursor.
+	// If zero, only samples after the latest one stored in Astarte when the watcher starts are delivered.
+	Since time.Time
+	// CursorStore, if set, is used to load the cursor when the watcher starts, and to save it after each
+	// delivered page of samples.
+	CursorStore WatchCursorStore
+	// PageSize is the page size used to retrieve samples. If <= 0, the default page size is used.
+	PageSize int
+	// OnError, if set, is called with each error encountered while polling or delivering samples.
+	// Errors never stop the watcher: it keeps polling with backoff until its context is canceled.
+	OnError func(error)
+}
+
+// WatchDatastream polls a path of a Datastream interface and calls handler with each new sample, in ascending order.
+// It blocks until ctx is canceled, and then returns ctx.Err(). Each poll retrieves the samples with a timestamp
+// later than the cursor, i.e. the timestamp of the last delivered sample. If handler returns an error, the sample is
+// delivered again at the next poll, and samples delivered after the last saved cursor are delivered again when the
+// watcher is restarted. Samples which are stored by Astarte when the cursor has already passed their timestamp are
+// never delivered: these include samples sharing the timestamp of the last delivered one, and samples arriving late,
+// e.g. sent with an explicit timestamp by a device which was offline.
+func (s *AppEngineService) WatchDatastream(ctx context.Context, realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType,
+	interfaceName, interfacePath string, options DatastreamWatchOptions, handler func(DatastreamValue) error) error {
+	return s.watchDatastreamInternal(ctx, realm, deviceIdentifier, deviceIdentifierType, interfaceName, interfacePath, options,
+		func(paginator *DatastreamPaginator) (time.Time, error) {
+			page, err := paginator.GetNextPage()
+			if err != nil || len(page) == 0 {
+				return invalidTime, err
+			}
+			return page[0].Timestamp, nil
+		},
+		func(paginator *DatastreamPaginator, cursor *time.Time) error {
+			page, err := paginator.GetNextPage()
+			if err != nil {
+				return err
+			}
+			for _, value := range page {
+				if err := handler(value); err != nil {
+					return err
+				}
+				*cursor = value.Timestamp
+			}
+			return nil
+		})
+}
+
+// WatchAggregateDatastream works like WatchDatastream, but for a path of an aggregate Datastream interface.
+func (s *AppEngineService) WatchAggregateDatastream(ctx context.Context, realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType,
+	interfaceName, interfacePath string, options DatastreamWatchOptions, handler func(DatastreamAggregateValue) error) error {
+	return s.watchDatastreamInternal(ctx, realm, deviceIdentifier, deviceIdentifierType, interfaceName, interfacePath, options,
+		func(paginator *DatastreamPaginator) (time.Time, error) {
+			page, err := paginator.GetNextAggregatePage()
+			if err != nil || len(page) == 0 {
+				return invalidTime, err
+			}
+			return page[0].Timestamp, nil
+		},
+		func(paginator *DatastreamPaginator, cursor *time.Time) error {
+			page, err := paginator.GetNextAggregatePage()
+			if err != nil {
+				return err
+			}
+			for _, value := range page {
+				if err := handler(value); err != nil {
+					return err
+				}
+				*cursor = value.Timestamp
+			}
+			return nil
+		})
+}
+
+func (s *AppEngineService) watchDatastreamInternal(ctx context.Context, realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType,
+	interfaceName, interfacePath string, options DatastreamWatchOptions, latestTimestamp func(*DatastreamPaginator) (time.Time, error),
+	deliverPage func(*DatastreamPaginator, *time.Time) error) error {
+	if options.Interval <= 0 {
+		options.Interval = defaultWatchInterval
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = defaultWatchMaxBackoff
+	}
+	if options.PageSize <= 0 {
+		options.PageSize = defaultPageSize
+	}
+	reportError := func(err error) {
+		if options.OnError != nil {
+			options.OnError(err)
+		}
+	}
+
+	cursor := options.Since
+	if options.CursorStore != nil {
+		savedCursor, err := options.CursorStore.LoadCursor()
+		if err != nil {
+			return err
+		}
+		if !savedCursor.IsZero() {
+			cursor = savedCursor
+		}
+	}
+
+	deviceResourcePath, err := s.resolveDevicePath(realm, deviceIdentifier, deviceIdentifierType)
+	if err != nil {
+		return err
+	}
+	wait := time.Duration(0)
+	backoff := options.Interval
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+
+		err := s.pollDatastream(realm, deviceResourcePath, interfaceName, interfacePath, options, &cursor,
+			latestTimestamp, deliverPage)
+		if err != nil {
+			reportError(err)
+			wait = backoff
+			backoff *= 2
+			if backoff > options.MaxBackoff {
+				backoff = options.MaxBackoff
+			}
+			continue
+		}
+		wait = options.Interval
+		backoff = options.Interval
+	}
+}
+
+// pollDatastream delivers all samples after cursor, updating and saving it after each page. A zero cursor
+// is first set to the timestamp of the latest sample, so that the clock of the client is never relied upon.
+func (s *AppEngineService) pollDatastream(realm, deviceResourcePath, interfaceName, interfacePath string,
+	options DatastreamWatchOptions, cursor *time.Time, latestTimestamp func(*DatastreamPaginator) (time.Time, error),
+	deliverPage func(*DatastreamPaginator, *time.Time) error) error {
+	if cursor.IsZero() {
+		paginator, err := s.getDatastreamPaginatorInternal(realm, deviceResourcePath, interfaceName, interfacePath,
+			invalidTime, time.Now(), 1, DescendingOrder)
+		if err != nil {
+			return err
+		}
+		// invalidTime, if there are no samples yet, makes all of them be delivered
+		if *cursor, err = latestTimestamp(&paginator); err != nil {
+			*cursor = time.Time{}
+			return err
+		}
+	}
+
+	paginator, err := s.getDatastreamPaginatorInternal(realm, deviceResourcePath, interfaceName, interfacePath,
+		invalidTime, time.Now(), options.PageSize, AscendingOrder)
+	if err != nil {
+		return err
+	}
+	paginator.nextWindow = *cursor
+
+	for paginator.HasNextPage() {
+		previousCursor := *cursor
+		deliveryErr := deliverPage(&paginator, cursor)
+		if options.CursorStore != nil && !cursor.Equal(previousCursor) {
+			if err := options.CursorStore.SaveCursor(*cursor); err != nil {
+				return err
+			}
+		}
+		if deliveryErr != nil {
+			return deliveryErr
+		}
+	}
+
+	return nil
+}
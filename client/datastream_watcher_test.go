@@ -0,0 +1,118 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchAggregateDatastream(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	tmpDir, err := ioutil.TempDir("", "astarte-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	cursorStore := FileWatchCursorStore{FileName: filepath.Join(tmpDir, "cursor")}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	delivered := []time.Time{}
+	errorsReported := 0
+	failed := false
+	options := DatastreamWatchOptions{
+		Interval:    time.Millisecond,
+		Since:       testAggregateSamplesStart.Add(19 * time.Minute),
+		CursorStore: cursorStore,
+		PageSize:    2,
+		OnError:     func(error) { errorsReported++ },
+	}
+	err = client.AppEngine.WatchAggregateDatastream(ctx, testRealmName, testDevices[0], AstarteDeviceID, testAggregateInterfaceName, "/gps",
+		options, func(value DatastreamAggregateValue) error {
+			// Fail once on the second sample: it must be delivered again
+			if len(delivered) == 1 && !failed {
+				failed = true
+				return errors.New("handler failure")
+			}
+			delivered = append(delivered, value.Timestamp)
+			if len(delivered) == 5 {
+				cancel()
+			}
+			return nil
+		})
+	if err != context.Canceled {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if errorsReported != 1 {
+		t.Errorf("expected 1 error, got %v", errorsReported)
+	}
+	for i, timestamp := range delivered {
+		if !timestamp.Equal(testAggregateSamplesStart.Add(time.Duration(20+i) * time.Minute)) {
+			t.Errorf("unexpected sample %v at %v", i, timestamp)
+		}
+	}
+
+	cursor, err := cursorStore.LoadCursor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cursor.Equal(testAggregateSamplesStart.Add(24 * time.Minute)) {
+		t.Errorf("unexpected saved cursor %v", cursor)
+	}
+}
+
+func TestWatchDatastreamStartsFromLatestSample(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sinceAfter := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if query := req.URL.Query(); query.Get("page_size") != "" {
+			sinceAfter = query.Get("since_after")
+			cancel()
+		}
+		astarteAPIMock(w, req)
+	}))
+	defer server.Close()
+	client, err := NewClient(server.URL, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken(testTokenValue)
+
+	err = client.AppEngine.WatchAggregateDatastream(ctx, testRealmName, testDevices[0], AstarteDeviceID, testAggregateInterfaceName, "/gps",
+		DatastreamWatchOptions{Interval: time.Millisecond}, func(value DatastreamAggregateValue) error {
+			t.Errorf("unexpected sample %v", value)
+			return nil
+		})
+	if err != context.Canceled {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if expected := testAggregateSamplesStart.Add(24 * time.Minute).Format(time.RFC3339Nano); sinceAfter != expected {
+		t.Errorf("watcher started after %v, expected %v", sinceAfter, expected)
+	}
+}
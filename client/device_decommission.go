@@ -0,0 +1,269 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ErrDecommissionNotReversible is returned when reversing a decommission which deleted the data of the Device.
+var ErrDecommissionNotReversible = errors.New("decommissions deleting device data cannot be reversed")
+
+// DecommissionStep represents a step of the decommissioning of a Device. Steps are always run in the order
+// of their declaration.
+type DecommissionStep int
+
+const (
+	// InhibitCredentialsStep inhibits the credentials of the Device, so that it cannot connect anymore.
+	InhibitCredentialsStep DecommissionStep = iota
+	// RemoveFromGroupsStep removes the Device from all its groups.
+	RemoveFromGroupsStep
+	// DeleteAliasesStep deletes all aliases of the Device.
+	DeleteAliasesStep
+	// DeleteAttributesStep deletes all attributes of the Device.
+	DeleteAttributesStep
+	// UnregisterDeviceStep unregisters the Device from Pairing.
+	UnregisterDeviceStep
+	// DeleteDataStep deletes the Device and all its data, using RealmManagementService.DeleteDevice.
+	// It cannot be reversed.
+	DeleteDataStep
+)
+
+func (s DecommissionStep) String() string {
+	switch s {
+	case InhibitCredentialsStep:
+		return "inhibit_credentials"
+	case RemoveFromGroupsStep:
+		return "remove_from_groups"
+	case DeleteAliasesStep:
+		return "delete_aliases"
+	case DeleteAttributesStep:
+		return "delete_attributes"
+	case UnregisterDeviceStep:
+		return "unregister_device"
+	case DeleteDataStep:
+		return "delete_data"
+	}
+	return fmt.Sprintf("DecommissionStep(%d)", int(s))
+}
+
+// DecommissionAction is a single change made while decommissioning a Device. Key is the group, alias tag or
+// attribute key the action refers to, if any.
+type DecommissionAction struct {
+	Step DecommissionStep
+	Key  string
+}
+
+func (a DecommissionAction) String() string {
+	switch a.Step {
+	case InhibitCredentialsStep:
+		return "inhibit credentials"
+	case RemoveFromGroupsStep:
+		return fmt.Sprintf("remove from group %s", a.Key)
+	case DeleteAliasesStep:
+		return fmt.Sprintf("delete alias %s", a.Key)
+	case DeleteAttributesStep:
+		return fmt.Sprintf("delete attribute %s", a.Key)
+	case UnregisterDeviceStep:
+		return "unregister from pairing"
+	case DeleteDataStep:
+		return "delete device and data"
+	}
+	return "unknown action"
+}
+
+// DecommissionOptions configures the decommissioning of a Device.
+type DecommissionOptions struct {
+	// Steps are the steps to run. If empty, all steps but DeleteDataStep are run.
+	Steps []DecommissionStep
+	// DryRun only computes the actions needed to decommission the Device, without applying them.
+	DryRun bool
+}
+
+// DecommissionReport is the outcome of the decommissioning of a Device. PriorState are the details of the Device
+// before any change, and are used to reverse the decommission. Actions are the changes needed (or applied): if the
+// decommission failed, only the actions before the failed one were applied.
+type DecommissionReport struct {
+	DeviceID   string
+	PriorState DeviceDetails
+	Actions    []DecommissionAction
+	DryRun     bool
+}
+
+// Write writes a human readable plan, or outcome if the report is not a dry run, to w.
+func (r DecommissionReport) Write(w io.Writer) error {
+	verb := "applied"
+	if r.DryRun {
+		verb = "planned"
+	}
+	if len(r.Actions) == 0 {
+		_, err := fmt.Fprintf(w, "%s: nothing to do\n", r.DeviceID)
+		return err
+	}
+	for _, action := range r.Actions {
+		if _, err := fmt.Fprintf(w, "%s: %s %s\n", r.DeviceID, verb, action); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DecommissionDevice retires a Device by running the configured steps, which are planned from the current state of
+// the Device: running it again after a failure only applies the remaining actions, hence retries are safe. In that
+// case, the reports of all runs are needed to reverse the whole decommission. Unregistering the Device requires
+// a PairingService, and deleting its data requires a RealmManagementService: deletion is asynchronous, see
+// RealmManagementService.WaitForDeviceDeletion. The returned report lists the applied actions even if an error
+// is returned.
+func (s *AppEngineService) DecommissionDevice(realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType,
+	options DecommissionOptions) (DecommissionReport, error) {
+	steps := map[DecommissionStep]bool{}
+	for _, step := range options.Steps {
+		steps[step] = true
+	}
+	if len(steps) == 0 {
+		for step := InhibitCredentialsStep; step < DeleteDataStep; step++ {
+			steps[step] = true
+		}
+	}
+	if steps[UnregisterDeviceStep] && s.client.Pairing == nil {
+		return DecommissionReport{}, errors.New("unregistering a device requires a Pairing service")
+	}
+	if steps[DeleteDataStep] && s.client.RealmManagement == nil {
+		return DecommissionReport{}, errors.New("deleting device data requires a RealmManagement service")
+	}
+
+	details, err := s.GetDevice(realm, deviceIdentifier, deviceIdentifierType)
+	if err != nil {
+		return DecommissionReport{}, err
+	}
+	report := DecommissionReport{DeviceID: details.DeviceID, PriorState: details, Actions: planDecommission(details, steps),
+		DryRun: options.DryRun}
+	if options.DryRun {
+		return report, nil
+	}
+
+	for i, action := range report.Actions {
+		if err := s.applyDecommissionAction(realm, details.DeviceID, action); err != nil {
+			report.Actions = report.Actions[:i]
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+func planDecommission(details DeviceDetails, steps map[DecommissionStep]bool) []DecommissionAction {
+	actions := []DecommissionAction{}
+	if steps[InhibitCredentialsStep] && !details.CredentialsInhibited {
+		actions = append(actions, DecommissionAction{Step: InhibitCredentialsStep})
+	}
+	if steps[RemoveFromGroupsStep] {
+		groups := append([]string{}, details.Groups...)
+		sort.Strings(groups)
+		for _, group := range groups {
+			actions = append(actions, DecommissionAction{Step: RemoveFromGroupsStep, Key: group})
+		}
+	}
+	if steps[DeleteAliasesStep] {
+		for _, tag := range sortedKeys(details.Aliases) {
+			actions = append(actions, DecommissionAction{Step: DeleteAliasesStep, Key: tag})
+		}
+	}
+	if steps[DeleteAttributesStep] {
+		for _, key := range sortedKeys(details.Attributes) {
+			actions = append(actions, DecommissionAction{Step: DeleteAttributesStep, Key: key})
+		}
+	}
+	if steps[UnregisterDeviceStep] {
+		actions = append(actions, DecommissionAction{Step: UnregisterDeviceStep})
+	}
+	if steps[DeleteDataStep] {
+		actions = append(actions, DecommissionAction{Step: DeleteDataStep})
+	}
+	return actions
+}
+
+func (s *AppEngineService) applyDecommissionAction(realm, deviceID string, action DecommissionAction) error {
+	switch action.Step {
+	case InhibitCredentialsStep:
+		return s.InhibitDevice(realm, deviceID, AstarteDeviceID, true)
+	case RemoveFromGroupsStep:
+		// The Device might have been removed from the group in the meanwhile
+		if err := s.RemoveDeviceFromGroup(realm, action.Key, deviceID, AstarteDeviceID); err != nil && !isNotFound(err) {
+			return err
+		}
+		return nil
+	case DeleteAliasesStep:
+		return s.DeleteDeviceAlias(realm, deviceID, action.Key)
+	case DeleteAttributesStep:
+		return s.DeleteDeviceAttribute(realm, deviceID, AstarteDeviceID, action.Key)
+	case UnregisterDeviceStep:
+		if err := s.client.Pairing.UnregisterDevice(realm, deviceID); err != nil && !isNotFound(err) {
+			return err
+		}
+		return nil
+	case DeleteDataStep:
+		// The deletion of the Device might have been started already
+		if err := s.client.RealmManagement.DeleteDevice(realm, deviceID); err != nil && !isNotFound(err) {
+			return err
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported decommission step %v", action.Step)
+}
+
+// ReverseDecommission restores the state of a Device recorded in report, by reversing its actions in the opposite
+// order. Groups which no longer exist are created again. If the Device was unregistered, it is registered again,
+// and its new Credentials Secret is returned: the Device must be provisioned with it to connect again.
+func (s *AppEngineService) ReverseDecommission(realm string, report DecommissionReport) (string, error) {
+	for _, action := range report.Actions {
+		if action.Step == DeleteDataStep {
+			return "", ErrDecommissionNotReversible
+		}
+		if action.Step == UnregisterDeviceStep && s.client.Pairing == nil {
+			return "", errors.New("registering a device requires a Pairing service")
+		}
+	}
+	if report.DryRun {
+		return "", nil
+	}
+
+	credentialsSecret := ""
+	for i := len(report.Actions) - 1; i >= 0; i-- {
+		action := report.Actions[i]
+		var err error
+		switch action.Step {
+		case InhibitCredentialsStep:
+			err = s.InhibitDevice(realm, report.DeviceID, AstarteDeviceID, false)
+		case RemoveFromGroupsStep:
+			err = s.AddDeviceToGroup(realm, action.Key, report.DeviceID, AstarteDeviceID)
+			if isNotFound(err) {
+				err = s.CreateGroup(realm, action.Key, []string{report.DeviceID}, AstarteDeviceID)
+			}
+		case DeleteAliasesStep:
+			err = s.AddDeviceAlias(realm, report.DeviceID, action.Key, report.PriorState.Aliases[action.Key])
+		case DeleteAttributesStep:
+			err = s.SetDeviceAttribute(realm, report.DeviceID, AstarteDeviceID, action.Key, report.PriorState.Attributes[action.Key])
+		case UnregisterDeviceStep:
+			credentialsSecret, err = s.client.Pairing.RegisterDevice(realm, report.DeviceID)
+		}
+		if err != nil {
+			return credentialsSecret, fmt.Errorf("reversing %s: %w", action, err)
+		}
+	}
+	return credentialsSecret, nil
+}
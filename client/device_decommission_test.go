@@ -0,0 +1,100 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestDecommissionDevice(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	report, err := client.AppEngine.DecommissionDevice(testRealmName, "sensor-a", AutodiscoverDeviceIdentifier, DecommissionOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedActions := []DecommissionAction{
+		{Step: InhibitCredentialsStep},
+		{Step: RemoveFromGroupsStep, Key: testGroupName},
+		{Step: DeleteAliasesStep, Key: "name"},
+		{Step: DeleteAttributesStep, Key: "site"},
+		{Step: UnregisterDeviceStep},
+	}
+	if report.DeviceID != testDevices[0] || !reflect.DeepEqual(report.Actions, expectedActions) {
+		t.Errorf("unexpected plan %+v", report)
+	}
+	if requests := popTestWriteRequests(); len(requests) != 0 {
+		t.Errorf("dry run sent requests %v", requests)
+	}
+
+	report, err = client.AppEngine.DecommissionDevice(testRealmName, testDevices[0], AstarteDeviceID, DecommissionOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	devicePath := fmt.Sprintf("/appengine/v1/%s/devices/%s", testRealmName, testDevices[0])
+	expectedPaths := []string{
+		devicePath,
+		fmt.Sprintf("/appengine/v1/%s/groups/%s/devices/%s", testRealmName, testGroupName, testDevices[0]),
+		devicePath,
+		devicePath,
+		fmt.Sprintf("/pairing/v1/%s/agent/devices/%s", testRealmName, testDevices[0]),
+	}
+	if paths := testWriteRequestPaths(popTestWriteRequests()); !reflect.DeepEqual(paths, expectedPaths) {
+		t.Errorf("unexpected requests %v", paths)
+	}
+
+	if _, err := client.AppEngine.ReverseDecommission(testRealmName, report); err != nil {
+		t.Fatal(err)
+	}
+	requests := popTestWriteRequests()
+	expectedPaths = []string{
+		fmt.Sprintf("/pairing/v1/%s/agent/devices", testRealmName),
+		devicePath,
+		devicePath,
+		fmt.Sprintf("/appengine/v1/%s/groups/%s/devices", testRealmName, testGroupName),
+		devicePath,
+	}
+	if paths := testWriteRequestPaths(requests); !reflect.DeepEqual(paths, expectedPaths) {
+		t.Errorf("unexpected requests %v", paths)
+	}
+	if data := requests[1].Data; !reflect.DeepEqual(data, map[string]interface{}{"attributes": map[string]interface{}{"site": "north"}}) {
+		t.Errorf("unexpected attribute restore %v", data)
+	}
+
+	report, err = client.AppEngine.DecommissionDevice(testRealmName, testDevices[0], AstarteDeviceID,
+		DecommissionOptions{Steps: []DecommissionStep{DeleteDataStep}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedPaths = []string{fmt.Sprintf("/realmmanagement/v1/%s/devices/%s", testRealmName, testDevices[0])}
+	if paths := testWriteRequestPaths(popTestWriteRequests()); !reflect.DeepEqual(paths, expectedPaths) {
+		t.Errorf("unexpected requests %v", paths)
+	}
+	if _, err := client.AppEngine.ReverseDecommission(testRealmName, report); !errors.Is(err, ErrDecommissionNotReversible) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func testWriteRequestPaths(requests []testWriteRequest) []string {
+	paths := []string{}
+	for _, request := range requests {
+		paths = append(paths, request.Path)
+	}
+	return paths
+}
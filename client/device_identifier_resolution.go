@@ -0,0 +1,242 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/astarte-platform/astarte-go/misc"
+)
+
+// DeviceIdentifierResolution represents how AutodiscoverDeviceIdentifier identifiers are resolved by an AppEngineService.
+type DeviceIdentifierResolution int
+
+const (
+	// HeuristicDeviceIdentifierResolution is the default, and considers an identifier a Device ID if it is a valid
+	// Device ID, an alias otherwise. Aliases which are also valid Device IDs are misrouted.
+	HeuristicDeviceIdentifierResolution DeviceIdentifierResolution = iota
+	// StrictDeviceIDResolution always considers identifiers Device IDs.
+	StrictDeviceIDResolution
+	// StrictDeviceAliasResolution always considers identifiers aliases.
+	StrictDeviceAliasResolution
+	// DeviceIDWithAliasFallbackResolution considers an identifier a Device ID if it is a valid Device ID, and tries
+	// it again as an alias if AppEngine replies with 404. Other identifiers are considered aliases.
+	DeviceIDWithAliasFallbackResolution
+)
+
+const (
+	// DefaultDeviceAliasCacheTTL is how long a resolved alias is used before being resolved again, by default.
+	DefaultDeviceAliasCacheTTL = 5 * time.Minute
+	// maxDeviceAliasCacheEntries bounds the number of aliases cached for each Realm
+	maxDeviceAliasCacheEntries = 10000
+)
+
+// deviceAliasCacheEntry is a Device ID, with the time after which it must be resolved again
+type deviceAliasCacheEntry struct {
+	deviceID string
+	expiry   time.Time
+}
+
+// deviceAliasCache maps aliases to Device IDs, for each Realm. The zero value is ready to use, and keeps entries
+// for DefaultDeviceAliasCacheTTL.
+type deviceAliasCache struct {
+	lock      sync.RWMutex
+	ttl       time.Duration
+	deviceIDs map[string]map[string]deviceAliasCacheEntry
+}
+
+func (c *deviceAliasCache) get(realm, alias string) (string, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	entry, ok := c.deviceIDs[realm][alias]
+	if !ok || time.Now().After(entry.expiry) {
+		return "", false
+	}
+	return entry.deviceID, true
+}
+
+func (c *deviceAliasCache) set(realm, alias, deviceID string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ttl := c.ttl
+	switch {
+	case ttl < 0:
+		return
+	case ttl == 0:
+		ttl = DefaultDeviceAliasCacheTTL
+	}
+	if c.deviceIDs == nil {
+		c.deviceIDs = map[string]map[string]deviceAliasCacheEntry{}
+	}
+	if c.deviceIDs[realm] == nil {
+		c.deviceIDs[realm] = map[string]deviceAliasCacheEntry{}
+	}
+	if len(c.deviceIDs[realm]) >= maxDeviceAliasCacheEntries {
+		c.evictExpired(realm)
+	}
+	c.deviceIDs[realm][alias] = deviceAliasCacheEntry{deviceID: deviceID, expiry: time.Now().Add(ttl)}
+}
+
+// evictExpired removes expired entries of a Realm, or all of them if none expired. The lock must be held.
+func (c *deviceAliasCache) evictExpired(realm string) {
+	now := time.Now()
+	for alias, entry := range c.deviceIDs[realm] {
+		if now.After(entry.expiry) {
+			delete(c.deviceIDs[realm], alias)
+		}
+	}
+	if len(c.deviceIDs[realm]) >= maxDeviceAliasCacheEntries {
+		c.deviceIDs[realm] = map[string]deviceAliasCacheEntry{}
+	}
+}
+
+func (c *deviceAliasCache) evictDevice(realm, deviceID string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for alias, entry := range c.deviceIDs[realm] {
+		if entry.deviceID == deviceID {
+			delete(c.deviceIDs[realm], alias)
+		}
+	}
+}
+
+// SetDeviceIdentifierResolution sets how AutodiscoverDeviceIdentifier identifiers are resolved by all methods
+// accepting a DeviceIdentifierType.
+func (s *AppEngineService) SetDeviceIdentifierResolution(resolution DeviceIdentifierResolution) {
+	s.resolutionLock.Lock()
+	defer s.resolutionLock.Unlock()
+	s.resolution = resolution
+}
+
+// SetDeviceAliasCacheTTL sets how long resolved aliases are cached before being resolved again. A zero ttl restores
+// DefaultDeviceAliasCacheTTL, a negative one disables the cache. Entries already cached are kept until they expire.
+func (s *AppEngineService) SetDeviceAliasCacheTTL(ttl time.Duration) {
+	s.aliasCache.lock.Lock()
+	defer s.aliasCache.lock.Unlock()
+	s.aliasCache.ttl = ttl
+}
+
+// ClearDeviceAliasCache empties the cache mapping aliases to Device IDs. The cache is shared by all methods accepting
+// a DeviceIdentifierType, so that an alias is resolved at most once per cache TTL, and is kept up to date with the
+// aliases changed through the AppEngineService. It should be cleared if aliases are moved between Devices by other
+// clients: until the entries expire, both reads and writes through a moved alias reach the Device which had it.
+func (s *AppEngineService) ClearDeviceAliasCache() {
+	s.aliasCache.lock.Lock()
+	defer s.aliasCache.lock.Unlock()
+	s.aliasCache.deviceIDs = nil
+}
+
+// resolveDeviceIdentifierType works like the resolveDeviceIdentifierType function, but using the configured
+// DeviceIdentifierResolution. It also returns whether an AstarteDeviceID should be tried again as an alias.
+func (s *AppEngineService) resolveDeviceIdentifierType(deviceIdentifier string, deviceIdentifierType DeviceIdentifierType) (DeviceIdentifierType, bool) {
+	if deviceIdentifierType != AutodiscoverDeviceIdentifier {
+		return deviceIdentifierType, false
+	}
+
+	s.resolutionLock.RLock()
+	resolution := s.resolution
+	s.resolutionLock.RUnlock()
+	switch resolution {
+	case StrictDeviceIDResolution:
+		return AstarteDeviceID, false
+	case StrictDeviceAliasResolution:
+		return AstarteDeviceAlias, false
+	case DeviceIDWithAliasFallbackResolution:
+		if misc.IsValidAstarteDeviceID(deviceIdentifier) {
+			return AstarteDeviceID, true
+		}
+		return AstarteDeviceAlias, false
+	}
+	return resolveDeviceIdentifierType(deviceIdentifier, deviceIdentifierType), false
+}
+
+// withDevicePath calls f with the path of a Device, as returned by devicePath. Cached aliases are replaced by their
+// Device ID, and f is called again if the Device cannot be found and the identifier could be resolved differently.
+func (s *AppEngineService) withDevicePath(realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType, f func(string) error) error {
+	resolvedDeviceIdentifierType, fallback := s.resolveDeviceIdentifierType(deviceIdentifier, deviceIdentifierType)
+	if resolvedDeviceIdentifierType == AstarteDeviceAlias || fallback {
+		if deviceID, ok := s.aliasCache.get(realm, deviceIdentifier); ok {
+			err := f(devicePath(deviceID, AstarteDeviceID))
+			if !s.isDeviceNotFound(realm, deviceID, err) {
+				return err
+			}
+			// The cached Device has been deleted
+			s.aliasCache.evictDevice(realm, deviceID)
+		}
+	}
+	if resolvedDeviceIdentifierType == AstarteDeviceAlias {
+		return f(devicePath(deviceIdentifier, AstarteDeviceAlias))
+	}
+
+	err := f(devicePath(deviceIdentifier, AstarteDeviceID))
+	if !fallback || !s.isDeviceNotFound(realm, deviceIdentifier, err) {
+		return err
+	}
+	deviceID, aliasErr := s.GetDeviceIDFromAlias(realm, deviceIdentifier)
+	if aliasErr != nil {
+		return err
+	}
+	return f(devicePath(deviceID, AstarteDeviceID))
+}
+
+// isDeviceNotFound returns whether err is a 404 because the Device itself does not exist, rather than a resource of
+// the Device, such as an Interface path without data. The Device is retrieved to tell the two apart.
+func (s *AppEngineService) isDeviceNotFound(realm, deviceID string, err error) bool {
+	if !isNotFound(err) {
+		return false
+	}
+	callURL, _ := url.Parse(s.appEngineURL.String())
+	callURL.Path = path.Join(callURL.Path, fmt.Sprintf("/v1/%s/%s", realm, devicePath(deviceID, AstarteDeviceID)))
+	deviceDetails := DeviceDetails{}
+	return isNotFound(s.client.genericJSONDataAPIGET(&deviceDetails, callURL.String(), 200))
+}
+
+// resolveDevicePath returns the path of a Device, as returned by devicePath, for calls which cannot be retried
+// such as paginators. If the identifier could be resolved differently, the Device is retrieved to choose.
+func (s *AppEngineService) resolveDevicePath(realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType) (string, error) {
+	resolvedDeviceIdentifierType, fallback := s.resolveDeviceIdentifierType(deviceIdentifier, deviceIdentifierType)
+	if resolvedDeviceIdentifierType == AstarteDeviceAlias || fallback {
+		if deviceID, ok := s.aliasCache.get(realm, deviceIdentifier); ok {
+			return devicePath(deviceID, AstarteDeviceID), nil
+		}
+	}
+	if !fallback {
+		return devicePath(deviceIdentifier, resolvedDeviceIdentifierType), nil
+	}
+
+	deviceDetails, err := s.GetDevice(realm, deviceIdentifier, deviceIdentifierType)
+	if err != nil {
+		return "", err
+	}
+	return devicePath(deviceDetails.DeviceID, AstarteDeviceID), nil
+}
+
+// cacheDeviceAliases adds all aliases of a Device to the alias cache
+func (s *AppEngineService) cacheDeviceAliases(realm string, deviceDetails DeviceDetails) {
+	for _, alias := range deviceDetails.Aliases {
+		s.aliasCache.set(realm, alias, deviceDetails.DeviceID)
+	}
+}
+
+func isNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
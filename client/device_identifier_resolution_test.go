@@ -0,0 +1,154 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeviceIdentifierResolution(t *testing.T) {
+	// An alias which is also a valid Device ID
+	const alias = "QwtRYCkWTmiuoQCtYjdA1g"
+	aliasPath := fmt.Sprintf("/appengine/v1/%s/devices-by-alias/%s", testRealmName, alias)
+	missingInterfacePath := fmt.Sprintf("/appengine/v1/%s/devices/%s/interfaces/org.example.Missing", testRealmName, testDevices[0])
+	var lock sync.Mutex
+	getPaths := []string{}
+	aliasWrites := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		if req.Method == http.MethodGet {
+			getPaths = append(getPaths, req.URL.Path)
+		} else if req.URL.Path == aliasPath {
+			aliasWrites++
+		}
+		lock.Unlock()
+		if req.URL.Path == missingInterfacePath {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": map[string]string{"detail": "Interface not found"}})
+			return
+		}
+		if req.URL.Path == aliasPath {
+			details := testDeviceDetails()[2]
+			details.Aliases = map[string]string{"serial": alias}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": details})
+			return
+		}
+		astarteAPIMock(w, req)
+	}))
+	defer server.Close()
+	popGetPaths := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		paths := getPaths
+		getPaths = []string{}
+		return paths
+	}
+
+	client, err := NewClient(server.URL, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken(testTokenValue)
+
+	// The heuristic considers the alias a Device ID
+	_, err = client.AppEngine.GetDevice(testRealmName, alias, AutodiscoverDeviceIdentifier)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected error %v", err)
+	}
+	popGetPaths()
+
+	client.AppEngine.SetDeviceIdentifierResolution(DeviceIDWithAliasFallbackResolution)
+	deviceID, err := client.AppEngine.GetDeviceIDFromDeviceIdentifier(testRealmName, alias, AutodiscoverDeviceIdentifier)
+	if err != nil || deviceID != testDevices[2] {
+		t.Fatalf("unexpected resolution %v %v", deviceID, err)
+	}
+	// The Device ID is tried again as an alias once the Device is confirmed missing
+	if paths := popGetPaths(); len(paths) != 4 || paths[2] != aliasPath {
+		t.Errorf("unexpected requests %v", paths)
+	}
+
+	// Writes use the resolved alias too
+	if err := client.AppEngine.SetDeviceAttribute(testRealmName, alias, AutodiscoverDeviceIdentifier, "site", "east"); err != nil {
+		t.Fatal(err)
+	}
+	requests := popTestWriteRequests()
+	if len(requests) != 1 || requests[0].Path != fmt.Sprintf("/appengine/v1/%s/devices/%s", testRealmName, testDevices[2]) ||
+		aliasWrites != 0 {
+		t.Errorf("unexpected requests %v", requests)
+	}
+	if paths := popGetPaths(); len(paths) != 0 {
+		t.Errorf("unexpected requests %v", paths)
+	}
+	// Reads use the resolved alias
+	if _, err := client.AppEngine.GetDevice(testRealmName, alias, AutodiscoverDeviceIdentifier); err != nil {
+		t.Fatal(err)
+	}
+	if paths := popGetPaths(); len(paths) != 1 || paths[0] != fmt.Sprintf("/appengine/v1/%s/devices/%s", testRealmName, testDevices[2]) {
+		t.Errorf("unexpected requests %v", paths)
+	}
+
+	client.AppEngine.SetDeviceIdentifierResolution(StrictDeviceIDResolution)
+	if _, err := client.AppEngine.GetDevice(testRealmName, "sensor-a", AutodiscoverDeviceIdentifier); !isNotFound(err) {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// Aliases of retrieved Devices are cached too
+	client.AppEngine.SetDeviceIdentifierResolution(StrictDeviceAliasResolution)
+	client.AppEngine.ClearDeviceAliasCache()
+	if _, err := client.AppEngine.GetDevice(testRealmName, testDevices[0], AstarteDeviceID); err != nil {
+		t.Fatal(err)
+	}
+	popGetPaths()
+	if deviceID, err := client.AppEngine.GetDeviceIDFromDeviceIdentifier(testRealmName, "sensor-a", AutodiscoverDeviceIdentifier); err != nil ||
+		deviceID != testDevices[0] {
+		t.Errorf("unexpected resolution %v %v", deviceID, err)
+	}
+	if paths := popGetPaths(); len(paths) != 0 {
+		t.Errorf("unexpected requests %v", paths)
+	}
+
+	// A missing resource of an existing Device is not retried through the alias
+	if _, err := client.AppEngine.GetAggregateParametricDatastreamSnapshot(testRealmName, "sensor-a", AstarteDeviceAlias,
+		"org.example.Missing"); !isNotFound(err) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if paths := popGetPaths(); len(paths) != 2 || paths[1] != fmt.Sprintf("/appengine/v1/%s/devices/%s", testRealmName, testDevices[0]) {
+		t.Errorf("unexpected requests %v", paths)
+	}
+	if _, ok := client.AppEngine.aliasCache.get(testRealmName, "sensor-a"); !ok {
+		t.Error("alias of an existing Device evicted")
+	}
+
+	// Entries expire
+	client.AppEngine.SetDeviceAliasCacheTTL(time.Millisecond)
+	client.AppEngine.ClearDeviceAliasCache()
+	for i := 0; i < 2; i++ {
+		if _, err := client.AppEngine.GetDeviceIDFromAlias(testRealmName, "sensor-a"); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if paths := popGetPaths(); len(paths) != 2 {
+		t.Errorf("unexpected requests %v", paths)
+	}
+}
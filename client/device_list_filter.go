@@ -0,0 +1,157 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"time"
+)
+
+// DeviceListFilter selects a subset of the Devices of a Realm. Only the set fields are used to match Devices,
+// and a Device must match all of them. A zero DeviceListFilter matches all Devices.
+type DeviceListFilter struct {
+	// Connected, if set, matches Devices by their connection status.
+	Connected *bool
+	// CredentialsInhibited, if set, matches Devices by whether their credentials are inhibited.
+	CredentialsInhibited *bool
+	// Attributes matches Devices having all the given attributes, with the given values.
+	Attributes map[string]string
+	// InterfaceName, if set, matches Devices having InterfaceName in their introspection.
+	InterfaceName string
+	// InterfaceMajor, if set together with InterfaceName, matches only the given major version of the Interface.
+	InterfaceMajor *int
+	// NotSeenSince, if set, matches Devices which are not connected and did not connect or disconnect
+	// after NotSeenSince, including Devices which never connected.
+	NotSeenSince time.Time
+}
+
+// Match returns whether device matches the filter.
+func (f DeviceListFilter) Match(device DeviceDetails) bool {
+	if f.Connected != nil && device.Connected != *f.Connected {
+		return false
+	}
+	if f.CredentialsInhibited != nil && device.CredentialsInhibited != *f.CredentialsInhibited {
+		return false
+	}
+	return f.matchAttributes(device) && f.matchIntrospection(device) && f.matchNotSeenSince(device)
+}
+
+func (f DeviceListFilter) matchAttributes(device DeviceDetails) bool {
+	for k, v := range f.Attributes {
+		if value, ok := device.Attributes[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (f DeviceListFilter) matchIntrospection(device DeviceDetails) bool {
+	if f.InterfaceName == "" {
+		return true
+	}
+	introspection, ok := device.Introspection[f.InterfaceName]
+	return ok && (f.InterfaceMajor == nil || introspection.Major == *f.InterfaceMajor)
+}
+
+func (f DeviceListFilter) matchNotSeenSince(device DeviceDetails) bool {
+	if f.NotSeenSince.IsZero() {
+		return true
+	}
+	return !device.Connected && !device.LastConnection.After(f.NotSeenSince) &&
+		!device.LastDisconnection.After(f.NotSeenSince)
+}
+
+// needsDetails returns whether the filter uses any field. AppEngine has no server-side Device filters, so all
+// fields are matched client-side, on DeviceDetails.
+func (f DeviceListFilter) needsDetails() bool {
+	return f.Connected != nil || f.CredentialsInhibited != nil || len(f.Attributes) > 0 || f.InterfaceName != "" ||
+		!f.NotSeenSince.IsZero()
+}
+
+// GetFilteredDeviceListPaginator returns a Paginator for the Devices in the realm matching filter. AppEngine API has
+// no server-side Device filters, so pages are filtered client-side: they might be smaller than pageSize, or even
+// empty, and the whole Device list is retrieved anyway. Hence, a filter using any field requires DeviceDetailsFormat.
+func (s *AppEngineService) GetFilteredDeviceListPaginator(realm string, pageSize int, format DeviceResultFormat,
+	filter DeviceListFilter) (DeviceListPaginator, error) {
+	if format == DeviceIDFormat && filter.needsDetails() {
+		return DeviceListPaginator{}, errors.New("filtering Devices requires DeviceDetailsFormat")
+	}
+	callURL, err := url.Parse(s.appEngineURL.String())
+	if err != nil {
+		return DeviceListPaginator{}, err
+	}
+	callURL.Path = path.Join(callURL.Path, fmt.Sprintf("/v1/%s/devices", realm))
+
+	paginator := newDeviceListPaginator(s.client, callURL, pageSize, format)
+	paginator.filter = &filter
+	return paginator, nil
+}
+
+// DeviceListIterator iterates over the Devices of a Realm matching a DeviceListFilter, retrieving them a page at a time.
+// Call Next to advance to the next matching Device, and Err once Next returns false to check whether the iteration
+// stopped because of an error.
+type DeviceListIterator struct {
+	paginator DeviceListPaginator
+	page      []DeviceDetails
+	device    DeviceDetails
+	err       error
+}
+
+// GetFilteredDeviceListIterator returns a DeviceListIterator over the Devices of realm matching filter.
+// As in GetFilteredDeviceListPaginator, Devices are filtered client-side: the iteration always goes through the
+// whole Device list.
+func (s *AppEngineService) GetFilteredDeviceListIterator(realm string, pageSize int, filter DeviceListFilter) (*DeviceListIterator, error) {
+	paginator, err := s.GetFilteredDeviceListPaginator(realm, pageSize, DeviceDetailsFormat, filter)
+	if err != nil {
+		return nil, err
+	}
+	return &DeviceListIterator{paginator: paginator}, nil
+}
+
+// Next advances the iterator to the next matching Device, and returns false when there are no more matching
+// Devices or an error occurred.
+func (i *DeviceListIterator) Next() bool {
+	if i.err != nil {
+		return false
+	}
+	for {
+		if len(i.page) > 0 {
+			i.device = i.page[0]
+			i.page = i.page[1:]
+			return true
+		}
+		if !i.paginator.HasNextPage() {
+			return false
+		}
+		page := []DeviceDetails{}
+		if i.err = i.paginator.GetNextPage(&page); i.err != nil {
+			return false
+		}
+		i.page = page
+	}
+}
+
+// Device returns the current Device. It must be called only after Next returned true.
+func (i *DeviceListIterator) Device() DeviceDetails {
+	return i.device
+}
+
+// Err returns the error which stopped the iteration, if any.
+func (i *DeviceListIterator) Err() error {
+	return i.err
+}
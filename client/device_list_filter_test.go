@@ -0,0 +1,93 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFilteredDeviceListIterator(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	connected := true
+	inhibited := true
+	major := 0
+	filters := []struct {
+		filter   DeviceListFilter
+		expected []string
+	}{
+		{DeviceListFilter{}, testDevices},
+		{DeviceListFilter{Connected: &connected}, testDevices[:1]},
+		{DeviceListFilter{CredentialsInhibited: &inhibited}, testDevices[1:2]},
+		{DeviceListFilter{Attributes: map[string]string{"site": "north"}}, []string{testDevices[0], testDevices[2]}},
+		{DeviceListFilter{InterfaceName: testAggregateInterfaceName}, testDevices[:2]},
+		{DeviceListFilter{InterfaceName: testAggregateInterfaceName, InterfaceMajor: &major}, testDevices[:1]},
+		{DeviceListFilter{NotSeenSince: time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC)}, testDevices[2:]},
+	}
+
+	for _, f := range filters {
+		iterator, err := client.AppEngine.GetFilteredDeviceListIterator(testRealmName, 100, f.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		devices := []string{}
+		for iterator.Next() {
+			devices = append(devices, iterator.Device().DeviceID)
+		}
+		if err := iterator.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(devices, f.expected) {
+			t.Errorf("filter %+v matched %v, expected %v", f.filter, devices, f.expected)
+		}
+	}
+}
+
+func TestFilteredDeviceListPaginator(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	disconnected := false
+	filter := DeviceListFilter{Connected: &disconnected, Attributes: map[string]string{"site": "north"}}
+	paginator, err := client.AppEngine.GetFilteredDeviceListPaginator(testRealmName, 2, DeviceDetailsFormat, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices := []string{}
+	for paginator.HasNextPage() {
+		page := []DeviceDetails{}
+		if err := paginator.GetNextPage(&page); err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range page {
+			devices = append(devices, d.DeviceID)
+		}
+	}
+	if !reflect.DeepEqual(devices, testDevices[2:]) {
+		t.Errorf("unexpected devices %v", devices)
+	}
+
+	// Every filter is matched client-side, so none of them works with DeviceIDFormat
+	if _, err := client.AppEngine.GetFilteredDeviceListPaginator(testRealmName, 2, DeviceIDFormat, filter); err == nil {
+		t.Error("filter accepted with DeviceIDFormat")
+	}
+	if _, err := client.AppEngine.GetFilteredDeviceListPaginator(testRealmName, 2, DeviceIDFormat,
+		DeviceListFilter{}); err != nil {
+		t.Error(err)
+	}
+}
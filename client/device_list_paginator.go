@@ -41,6 +41,18 @@ type DeviceListPaginator struct {
 	pageSize    int
 	client      *Client
 	hasNextPage bool
+	filter      *DeviceListFilter
+}
+
+func newDeviceListPaginator(client *Client, baseURL *url.URL, pageSize int, format DeviceResultFormat) DeviceListPaginator {
+	return DeviceListPaginator{
+		baseURL:     baseURL,
+		nextQuery:   url.Values{},
+		format:      format,
+		pageSize:    pageSize,
+		client:      client,
+		hasNextPage: true,
+	}
 }
 
 // Rewind rewinds the simulator to the first page. GetNextPage will then return the first page of the call.
@@ -84,10 +96,24 @@ func (d *DeviceListPaginator) GetNextPage(pagePtr interface{}) error {
 	}
 
 	d.computePageState(&links)
+	if d.filter != nil && d.format == DeviceDetailsFormat {
+		d.filterPage(pagePtr.(*[]DeviceDetails))
+	}
 
 	return nil
 }
 
+// filterPage removes from page the Devices which do not match the filter
+func (d *DeviceListPaginator) filterPage(page *[]DeviceDetails) {
+	filtered := (*page)[:0]
+	for _, device := range *page {
+		if d.filter.Match(device) {
+			filtered = append(filtered, device)
+		}
+	}
+	*page = filtered
+}
+
 func (d *DeviceListPaginator) checkPageFormat(pagePtr interface{}) error {
 	switch d.format {
 	case DeviceIDFormat:
@@ -0,0 +1,459 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DeviceManifestFormat represents the format of a device manifest.
+type DeviceManifestFormat int
+
+const (
+	// JSONDeviceManifestFormat is a JSON array of DeviceManifestEntry.
+	JSONDeviceManifestFormat DeviceManifestFormat = iota
+	// CSVDeviceManifestFormat is a CSV with a header. The device_id column is mandatory. Optional columns are
+	// credentials_inhibited, groups (separated by ';'), alias:<tag> for each alias tag and attribute:<key> for
+	// each attribute key. Empty cells are not managed by the manifest.
+	CSVDeviceManifestFormat
+)
+
+// DeviceManifestEntry describes the desired administrative state of a Device. Only the aliases, attributes and
+// groups listed in the entry are managed: other aliases, attributes and group memberships of the Device are left
+// untouched. If CredentialsInhibited is nil, the inhibition state is not managed.
+type DeviceManifestEntry struct {
+	DeviceID             string            `json:"device_id"`
+	Aliases              map[string]string `json:"aliases,omitempty"`
+	Attributes           map[string]string `json:"attributes,omitempty"`
+	Groups               []string          `json:"groups,omitempty"`
+	CredentialsInhibited *bool             `json:"credentials_inhibited,omitempty"`
+}
+
+// DeviceManifestActionType represents the kind of change needed to reconcile a Device with its manifest entry.
+type DeviceManifestActionType int
+
+const (
+	// SetAliasAction sets the alias with tag Key to Value.
+	SetAliasAction DeviceManifestActionType = iota
+	// SetAttributeAction sets the attribute Key to Value.
+	SetAttributeAction
+	// CreateGroupAction creates the group Key with the Device as its first member.
+	CreateGroupAction
+	// AddToGroupAction adds the Device to the group Key.
+	AddToGroupAction
+	// SetCredentialsInhibitedAction sets the Credentials Inhibition state to Value, either "true" or "false".
+	SetCredentialsInhibitedAction
+)
+
+// DeviceManifestAction is a single change needed to reconcile a Device with its manifest entry.
+type DeviceManifestAction struct {
+	Type  DeviceManifestActionType
+	Key   string
+	Value string
+}
+
+func (a DeviceManifestAction) String() string {
+	switch a.Type {
+	case SetAliasAction:
+		return fmt.Sprintf("set alias %s=%s", a.Key, a.Value)
+	case SetAttributeAction:
+		return fmt.Sprintf("set attribute %s=%s", a.Key, a.Value)
+	case CreateGroupAction:
+		return fmt.Sprintf("create group %s", a.Key)
+	case AddToGroupAction:
+		return fmt.Sprintf("add to group %s", a.Key)
+	case SetCredentialsInhibitedAction:
+		return fmt.Sprintf("set credentials inhibited to %s", a.Value)
+	}
+	return "unknown action"
+}
+
+// DeviceManifestRowResult is the outcome of the reconciliation of a single manifest entry. Row is the index of
+// the entry in the manifest, starting from 1. Actions are the changes needed (or applied) to reconcile the Device:
+// if Err is not nil, only the actions before the failed one were applied.
+type DeviceManifestRowResult struct {
+	Row      int
+	DeviceID string
+	Actions  []DeviceManifestAction
+	Err      error
+}
+
+// DeviceManifestReport summarizes the reconciliation of a manifest. Rows are ordered by Row.
+type DeviceManifestReport struct {
+	Rows   []DeviceManifestRowResult
+	DryRun bool
+}
+
+// Failed returns the results of all failed rows.
+func (r DeviceManifestReport) Failed() []DeviceManifestRowResult {
+	failed := []DeviceManifestRowResult{}
+	for _, row := range r.Rows {
+		if row.Err != nil {
+			failed = append(failed, row)
+		}
+	}
+	return failed
+}
+
+// Write writes a human readable plan, or outcome if the report is not a dry run, to w.
+func (r DeviceManifestReport) Write(w io.Writer) error {
+	verb := "applied"
+	if r.DryRun {
+		verb = "planned"
+	}
+	for _, row := range r.Rows {
+		if len(row.Actions) == 0 && row.Err == nil {
+			if _, err := fmt.Fprintf(w, "row %d %s: up to date\n", row.Row, row.DeviceID); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, action := range row.Actions {
+			if _, err := fmt.Fprintf(w, "row %d %s: %s %s\n", row.Row, row.DeviceID, verb, action); err != nil {
+				return err
+			}
+		}
+		if row.Err != nil {
+			if _, err := fmt.Fprintf(w, "row %d %s: error: %v\n", row.Row, row.DeviceID, row.Err); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DeviceManifestOptions configures the reconciliation of a manifest.
+type DeviceManifestOptions struct {
+	// Concurrency is the maximum number of Devices reconciled concurrently. If <= 0, a default concurrency is used.
+	Concurrency int
+	// DryRun only computes the actions needed to reconcile each Device, without applying them.
+	DryRun bool
+}
+
+// ParseDeviceManifest reads a manifest from r.
+func ParseDeviceManifest(r io.Reader, format DeviceManifestFormat) ([]DeviceManifestEntry, error) {
+	switch format {
+	case JSONDeviceManifestFormat:
+		entries := []DeviceManifestEntry{}
+		if err := json.NewDecoder(r).Decode(&entries); err != nil {
+			return nil, err
+		}
+		return entries, nil
+	case CSVDeviceManifestFormat:
+		return parseCSVDeviceManifest(r)
+	}
+	return nil, errors.New("invalid manifest format")
+}
+
+// parseCSVDeviceManifest parses a CSV manifest. Errors report the line where the offending record starts.
+func parseCSVDeviceManifest(r io.Reader) ([]DeviceManifestEntry, error) {
+	lineReader := &csvLineReader{r: bufio.NewReader(r)}
+	reader := csv.NewReader(lineReader)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	deviceIDColumn, err := csvDeviceManifestIDColumn(header)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", lineReader.recordLine(header), err)
+	}
+
+	entries := []DeviceManifestEntry{}
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			// csv.ParseError already reports its line
+			return nil, err
+		}
+		entry, err := parseCSVDeviceManifestRecord(header, fields, deviceIDColumn)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineReader.recordLine(fields), err)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// csvLineReader passes its input to a csv.Reader at most one line at a time. Since csv.Reader reads line by line, it
+// never buffers past the end of the record it returns, so that the lines read so far, including the empty ones which
+// csv.Reader skips, locate that record.
+type csvLineReader struct {
+	r     *bufio.Reader
+	lines int
+	last  byte
+}
+
+func (l *csvLineReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		b, err := l.r.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		p[n], l.last = b, b
+		n++
+		if b == '\n' {
+			l.lines++
+			break
+		}
+	}
+	return n, nil
+}
+
+// recordLine returns the line where the record just read starts
+func (l *csvLineReader) recordLine(fields []string) int {
+	endLine := l.lines
+	if l.last != '\n' {
+		// The last line of the input has no line break
+		endLine++
+	}
+	return endLine - csvRecordLines(fields) + 1
+}
+
+// csvDeviceManifestIDColumn checks the columns of header, and returns the index of the device_id one
+func csvDeviceManifestIDColumn(header []string) (int, error) {
+	deviceIDColumn := -1
+	for i, column := range header {
+		switch {
+		case column == "device_id":
+			deviceIDColumn = i
+		case column == "credentials_inhibited", column == "groups", strings.HasPrefix(column, "alias:"),
+			strings.HasPrefix(column, "attribute:"):
+		default:
+			return -1, fmt.Errorf("unknown column %s", column)
+		}
+	}
+	if deviceIDColumn < 0 {
+		return -1, errors.New("missing device_id column")
+	}
+	return deviceIDColumn, nil
+}
+
+// csvRecordLines returns how many lines a CSV record spans
+func csvRecordLines(fields []string) int {
+	lines := 1
+	for _, field := range fields {
+		lines += strings.Count(field, "\n")
+	}
+	return lines
+}
+
+func parseCSVDeviceManifestRecord(header, fields []string, deviceIDColumn int) (DeviceManifestEntry, error) {
+	entry := DeviceManifestEntry{DeviceID: fields[deviceIDColumn], Aliases: map[string]string{}, Attributes: map[string]string{}}
+	for i, column := range header {
+		value := fields[i]
+		if value == "" {
+			continue
+		}
+		switch {
+		case column == "credentials_inhibited":
+			inhibited, err := strconv.ParseBool(value)
+			if err != nil {
+				return entry, fmt.Errorf("invalid credentials_inhibited %q", value)
+			}
+			entry.CredentialsInhibited = &inhibited
+		case column == "groups":
+			entry.Groups = splitCSVList(value)
+		case strings.HasPrefix(column, "alias:"):
+			entry.Aliases[strings.TrimPrefix(column, "alias:")] = value
+		case strings.HasPrefix(column, "attribute:"):
+			entry.Attributes[strings.TrimPrefix(column, "attribute:")] = value
+		}
+	}
+	return entry, nil
+}
+
+// splitCSVList splits a ;-separated list, trimming its elements and dropping the empty ones
+func splitCSVList(value string) []string {
+	elements := []string{}
+	for _, element := range strings.Split(value, ";") {
+		if element = strings.TrimSpace(element); element != "" {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
+
+// ApplyDeviceManifest reconciles the Devices of realm with entries. Each Device is compared with its entry and only
+// the needed changes are applied, so that applying the same manifest again is a no-op. Groups which do not exist are
+// created. A failure on a single entry does not stop the reconciliation, and is reported in the returned
+// DeviceManifestReport; an error is returned only if the groups of the Realm cannot be listed.
+func (s *AppEngineService) ApplyDeviceManifest(realm string, entries []DeviceManifestEntry, options DeviceManifestOptions) (DeviceManifestReport, error) {
+	if options.Concurrency <= 0 {
+		options.Concurrency = defaultGroupConcurrency
+	}
+	rows, err := s.planDeviceManifest(realm, entries, options.Concurrency)
+	if err != nil {
+		return DeviceManifestReport{}, err
+	}
+	report := DeviceManifestReport{Rows: rows, DryRun: options.DryRun}
+	if options.DryRun {
+		return report, nil
+	}
+
+	// Groups must exist before any other Device is added to them
+	s.createDeviceManifestGroups(realm, rows)
+	s.forEachManifestRow(rows, options.Concurrency, func(row *DeviceManifestRowResult) {
+		if row.Err != nil {
+			return
+		}
+		for j, action := range row.Actions {
+			if err := s.applyDeviceManifestAction(realm, row.DeviceID, action); err != nil {
+				row.Err = err
+				row.Actions = row.Actions[:j]
+				return
+			}
+		}
+	})
+
+	return report, nil
+}
+
+// planDeviceManifest plans all rows, then makes the first Device which should belong to each missing group create it
+func (s *AppEngineService) planDeviceManifest(realm string, entries []DeviceManifestEntry, concurrency int) ([]DeviceManifestRowResult, error) {
+	groups, err := s.ListGroups(realm)
+	if err != nil {
+		return nil, err
+	}
+	existingGroups := map[string]bool{}
+	for _, group := range groups {
+		existingGroups[group] = true
+	}
+
+	rows := make([]DeviceManifestRowResult, len(entries))
+	s.forEachManifestRow(rows, concurrency, func(row *DeviceManifestRowResult) {
+		row.DeviceID = entries[row.Row-1].DeviceID
+		row.Actions, row.Err = s.planDeviceManifestEntry(realm, entries[row.Row-1])
+	})
+	for i := range rows {
+		for j, action := range rows[i].Actions {
+			if action.Type == AddToGroupAction && !existingGroups[action.Key] {
+				rows[i].Actions[j].Type = CreateGroupAction
+				existingGroups[action.Key] = true
+			}
+		}
+	}
+	return rows, nil
+}
+
+// createDeviceManifestGroups applies the CreateGroupActions of rows. If creating a group fails, the row fails, and
+// none of its other actions is applied.
+func (s *AppEngineService) createDeviceManifestGroups(realm string, rows []DeviceManifestRowResult) {
+	for i := range rows {
+		created := []DeviceManifestAction{}
+		for _, action := range rows[i].Actions {
+			if action.Type != CreateGroupAction {
+				continue
+			}
+			if err := s.CreateGroup(realm, action.Key, []string{rows[i].DeviceID}, AstarteDeviceID); err != nil {
+				rows[i].Err = err
+				rows[i].Actions = created
+				break
+			}
+			created = append(created, action)
+		}
+	}
+}
+
+func (s *AppEngineService) forEachManifestRow(rows []DeviceManifestRowResult, concurrency int, f func(*DeviceManifestRowResult)) {
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, concurrency)
+	for i := range rows {
+		if rows[i].Row == 0 {
+			rows[i].Row = i + 1
+		}
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(row *DeviceManifestRowResult) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			f(row)
+		}(&rows[i])
+	}
+	wg.Wait()
+}
+
+func (s *AppEngineService) planDeviceManifestEntry(realm string, entry DeviceManifestEntry) ([]DeviceManifestAction, error) {
+	if entry.DeviceID == "" {
+		return nil, errors.New("missing device_id")
+	}
+	details, err := s.GetDevice(realm, entry.DeviceID, AstarteDeviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	actions := []DeviceManifestAction{}
+	for _, tag := range sortedKeys(entry.Aliases) {
+		if details.Aliases[tag] != entry.Aliases[tag] {
+			actions = append(actions, DeviceManifestAction{Type: SetAliasAction, Key: tag, Value: entry.Aliases[tag]})
+		}
+	}
+	for _, key := range sortedKeys(entry.Attributes) {
+		if value, ok := details.Attributes[key]; !ok || value != entry.Attributes[key] {
+			actions = append(actions, DeviceManifestAction{Type: SetAttributeAction, Key: key, Value: entry.Attributes[key]})
+		}
+	}
+	memberOf := map[string]bool{}
+	for _, group := range details.Groups {
+		memberOf[group] = true
+	}
+	for _, group := range entry.Groups {
+		if !memberOf[group] {
+			memberOf[group] = true
+			actions = append(actions, DeviceManifestAction{Type: AddToGroupAction, Key: group})
+		}
+	}
+	if entry.CredentialsInhibited != nil && details.CredentialsInhibited != *entry.CredentialsInhibited {
+		actions = append(actions, DeviceManifestAction{Type: SetCredentialsInhibitedAction, Value: strconv.FormatBool(*entry.CredentialsInhibited)})
+	}
+
+	return actions, nil
+}
+
+func (s *AppEngineService) applyDeviceManifestAction(realm, deviceID string, action DeviceManifestAction) error {
+	switch action.Type {
+	case SetAliasAction:
+		return s.AddDeviceAlias(realm, deviceID, action.Key, action.Value)
+	case SetAttributeAction:
+		return s.SetDeviceAttribute(realm, deviceID, AstarteDeviceID, action.Key, action.Value)
+	case CreateGroupAction:
+		// Already applied
+		return nil
+	case AddToGroupAction:
+		return s.AddDeviceToGroup(realm, action.Key, deviceID, AstarteDeviceID)
+	case SetCredentialsInhibitedAction:
+		return s.InhibitDevice(realm, deviceID, AstarteDeviceID, action.Value == "true")
+	}
+	return errors.New("unknown action")
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,137 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyDeviceManifest(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	manifest := strings.Join([]string{
+		"device_id,alias:name,attribute:site,groups,credentials_inhibited",
+		// Up to date
+		fmt.Sprintf("%s,sensor-a,north,%s,false", testDevices[0], testGroupName),
+		// New alias, existing group and a new one, inhibition change
+		fmt.Sprintf("%s,sensor-c,south,%s;new-group,false", testDevices[1], testGroupName),
+		// Also in the new group, which must be created once
+		fmt.Sprintf("%s,,,new-group,", testDevices[2]),
+		// Unknown device
+		"QwtRYCkWTmiuoQCtYjdA1g,,,,true",
+	}, "\n")
+	entries, err := ParseDeviceManifest(strings.NewReader(manifest), CSVDeviceManifestFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := client.AppEngine.ApplyDeviceManifest(testRealmName, entries, DeviceManifestOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests := popTestWriteRequests(); len(requests) != 0 {
+		t.Errorf("dry run sent requests %v", requests)
+	}
+	expectedActions := [][]DeviceManifestAction{
+		{},
+		{
+			{Type: SetAliasAction, Key: "name", Value: "sensor-c"},
+			{Type: AddToGroupAction, Key: testGroupName},
+			{Type: CreateGroupAction, Key: "new-group"},
+			{Type: SetCredentialsInhibitedAction, Value: "false"},
+		},
+		{{Type: AddToGroupAction, Key: "new-group"}},
+		nil,
+	}
+	for i, row := range plan.Rows {
+		if row.Row != i+1 || !reflect.DeepEqual(row.Actions, expectedActions[i]) {
+			t.Errorf("unexpected plan for row %v: %+v", i+1, row)
+		}
+	}
+	if failed := plan.Failed(); len(failed) != 1 || failed[0].Row != 4 {
+		t.Errorf("unexpected failures %+v", failed)
+	}
+	output := &bytes.Buffer{}
+	if err := plan.Write(output); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output.String(), "row 2 "+testDevices[1]+": planned create group new-group") {
+		t.Errorf("unexpected plan output %s", output)
+	}
+
+	report, err := client.AppEngine.ApplyDeviceManifest(testRealmName, entries, DeviceManifestOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Failed()) != 1 {
+		t.Errorf("unexpected failures %+v", report.Failed())
+	}
+	requests := popTestWriteRequests()
+	if len(requests) != 5 {
+		t.Fatalf("unexpected requests %v", requests)
+	}
+	// The group is created before any other request
+	if requests[0].Method != "POST" || requests[0].Path != fmt.Sprintf("/appengine/v1/%s/groups", testRealmName) {
+		t.Errorf("unexpected first request %v", requests[0])
+	}
+}
+
+func TestParseCSVDeviceManifestErrors(t *testing.T) {
+	manifest := strings.Join([]string{
+		"device_id,attribute:notes,credentials_inhibited",
+		fmt.Sprintf("%s,\"two\nlines\",false", testDevices[0]),
+		fmt.Sprintf("%s,,maybe", testDevices[1]),
+	}, "\n")
+	if _, err := ParseDeviceManifest(strings.NewReader(manifest), CSVDeviceManifestFormat); err == nil ||
+		!strings.HasPrefix(err.Error(), "line 4:") {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if _, err := ParseDeviceManifest(strings.NewReader("device_id,color\n"), CSVDeviceManifestFormat); err == nil ||
+		!strings.HasPrefix(err.Error(), "line 1:") {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// Empty lines are counted too
+	manifest = strings.Join([]string{
+		"device_id,credentials_inhibited",
+		"",
+		fmt.Sprintf("%s,false", testDevices[0]),
+		"",
+		"",
+		fmt.Sprintf("%s,maybe", testDevices[1]),
+		"",
+	}, "\n")
+	if _, err := ParseDeviceManifest(strings.NewReader(manifest), CSVDeviceManifestFormat); err == nil ||
+		!strings.HasPrefix(err.Error(), "line 6:") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestParseCSVDeviceManifestGroups(t *testing.T) {
+	manifest := fmt.Sprintf("device_id,groups\n%s,\"a; b ;;\"\n", testDevices[0])
+	entries, err := ParseDeviceManifest(strings.NewReader(manifest), CSVDeviceManifestFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !reflect.DeepEqual(entries[0].Groups, []string{"a", "b"}) {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"time"
+)
+
+// DeviceEventType represents the kind of change detected by a DeviceMonitor.
+type DeviceEventType int
+
+const (
+	// DeviceConnectedEvent means the Device connected since the previous sweep.
+	DeviceConnectedEvent DeviceEventType = iota
+	// DeviceDisconnectedEvent means the Device disconnected since the previous sweep.
+	DeviceDisconnectedEvent
+	// DeviceRegisteredEvent means the Device was not in the Realm during the previous sweep.
+	DeviceRegisteredEvent
+	// DeviceCredentialsInhibitedEvent means the Device credentials were inhibited since the previous sweep.
+	DeviceCredentialsInhibitedEvent
+	// DeviceIntrospectionChangedEvent means the Device introspection changed since the previous sweep.
+	DeviceIntrospectionChangedEvent
+)
+
+func (t DeviceEventType) String() string {
+	switch t {
+	case DeviceConnectedEvent:
+		return "connected"
+	case DeviceDisconnectedEvent:
+		return "disconnected"
+	case DeviceRegisteredEvent:
+		return "registered"
+	case DeviceCredentialsInhibitedEvent:
+		return "credentials_inhibited"
+	case DeviceIntrospectionChangedEvent:
+		return "introspection_changed"
+	}
+	return fmt.Sprintf("DeviceEventType(%d)", int(t))
+}
+
+// DeviceEvent is a change in the state of a Device, detected by a DeviceMonitor. Timestamp is the time at which
+// the change happened when Astarte reports it (connections, disconnections and registrations), otherwise it is the
+// time of the sweep which detected the change. Details are the DeviceDetails retrieved by that sweep.
+type DeviceEvent struct {
+	Type      DeviceEventType
+	DeviceID  string
+	Timestamp time.Time
+	Details   DeviceDetails
+}
+
+// deviceMonitorState is the compact state kept for each Device between sweeps
+type deviceMonitorState struct {
+	connected         bool
+	inhibited         bool
+	lastConnection    time.Time
+	lastDisconnection time.Time
+	introspectionHash uint64
+}
+
+// DeviceMonitor detects changes in the state of the Devices of a Realm, by periodically sweeping the Device list
+// and comparing it with the previous sweep. Only a compact state is kept for each Device, so that a DeviceMonitor
+// can be used on Realms with a large number of Devices. A DeviceMonitor must not be used concurrently.
+type DeviceMonitor struct {
+	appEngine *AppEngineService
+	realm     string
+	pageSize  int
+	devices   map[string]deviceMonitorState
+}
+
+// NewDeviceMonitor returns a DeviceMonitor for the Devices of realm. If pageSize is <= 0, the default page size is used.
+func (s *AppEngineService) NewDeviceMonitor(realm string, pageSize int) *DeviceMonitor {
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	return &DeviceMonitor{appEngine: s, realm: realm, pageSize: pageSize}
+}
+
+// Sweep retrieves the whole Device list, and calls handler for each change since the previous sweep. The first
+// sweep only records the state of the Realm and emits no events. If the sweep fails, no events are emitted and the
+// state of the previous sweep is kept, so that changes will be detected by the next successful sweep.
+func (m *DeviceMonitor) Sweep(handler func(DeviceEvent)) error {
+	paginator, err := m.appEngine.GetDeviceListPaginator(m.realm, m.pageSize, DeviceDetailsFormat)
+	if err != nil {
+		return err
+	}
+
+	sweepTime := time.Now()
+	devices := make(map[string]deviceMonitorState, len(m.devices))
+	events := []DeviceEvent{}
+	for paginator.HasNextPage() {
+		page := []DeviceDetails{}
+		if err := paginator.GetNextPage(&page); err != nil {
+			return err
+		}
+		for _, details := range page {
+			state := newDeviceMonitorState(details)
+			devices[details.DeviceID] = state
+			if m.devices != nil {
+				events = append(events, diffDeviceMonitorState(m.devices, state, details, sweepTime)...)
+			}
+		}
+	}
+
+	m.devices = devices
+	for _, event := range events {
+		handler(event)
+	}
+	return nil
+}
+
+// Run sweeps the Device list every interval, calling handler with all detected changes, until ctx is canceled.
+// Errors are passed to onError, if not nil, and do not stop the monitor. Run returns ctx.Err().
+func (m *DeviceMonitor) Run(ctx context.Context, interval time.Duration, handler func(DeviceEvent), onError func(error)) error {
+	wait := time.Duration(0)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+
+		if err := m.Sweep(handler); err != nil && onError != nil {
+			onError(err)
+		}
+		wait = interval
+	}
+}
+
+func newDeviceMonitorState(details DeviceDetails) deviceMonitorState {
+	introspection := []string{}
+	for name, i := range details.Introspection {
+		introspection = append(introspection, fmt.Sprintf("%s:%d.%d", name, i.Major, i.Minor))
+	}
+	sort.Strings(introspection)
+	hash := fnv.New64a()
+	for _, i := range introspection {
+		_, _ = hash.Write([]byte(i))
+		_, _ = hash.Write([]byte{0})
+	}
+
+	return deviceMonitorState{
+		connected:         details.Connected,
+		inhibited:         details.CredentialsInhibited,
+		lastConnection:    details.LastConnection,
+		lastDisconnection: details.LastDisconnection,
+		introspectionHash: hash.Sum64(),
+	}
+}
+
+// newDeviceEvent returns an event of a Device found by the sweep at sweepTime, which happened at timestamp, or at
+// sweepTime if timestamp is zero
+func newDeviceEvent(eventType DeviceEventType, details DeviceDetails, timestamp, sweepTime time.Time) DeviceEvent {
+	if timestamp.IsZero() {
+		timestamp = sweepTime
+	}
+	return DeviceEvent{Type: eventType, DeviceID: details.DeviceID, Timestamp: timestamp, Details: details}
+}
+
+func diffDeviceMonitorState(previousDevices map[string]deviceMonitorState, state deviceMonitorState, details DeviceDetails,
+	sweepTime time.Time) []DeviceEvent {
+	previous, ok := previousDevices[details.DeviceID]
+	if !ok {
+		events := []DeviceEvent{newDeviceEvent(DeviceRegisteredEvent, details, details.FirstRegistration, sweepTime)}
+		if state.connected {
+			events = append(events, newDeviceEvent(DeviceConnectedEvent, details, state.lastConnection, sweepTime))
+		}
+		return events
+	}
+
+	events := diffDeviceConnection(previous, state, details, sweepTime)
+	events = append(events, diffDeviceCredentials(previous, state, details, sweepTime)...)
+	return append(events, diffDeviceIntrospection(previous, state, details, sweepTime)...)
+}
+
+// diffDeviceConnection returns the connection and disconnection events between two sweeps
+func diffDeviceConnection(previous, state deviceMonitorState, details DeviceDetails, sweepTime time.Time) []DeviceEvent {
+	// A Device might have connected and disconnected (or vice versa) between two sweeps:
+	// rely on timestamps rather than on the connection status, and order events accordingly
+	connected := state.lastConnection.After(previous.lastConnection) || (state.connected && !previous.connected)
+	disconnected := state.lastDisconnection.After(previous.lastDisconnection) || (!state.connected && previous.connected)
+	connectedEvent := newDeviceEvent(DeviceConnectedEvent, details, state.lastConnection, sweepTime)
+	disconnectedEvent := newDeviceEvent(DeviceDisconnectedEvent, details, state.lastDisconnection, sweepTime)
+	switch {
+	case connected && disconnected && state.connected:
+		return []DeviceEvent{disconnectedEvent, connectedEvent}
+	case connected && disconnected:
+		return []DeviceEvent{connectedEvent, disconnectedEvent}
+	case connected:
+		return []DeviceEvent{connectedEvent}
+	case disconnected:
+		return []DeviceEvent{disconnectedEvent}
+	}
+	return []DeviceEvent{}
+}
+
+// diffDeviceCredentials returns the credentials inhibition event between two sweeps, if any
+func diffDeviceCredentials(previous, state deviceMonitorState, details DeviceDetails, sweepTime time.Time) []DeviceEvent {
+	if state.inhibited && !previous.inhibited {
+		return []DeviceEvent{newDeviceEvent(DeviceCredentialsInhibitedEvent, details, sweepTime, sweepTime)}
+	}
+	return nil
+}
+
+// diffDeviceIntrospection returns the introspection change event between two sweeps, if any
+func diffDeviceIntrospection(previous, state deviceMonitorState, details DeviceDetails, sweepTime time.Time) []DeviceEvent {
+	if state.introspectionHash != previous.introspectionHash {
+		return []DeviceEvent{newDeviceEvent(DeviceIntrospectionChangedEvent, details, sweepTime, sweepTime)}
+	}
+	return nil
+}
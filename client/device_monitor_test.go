@@ -0,0 +1,125 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeviceMonitorDiff(t *testing.T) {
+	start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	sweepTime := start.Add(time.Hour)
+	previous := DeviceDetails{
+		DeviceID:          testDevices[0],
+		Connected:         true,
+		LastConnection:    start,
+		FirstRegistration: start,
+		Introspection:     map[string]DeviceInterfaceIntrospection{"org.astarte-platform.genericsensors.Values": {Major: 0, Minor: 1}},
+	}
+	previousDevices := map[string]deviceMonitorState{previous.DeviceID: newDeviceMonitorState(previous)}
+
+	// Disconnected and connected again between sweeps, with inhibited credentials and a new introspection
+	current := previous
+	current.LastDisconnection = start.Add(10 * time.Minute)
+	current.LastConnection = start.Add(20 * time.Minute)
+	current.CredentialsInhibited = true
+	current.Introspection = map[string]DeviceInterfaceIntrospection{"org.astarte-platform.genericsensors.Values": {Major: 0, Minor: 2}}
+	events := diffDeviceMonitorState(previousDevices, newDeviceMonitorState(current), current, sweepTime)
+
+	expected := []DeviceEvent{
+		{Type: DeviceDisconnectedEvent, Timestamp: current.LastDisconnection},
+		{Type: DeviceConnectedEvent, Timestamp: current.LastConnection},
+		{Type: DeviceCredentialsInhibitedEvent, Timestamp: sweepTime},
+		{Type: DeviceIntrospectionChangedEvent, Timestamp: sweepTime},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("unexpected events %v", events)
+	}
+	for i, event := range events {
+		if event.Type != expected[i].Type || !event.Timestamp.Equal(expected[i].Timestamp) || event.DeviceID != current.DeviceID {
+			t.Errorf("expected %v at %v, got %v at %v", expected[i].Type, expected[i].Timestamp, event.Type, event.Timestamp)
+		}
+	}
+
+	// Unchanged
+	if events := diffDeviceMonitorState(previousDevices, newDeviceMonitorState(previous), previous, sweepTime); len(events) != 0 {
+		t.Errorf("unexpected events %v", events)
+	}
+
+	// Newly registered
+	registered := DeviceDetails{DeviceID: testDevices[1], FirstRegistration: start.Add(30 * time.Minute)}
+	events = diffDeviceMonitorState(previousDevices, newDeviceMonitorState(registered), registered, sweepTime)
+	if len(events) != 1 || events[0].Type != DeviceRegisteredEvent || !events[0].Timestamp.Equal(registered.FirstRegistration) {
+		t.Errorf("unexpected events %v", events)
+	}
+}
+
+func TestDeviceMonitorSweep(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	monitor := client.AppEngine.NewDeviceMonitor(testRealmName, 2)
+	events := []DeviceEvent{}
+	handler := func(event DeviceEvent) {
+		events = append(events, event)
+	}
+	// The first sweep goes through all pages, and only records the state
+	if err := monitor.Sweep(handler); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 || len(monitor.devices) != len(testDevices) {
+		t.Fatalf("unexpected events %v with %d devices", events, len(monitor.devices))
+	}
+
+	// Make the previous sweep see the first Device disconnected, the second one with its credentials, and the third
+	// one missing
+	details := testDeviceDetails()
+	first := monitor.devices[testDevices[0]]
+	first.connected, first.lastConnection = false, details[0].LastConnection.Add(-time.Hour)
+	monitor.devices[testDevices[0]] = first
+	second := monitor.devices[testDevices[1]]
+	second.inhibited = false
+	monitor.devices[testDevices[1]] = second
+	delete(monitor.devices, testDevices[2])
+
+	if err := monitor.Sweep(handler); err != nil {
+		t.Fatal(err)
+	}
+	expected := []DeviceEvent{
+		{Type: DeviceConnectedEvent, DeviceID: testDevices[0], Timestamp: details[0].LastConnection},
+		{Type: DeviceCredentialsInhibitedEvent, DeviceID: testDevices[1]},
+		{Type: DeviceRegisteredEvent, DeviceID: testDevices[2], Timestamp: details[2].FirstRegistration},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("unexpected events %v", events)
+	}
+	for i, event := range events {
+		if event.Type != expected[i].Type || event.DeviceID != expected[i].DeviceID ||
+			(!expected[i].Timestamp.IsZero() && !event.Timestamp.Equal(expected[i].Timestamp)) {
+			t.Errorf("expected %v of %s, got %v of %s at %v", expected[i].Type, expected[i].DeviceID, event.Type,
+				event.DeviceID, event.Timestamp)
+		}
+	}
+
+	// The state of the second sweep is kept
+	events = []DeviceEvent{}
+	if err := monitor.Sweep(handler); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 || len(monitor.devices) != len(testDevices) {
+		t.Errorf("unexpected events %v with %d devices", events, len(monitor.devices))
+	}
+}
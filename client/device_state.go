@@ -0,0 +1,102 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"path"
+	"sync"
+
+	"github.com/astarte-platform/astarte-go/interfaces"
+)
+
+// DeviceInterfaceState represents the current state of a single Interface in a Device's introspection.
+// Depending on the Interface type and aggregation, only one among Properties, Datastreams and
+// AggregateDatastreams is populated. If retrieving the Interface or its values failed, Error is set.
+type DeviceInterfaceState struct {
+	Interface            interfaces.AstarteInterface
+	Properties           map[string]interface{}
+	Datastreams          map[string]DatastreamValue
+	AggregateDatastreams map[string]DatastreamAggregateValue
+	Error                error
+}
+
+// DeviceState represents a full snapshot of a Device: its DeviceDetails, and the state of each of the
+// Interfaces in its introspection, keyed by Interface name.
+type DeviceState struct {
+	Details    DeviceDetails
+	Interfaces map[string]DeviceInterfaceState
+}
+
+// GetDeviceState returns a snapshot of the Device's details and of the values of all Interfaces in its introspection.
+// Interface definitions are retrieved from RealmManagement, hence the Client must have a RealmManagementService
+// available. Interfaces are queried concurrently, and a failure on a single Interface is reported in its
+// DeviceInterfaceState rather than failing the whole call.
+func (s *AppEngineService) GetDeviceState(realm, deviceIdentifier string, deviceIdentifierType DeviceIdentifierType) (DeviceState, error) {
+	if s.client.RealmManagement == nil {
+		return DeviceState{}, errors.New("GetDeviceState requires a RealmManagement service")
+	}
+
+	details, err := s.GetDevice(realm, deviceIdentifier, deviceIdentifierType)
+	if err != nil {
+		return DeviceState{}, err
+	}
+
+	state := DeviceState{Details: details, Interfaces: make(map[string]DeviceInterfaceState, len(details.Introspection))}
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	for interfaceName, introspection := range details.Introspection {
+		wg.Add(1)
+		go func(interfaceName string, major int) {
+			defer wg.Done()
+			interfaceState := s.getDeviceInterfaceState(realm, details.DeviceID, interfaceName, major)
+			mutex.Lock()
+			state.Interfaces[interfaceName] = interfaceState
+			mutex.Unlock()
+		}(interfaceName, introspection.Major)
+	}
+	wg.Wait()
+
+	return state, nil
+}
+
+func (s *AppEngineService) getDeviceInterfaceState(realm, deviceID, interfaceName string, major int) DeviceInterfaceState {
+	iface, err := s.client.RealmManagement.GetInterface(realm, interfaceName, major)
+	if err != nil {
+		return DeviceInterfaceState{Error: err}
+	}
+
+	interfaceState := DeviceInterfaceState{Interface: iface}
+	switch {
+	case iface.Type == interfaces.PropertiesType:
+		interfaceState.Properties, interfaceState.Error = s.GetProperties(realm, deviceID, AstarteDeviceID, interfaceName)
+	case iface.Aggregation == interfaces.IndividualAggregation:
+		interfaceState.Datastreams, interfaceState.Error = s.GetDatastreamSnapshot(realm, deviceID, AstarteDeviceID, interfaceName)
+	case iface.IsParametric():
+		interfaceState.AggregateDatastreams, interfaceState.Error =
+			s.GetAggregateParametricDatastreamSnapshot(realm, deviceID, AstarteDeviceID, interfaceName)
+	default:
+		value, err := s.GetAggregateDatastreamSnapshot(realm, deviceID, AstarteDeviceID, interfaceName)
+		interfaceState.Error = err
+		interfaceState.AggregateDatastreams = map[string]DatastreamAggregateValue{}
+		// Non-parametric aggregates share the same parent path for all endpoints: use it as key,
+		// consistently with parametric snapshots. If there is no data, leave the map empty.
+		if err == nil && !value.Timestamp.IsZero() && len(iface.Mappings) > 0 {
+			interfaceState.AggregateDatastreams[path.Dir(iface.Mappings[0].Endpoint)] = value
+		}
+	}
+
+	return interfaceState
+}
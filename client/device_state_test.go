@@ -0,0 +1,118 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const testGeolocationInterface = `{
+	"interface_name": "org.astarte-platform.genericsensors.Geolocation",
+	"version_major": 0,
+	"version_minor": 1,
+	"type": "datastream",
+	"ownership": "device",
+	"aggregation": "object",
+	"mappings": [
+		{"endpoint": "/gps/latitude", "type": "double"},
+		{"endpoint": "/gps/longitude", "type": "double"}
+	]
+}`
+
+func TestGetDeviceState(t *testing.T) {
+	devicePath := fmt.Sprintf("/appengine/v1/%s/devices/%s", testRealmName, testDevices[2])
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case devicePath:
+			details := testDeviceDetails()[2]
+			details.Introspection = map[string]DeviceInterfaceIntrospection{
+				"org.astarte-platform.genericsensors.AvailableSensors": {Major: 0, Minor: 1},
+				"org.astarte-platform.genericsensors.Values":           {Major: 0, Minor: 1},
+				"org.astarte-platform.genericsensors.SamplingRate":     {Major: 0, Minor: 1},
+				testAggregateInterfaceName:                             {Major: 0, Minor: 1},
+				"org.example.Missing":                                  {Major: 1, Minor: 0},
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": details})
+		case fmt.Sprintf("/realmmanagement/v1/%s/interfaces/%s/0", testRealmName, testAggregateInterfaceName):
+			fmt.Fprintf(w, `{"data": %s}`, testGeolocationInterface)
+		case devicePath + "/interfaces/org.astarte-platform.genericsensors.AvailableSensors":
+			fmt.Fprint(w, `{"data": {"s1": {"name": "Temperature", "unit": "C"}}}`)
+		case devicePath + "/interfaces/org.astarte-platform.genericsensors.Values":
+			fmt.Fprint(w, `{"data": {"s1": {"value": {"value": 21.5, "timestamp": "2021-03-01T10:00:00Z"}}}}`)
+		case devicePath + "/interfaces/org.astarte-platform.genericsensors.SamplingRate":
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"errors": {"detail": "Internal server error"}}`)
+		case devicePath + "/interfaces/" + testAggregateInterfaceName:
+			aggregateDatastreamMock(w, req)
+		default:
+			astarteAPIMock(w, req)
+		}
+	}))
+	defer server.Close()
+	client, err := NewClient(server.URL, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken(testTokenValue)
+
+	state, err := client.AppEngine.GetDeviceState(testRealmName, testDevices[2], AstarteDeviceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Interfaces) != 5 || state.Details.DeviceID != testDevices[2] {
+		t.Fatalf("unexpected state %+v", state)
+	}
+
+	properties := state.Interfaces["org.astarte-platform.genericsensors.AvailableSensors"]
+	if properties.Error != nil || properties.Properties["/s1/name"] != "Temperature" || properties.Datastreams != nil {
+		t.Errorf("unexpected properties %+v", properties)
+	}
+	datastreams := state.Interfaces["org.astarte-platform.genericsensors.Values"]
+	if value := datastreams.Datastreams["/s1/value"]; datastreams.Error != nil || value.Value != 21.5 ||
+		!value.Timestamp.Equal(time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected datastreams %+v", datastreams)
+	}
+
+	// Object aggregates are keyed by the parent path of their mappings
+	aggregates := state.Interfaces[testAggregateInterfaceName]
+	value, ok := aggregates.AggregateDatastreams["/gps"]
+	if aggregates.Error != nil || len(aggregates.AggregateDatastreams) != 1 || !ok {
+		t.Fatalf("unexpected aggregates %+v", aggregates)
+	}
+	if latitude, _ := value.Values.Get("latitude"); latitude != float64(24) ||
+		!value.Timestamp.Equal(testAggregateSamplesStart.Add(24*time.Minute)) {
+		t.Errorf("unexpected aggregate %+v", value)
+	}
+
+	// Failures are reported for each Interface
+	if failed := state.Interfaces["org.astarte-platform.genericsensors.SamplingRate"]; !isAPIErrorStatus(failed.Error, http.StatusInternalServerError) ||
+		failed.Interface.Name != "org.astarte-platform.genericsensors.SamplingRate" {
+		t.Errorf("unexpected state %+v", failed)
+	}
+	if missing := state.Interfaces["org.example.Missing"]; !isNotFound(missing.Error) {
+		t.Errorf("unexpected state %+v", missing)
+	}
+}
+
+func isAPIErrorStatus(err error, statusCode int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == statusCode
+}
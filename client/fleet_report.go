@@ -0,0 +1,328 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultFleetReportTopTalkers = 10
+	defaultFleetReportStaleAfter = 7 * 24 * time.Hour
+)
+
+// FleetReportFormat represents the format a FleetReport is written in.
+type FleetReportFormat int
+
+const (
+	// JSONFleetReportFormat is an indented JSON object.
+	JSONFleetReportFormat FleetReportFormat = iota
+	// MarkdownFleetReportFormat is a Markdown document with a table for each section.
+	MarkdownFleetReportFormat
+)
+
+// FleetReportOptions configures the generation of a FleetReport.
+type FleetReportOptions struct {
+	// PageSize is the page size used to sweep the device list. If <= 0, the default page size is used.
+	PageSize int
+	// TopTalkers is the number of Devices listed as top talkers. If <= 0, 10 Devices are listed.
+	TopTalkers int
+	// StaleAfter is how long a disconnected Device must not be seen to be considered stale. If <= 0, 7 days are used.
+	StaleAfter time.Duration
+	// Now is the time the report refers to. If zero, the current time is used.
+	Now time.Time
+}
+
+// FleetDeviceTraffic is the traffic received from a single Device.
+type FleetDeviceTraffic struct {
+	DeviceID string `json:"device_id"`
+	Messages int64  `json:"messages"`
+	Bytes    uint64 `json:"bytes"`
+}
+
+// FleetInterfaceTraffic is the traffic exchanged on an Interface, summed over all its major versions and over all
+// Devices having it in their introspection.
+type FleetInterfaceTraffic struct {
+	Name     string `json:"name"`
+	Devices  int    `json:"devices"`
+	Messages uint64 `json:"messages"`
+	Bytes    uint64 `json:"bytes"`
+}
+
+// FleetDeviceTimestamp is a Device with a relevant timestamp, such as when it was last seen or registered.
+type FleetDeviceTimestamp struct {
+	DeviceID  string    `json:"device_id"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// FleetReport is a Realm-wide view of the health and traffic of Devices. Lists of Devices are sorted, and empty
+// lists are not nil.
+type FleetReport struct {
+	Realm       string    `json:"realm"`
+	GeneratedAt time.Time `json:"generated_at"`
+	// Stats are the statistics reported by AppEngine, the other fields are computed from the device list
+	Stats          DevicesStats `json:"stats"`
+	ScannedDevices int          `json:"scanned_devices"`
+	TotalMessages  int64        `json:"total_messages"`
+	TotalBytes     uint64       `json:"total_bytes"`
+	// TopTalkersByBytes and TopTalkersByMessages list the Devices which sent the most traffic, in descending order
+	TopTalkersByBytes    []FleetDeviceTraffic `json:"top_talkers_by_bytes"`
+	TopTalkersByMessages []FleetDeviceTraffic `json:"top_talkers_by_messages"`
+	// Interfaces are sorted by descending bytes
+	Interfaces []FleetInterfaceTraffic `json:"interfaces"`
+	// StaleSince is the time disconnected Devices must have been seen after not to be stale
+	StaleSince time.Time `json:"stale_since"`
+	// StaleDevices are disconnected Devices not seen since StaleSince, with their last seen time, oldest first
+	StaleDevices []FleetDeviceTimestamp `json:"stale_devices"`
+	// NeverConnectedDevices are registered Devices which never connected, with their registration time, oldest first
+	NeverConnectedDevices []FleetDeviceTimestamp `json:"never_connected_devices"`
+	// InhibitedDevices are the Device IDs of the Devices with inhibited credentials
+	InhibitedDevices []string `json:"inhibited_devices"`
+}
+
+// GenerateFleetReport sweeps the device list of realm with details and combines it with GetDevicesStats
+// into a FleetReport.
+func (s *AppEngineService) GenerateFleetReport(realm string, options FleetReportOptions) (FleetReport, error) {
+	stats, err := s.GetDevicesStats(realm)
+	if err != nil {
+		return FleetReport{}, err
+	}
+	paginator, err := s.GetDeviceListPaginator(realm, options.PageSize, DeviceDetailsFormat)
+	if err != nil {
+		return FleetReport{}, err
+	}
+
+	report := newFleetReport(realm, stats, options)
+	interfaces := map[string]*FleetInterfaceTraffic{}
+	traffic := []FleetDeviceTraffic{}
+	for paginator.HasNextPage() {
+		page := []DeviceDetails{}
+		if err := paginator.GetNextPage(&page); err != nil {
+			return FleetReport{}, err
+		}
+		for _, device := range page {
+			traffic = append(traffic, report.addDevice(device, interfaces))
+		}
+	}
+	report.finish(traffic, interfaces, options.TopTalkers)
+	return report, nil
+}
+
+func newFleetReport(realm string, stats DevicesStats, options FleetReportOptions) FleetReport {
+	now := options.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	staleAfter := options.StaleAfter
+	if staleAfter <= 0 {
+		staleAfter = defaultFleetReportStaleAfter
+	}
+	return FleetReport{
+		Realm:                 realm,
+		GeneratedAt:           now,
+		Stats:                 stats,
+		StaleSince:            now.Add(-staleAfter),
+		StaleDevices:          []FleetDeviceTimestamp{},
+		NeverConnectedDevices: []FleetDeviceTimestamp{},
+		InhibitedDevices:      []string{},
+	}
+}
+
+// addDevice accounts device in the report and in interfaces, and returns its traffic
+func (r *FleetReport) addDevice(device DeviceDetails, interfaces map[string]*FleetInterfaceTraffic) FleetDeviceTraffic {
+	r.ScannedDevices++
+	r.TotalMessages += device.TotalReceivedMessages
+	r.TotalBytes += device.TotalReceivedBytes
+
+	for name, introspection := range device.Introspection {
+		if introspection.Name != "" {
+			name = introspection.Name
+		}
+		if interfaces[name] == nil {
+			interfaces[name] = &FleetInterfaceTraffic{Name: name}
+		}
+		interfaces[name].Devices++
+		interfaces[name].Messages += introspection.ExchangedMessages
+		interfaces[name].Bytes += introspection.ExchangedBytes
+	}
+	// Traffic of interfaces removed from the introspection is still part of the total
+	for _, introspection := range device.PreviousInterfaces {
+		if interfaces[introspection.Name] == nil {
+			interfaces[introspection.Name] = &FleetInterfaceTraffic{Name: introspection.Name}
+		}
+		interfaces[introspection.Name].Messages += introspection.ExchangedMessages
+		interfaces[introspection.Name].Bytes += introspection.ExchangedBytes
+	}
+
+	if device.CredentialsInhibited {
+		r.InhibitedDevices = append(r.InhibitedDevices, device.DeviceID)
+	}
+	switch {
+	case device.LastConnection.IsZero():
+		r.NeverConnectedDevices = append(r.NeverConnectedDevices,
+			FleetDeviceTimestamp{DeviceID: device.DeviceID, Timestamp: device.FirstRegistration})
+	case !device.Connected:
+		lastSeen := device.LastConnection
+		if device.LastDisconnection.After(lastSeen) {
+			lastSeen = device.LastDisconnection
+		}
+		if lastSeen.Before(r.StaleSince) {
+			r.StaleDevices = append(r.StaleDevices, FleetDeviceTimestamp{DeviceID: device.DeviceID, Timestamp: lastSeen})
+		}
+	}
+
+	return FleetDeviceTraffic{DeviceID: device.DeviceID, Messages: device.TotalReceivedMessages, Bytes: device.TotalReceivedBytes}
+}
+
+// finish sorts the lists of the report and computes the top talkers
+func (r *FleetReport) finish(traffic []FleetDeviceTraffic, interfaces map[string]*FleetInterfaceTraffic, topTalkers int) {
+	if topTalkers <= 0 {
+		topTalkers = defaultFleetReportTopTalkers
+	}
+	if topTalkers > len(traffic) {
+		topTalkers = len(traffic)
+	}
+
+	sort.SliceStable(traffic, func(i, j int) bool {
+		if traffic[i].Bytes != traffic[j].Bytes {
+			return traffic[i].Bytes > traffic[j].Bytes
+		}
+		return traffic[i].DeviceID < traffic[j].DeviceID
+	})
+	r.TopTalkersByBytes = append([]FleetDeviceTraffic{}, traffic[:topTalkers]...)
+	sort.SliceStable(traffic, func(i, j int) bool {
+		if traffic[i].Messages != traffic[j].Messages {
+			return traffic[i].Messages > traffic[j].Messages
+		}
+		return traffic[i].DeviceID < traffic[j].DeviceID
+	})
+	r.TopTalkersByMessages = append([]FleetDeviceTraffic{}, traffic[:topTalkers]...)
+
+	r.Interfaces = []FleetInterfaceTraffic{}
+	for _, i := range interfaces {
+		r.Interfaces = append(r.Interfaces, *i)
+	}
+	sort.Slice(r.Interfaces, func(i, j int) bool {
+		if r.Interfaces[i].Bytes != r.Interfaces[j].Bytes {
+			return r.Interfaces[i].Bytes > r.Interfaces[j].Bytes
+		}
+		return r.Interfaces[i].Name < r.Interfaces[j].Name
+	})
+
+	sortDeviceTimestamps(r.StaleDevices)
+	sortDeviceTimestamps(r.NeverConnectedDevices)
+	sort.Strings(r.InhibitedDevices)
+}
+
+func sortDeviceTimestamps(devices []FleetDeviceTimestamp) {
+	sort.Slice(devices, func(i, j int) bool {
+		if !devices[i].Timestamp.Equal(devices[j].Timestamp) {
+			return devices[i].Timestamp.Before(devices[j].Timestamp)
+		}
+		return devices[i].DeviceID < devices[j].DeviceID
+	})
+}
+
+// Write writes the report to w in format.
+func (r FleetReport) Write(w io.Writer, format FleetReportFormat) error {
+	switch format {
+	case JSONFleetReportFormat:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
+	case MarkdownFleetReportFormat:
+		return r.writeMarkdown(w)
+	}
+	return errors.New("unknown fleet report format")
+}
+
+func (r FleetReport) writeMarkdown(w io.Writer) error {
+	b := &strings.Builder{}
+	r.writeMarkdownSummary(b)
+	writeMarkdownTrafficTable(b, "Top talkers by bytes", r.TopTalkersByBytes)
+	writeMarkdownTrafficTable(b, "Top talkers by messages", r.TopTalkersByMessages)
+	r.writeMarkdownInterfaces(b)
+	writeMarkdownTimestampTable(b, fmt.Sprintf("Stale devices (not seen since %s)", r.StaleSince.UTC().Format(time.RFC3339)),
+		"Last seen", r.StaleDevices)
+	writeMarkdownTimestampTable(b, "Never connected devices", "First registration", r.NeverConnectedDevices)
+	r.writeMarkdownInhibitedDevices(b)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (r FleetReport) writeMarkdownSummary(b *strings.Builder) {
+	fmt.Fprintf(b, "# Fleet report for %s\n\nGenerated at %s.\n\n", r.Realm, r.GeneratedAt.UTC().Format(time.RFC3339))
+	fmt.Fprintf(b, "| Devices | Connected | Scanned | Received messages | Received bytes |\n")
+	fmt.Fprintf(b, "| ---: | ---: | ---: | ---: | ---: |\n")
+	fmt.Fprintf(b, "| %d | %d | %d | %d | %d |\n", r.Stats.TotalDevices, r.Stats.ConnectedDevices, r.ScannedDevices,
+		r.TotalMessages, r.TotalBytes)
+}
+
+func writeMarkdownTrafficTable(b *strings.Builder, title string, traffic []FleetDeviceTraffic) {
+	fmt.Fprintf(b, "\n## %s\n\n", title)
+	if len(traffic) == 0 {
+		fmt.Fprintf(b, "None.\n")
+		return
+	}
+	fmt.Fprintf(b, "| Device | Messages | Bytes |\n| --- | ---: | ---: |\n")
+	for _, t := range traffic {
+		fmt.Fprintf(b, "| %s | %d | %d |\n", t.DeviceID, t.Messages, t.Bytes)
+	}
+}
+
+func (r FleetReport) writeMarkdownInterfaces(b *strings.Builder) {
+	fmt.Fprintf(b, "\n## Interfaces\n\n")
+	if len(r.Interfaces) == 0 {
+		fmt.Fprintf(b, "None.\n")
+		return
+	}
+	fmt.Fprintf(b, "| Interface | Devices | Messages | Bytes |\n| --- | ---: | ---: | ---: |\n")
+	for _, i := range r.Interfaces {
+		fmt.Fprintf(b, "| %s | %d | %d | %d |\n", i.Name, i.Devices, i.Messages, i.Bytes)
+	}
+}
+
+func writeMarkdownTimestampTable(b *strings.Builder, title, column string, devices []FleetDeviceTimestamp) {
+	fmt.Fprintf(b, "\n## %s\n\n", title)
+	if len(devices) == 0 {
+		fmt.Fprintf(b, "None.\n")
+		return
+	}
+	fmt.Fprintf(b, "| Device | %s |\n| --- | --- |\n", column)
+	for _, d := range devices {
+		timestamp := "unknown"
+		if !d.Timestamp.IsZero() {
+			timestamp = d.Timestamp.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(b, "| %s | %s |\n", d.DeviceID, timestamp)
+	}
+}
+
+func (r FleetReport) writeMarkdownInhibitedDevices(b *strings.Builder) {
+	fmt.Fprintf(b, "\n## Inhibited devices\n\n")
+	if len(r.InhibitedDevices) == 0 {
+		fmt.Fprintf(b, "None.\n")
+	}
+	for _, deviceID := range r.InhibitedDevices {
+		fmt.Fprintf(b, "- %s\n", deviceID)
+	}
+}
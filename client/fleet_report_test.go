@@ -0,0 +1,91 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFleetReport(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	// testDevices[1] was last seen 10 days before now
+	now := time.Date(2021, 3, 11, 10, 0, 0, 0, time.UTC)
+	report, err := client.AppEngine.GenerateFleetReport(testRealmName, FleetReportOptions{PageSize: 2, TopTalkers: 1, Now: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Stats.TotalDevices != 3 || report.ScannedDevices != 3 || report.TotalMessages != 300 || report.TotalBytes != 5000 {
+		t.Errorf("unexpected totals %+v", report)
+	}
+	if !reflect.DeepEqual(report.TopTalkersByBytes, []FleetDeviceTraffic{{DeviceID: testDevices[0], Messages: 100, Bytes: 4000}}) {
+		t.Errorf("unexpected top talkers by bytes %v", report.TopTalkersByBytes)
+	}
+	if !reflect.DeepEqual(report.TopTalkersByMessages, []FleetDeviceTraffic{{DeviceID: testDevices[1], Messages: 200, Bytes: 1000}}) {
+		t.Errorf("unexpected top talkers by messages %v", report.TopTalkersByMessages)
+	}
+	if !reflect.DeepEqual(report.Interfaces, []FleetInterfaceTraffic{{Name: testAggregateInterfaceName, Devices: 2, Messages: 290, Bytes: 4600}}) {
+		t.Errorf("unexpected interfaces %v", report.Interfaces)
+	}
+	if len(report.StaleDevices) != 1 || report.StaleDevices[0].DeviceID != testDevices[1] {
+		t.Errorf("unexpected stale devices %v", report.StaleDevices)
+	}
+	if len(report.NeverConnectedDevices) != 1 || report.NeverConnectedDevices[0].DeviceID != testDevices[2] {
+		t.Errorf("unexpected never connected devices %v", report.NeverConnectedDevices)
+	}
+	if !reflect.DeepEqual(report.InhibitedDevices, []string{testDevices[1]}) {
+		t.Errorf("unexpected inhibited devices %v", report.InhibitedDevices)
+	}
+
+	// A longer threshold makes no device stale
+	report, err = client.AppEngine.GenerateFleetReport(testRealmName, FleetReportOptions{StaleAfter: 30 * 24 * time.Hour, Now: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.StaleDevices) != 0 || len(report.TopTalkersByBytes) != 3 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	out := &bytes.Buffer{}
+	if err := report.Write(out, JSONFleetReportFormat); err != nil {
+		t.Fatal(err)
+	}
+	decoded := FleetReport{}
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.TotalBytes != report.TotalBytes || !reflect.DeepEqual(decoded.StaleDevices, report.StaleDevices) {
+		t.Errorf("unexpected decoded report %+v", decoded)
+	}
+
+	out.Reset()
+	if err := report.Write(out, MarkdownFleetReportFormat); err != nil {
+		t.Fatal(err)
+	}
+	markdown := out.String()
+	for _, expected := range []string{"# Fleet report for " + testRealmName, "| 3 | 1 | 3 | 300 | 5000 |",
+		"| " + testAggregateInterfaceName + " | 2 | 290 | 4600 |", "- " + testDevices[1]} {
+		if !strings.Contains(markdown, expected) {
+			t.Errorf("%q not found in %s", expected, markdown)
+		}
+	}
+}
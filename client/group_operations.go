@@ -0,0 +1,202 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/astarte-platform/astarte-go/interfaces"
+)
+
+// ErrGroupOperationStopped is the error of the devices which were skipped because the group operation
+// was stopped after an error.
+var ErrGroupOperationStopped = errors.New("group operation stopped after an error")
+
+// GroupOperationOptions configures an operation run on all the devices of a group.
+type GroupOperationOptions struct {
+	// Concurrency is the maximum number of concurrent requests. If <= 0, a default concurrency is used.
+	Concurrency int
+	// RequestsPerSecond limits the rate at which requests are started. If <= 0, the rate is not limited.
+	RequestsPerSecond float64
+	// StopOnError stops the operation at the first failure: devices which were not processed yet are
+	// reported as failed with ErrGroupOperationStopped. Otherwise, all devices are processed.
+	StopOnError bool
+}
+
+// GroupOperationResult is the outcome of a group operation on a single device.
+type GroupOperationResult struct {
+	DeviceID string
+	Err      error
+}
+
+// GroupOperationReport summarizes a group operation. Results are in the same order as the devices of the group.
+type GroupOperationReport struct {
+	Results   []GroupOperationResult
+	Succeeded int
+	Failed    int
+}
+
+// Err returns a DeviceErrors with all failed devices, or nil if the operation succeeded on all devices.
+func (r GroupOperationReport) Err() error {
+	if r.Failed == 0 {
+		return nil
+	}
+	errs := DeviceErrors{}
+	for _, result := range r.Results {
+		if result.Err != nil {
+			errs[result.DeviceID] = result.Err
+		}
+	}
+	return errs
+}
+
+// SetGroupProperty sets a property on all the devices of a group. See RunGroupOperation for details.
+func (s *AppEngineService) SetGroupProperty(realm, groupName, interfaceName, interfacePath string, payload interface{},
+	options GroupOperationOptions) (GroupOperationReport, error) {
+	return s.RunGroupOperation(realm, groupName, options, func(deviceID string) error {
+		return s.SetProperty(realm, deviceID, AstarteDeviceID, interfaceName, interfacePath, payload)
+	})
+}
+
+// UnsetGroupProperty unsets a property on all the devices of a group. See RunGroupOperation for details.
+func (s *AppEngineService) UnsetGroupProperty(realm, groupName, interfaceName, interfacePath string,
+	options GroupOperationOptions) (GroupOperationReport, error) {
+	return s.RunGroupOperation(realm, groupName, options, func(deviceID string) error {
+		return s.UnsetProperty(realm, deviceID, AstarteDeviceID, interfaceName, interfacePath)
+	})
+}
+
+// SendGroupDatastream sends a datastream to all the devices of a group, like SendData: object aggregated payloads
+// can be maps or structs with `astarte` tags. See RunGroupOperation for details.
+func (s *AppEngineService) SendGroupDatastream(realm, groupName string, astarteInterface interfaces.AstarteInterface,
+	interfacePath string, payload interface{}, options GroupOperationOptions) (GroupOperationReport, error) {
+	if astarteInterface.Type != interfaces.DatastreamType {
+		return GroupOperationReport{}, fmt.Errorf("interface %s is not a datastream", astarteInterface.Name)
+	}
+	return s.RunGroupOperation(realm, groupName, options, func(deviceID string) error {
+		return s.SendData(realm, deviceID, AstarteDeviceID, astarteInterface, interfacePath, payload)
+	})
+}
+
+// InhibitGroup sets the Credentials Inhibition state of all the devices of a group. See RunGroupOperation for details.
+func (s *AppEngineService) InhibitGroup(realm, groupName string, inhibit bool, options GroupOperationOptions) (GroupOperationReport, error) {
+	return s.RunGroupOperation(realm, groupName, options, func(deviceID string) error {
+		return s.InhibitDevice(realm, deviceID, AstarteDeviceID, inhibit)
+	})
+}
+
+// SetGroupAttribute sets an Attribute on all the devices of a group. See RunGroupOperation for details.
+func (s *AppEngineService) SetGroupAttribute(realm, groupName, attributeKey, attributeValue string,
+	options GroupOperationOptions) (GroupOperationReport, error) {
+	return s.RunGroupOperation(realm, groupName, options, func(deviceID string) error {
+		return s.SetDeviceAttribute(realm, deviceID, AstarteDeviceID, attributeKey, attributeValue)
+	})
+}
+
+// RunGroupOperation lists the devices of a group and calls operation with the Device ID of each of them,
+// with bounded concurrency and rate. Failures on single devices are reported in the returned GroupOperationReport:
+// an error is returned only if the devices of the group cannot be listed.
+func (s *AppEngineService) RunGroupOperation(realm, groupName string, options GroupOperationOptions,
+	operation func(deviceID string) error) (GroupOperationReport, error) {
+	deviceIDs, err := s.ListGroupDevices(realm, groupName)
+	if err != nil {
+		return GroupOperationReport{}, err
+	}
+	runner := newGroupOperationRunner(deviceIDs, options, operation)
+	defer runner.close()
+	for i := range deviceIDs {
+		runner.start(i)
+	}
+	runner.wg.Wait()
+
+	report := GroupOperationReport{Results: runner.results}
+	for _, result := range runner.results {
+		if result.Err != nil {
+			report.Failed++
+		} else {
+			report.Succeeded++
+		}
+	}
+	return report, nil
+}
+
+// groupOperationRunner runs an operation on many devices, with bounded concurrency and rate
+type groupOperationRunner struct {
+	options   GroupOperationOptions
+	operation func(deviceID string) error
+	results   []GroupOperationResult
+	semaphore chan struct{}
+	ticker    *time.Ticker
+	wg        sync.WaitGroup
+	lock      sync.Mutex
+	stopped   bool
+}
+
+func newGroupOperationRunner(deviceIDs []string, options GroupOperationOptions, operation func(deviceID string) error) *groupOperationRunner {
+	if options.Concurrency <= 0 {
+		options.Concurrency = defaultGroupConcurrency
+	}
+	r := &groupOperationRunner{
+		options:   options,
+		operation: operation,
+		results:   make([]GroupOperationResult, len(deviceIDs)),
+		semaphore: make(chan struct{}, options.Concurrency),
+	}
+	for i, deviceID := range deviceIDs {
+		r.results[i].DeviceID = deviceID
+	}
+	if options.RequestsPerSecond > 0 {
+		r.ticker = time.NewTicker(time.Duration(float64(time.Second) / options.RequestsPerSecond))
+	}
+	return r
+}
+
+// start runs the operation on the i-th device as soon as concurrency and rate allow, unless the runner was stopped
+func (r *groupOperationRunner) start(i int) {
+	r.semaphore <- struct{}{}
+	r.lock.Lock()
+	stop := r.stopped
+	r.lock.Unlock()
+	if stop {
+		<-r.semaphore
+		r.results[i].Err = ErrGroupOperationStopped
+		return
+	}
+	if r.ticker != nil && i > 0 {
+		<-r.ticker.C
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer func() { <-r.semaphore }()
+		err := r.operation(r.results[i].DeviceID)
+		r.results[i].Err = err
+		if err != nil && r.options.StopOnError {
+			r.lock.Lock()
+			r.stopped = true
+			r.lock.Unlock()
+		}
+	}()
+}
+
+func (r *groupOperationRunner) close() {
+	if r.ticker != nil {
+		r.ticker.Stop()
+	}
+}
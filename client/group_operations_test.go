@@ -0,0 +1,125 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/astarte-platform/astarte-go/interfaces"
+)
+
+func TestSetGroupProperty(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	samplingRate := "org.astarte-platform.genericsensors.SamplingRate"
+	report, err := client.AppEngine.SetGroupProperty(testRealmName, testGroupName, samplingRate, "/sensor1/enable", true,
+		GroupOperationOptions{Concurrency: 2, RequestsPerSecond: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Succeeded != len(testDevices) || report.Err() != nil {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	requests := popTestWriteRequests()
+	if len(requests) != len(testDevices) {
+		t.Fatalf("unexpected requests %v", requests)
+	}
+	for _, deviceID := range testDevices {
+		expectedPath := fmt.Sprintf("/appengine/v1/%s/devices/%s/interfaces/%s/sensor1/enable", testRealmName, deviceID, samplingRate)
+		found := false
+		for _, request := range requests {
+			found = found || (request.Method == "PUT" && request.Path == expectedPath && request.Data == true)
+		}
+		if !found {
+			t.Errorf("property was not set on %s", deviceID)
+		}
+	}
+}
+
+func TestSendGroupDatastreamAggregate(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	setpoints := interfaces.AstarteInterface{
+		Name:        "org.example.Setpoints",
+		Type:        interfaces.DatastreamType,
+		Ownership:   interfaces.ServerOwnership,
+		Aggregation: interfaces.ObjectAggregation,
+		Mappings: []interfaces.AstarteInterfaceMapping{
+			{Endpoint: "/%{room}/temperature", Type: interfaces.Double},
+			{Endpoint: "/%{room}/humidity", Type: interfaces.Double},
+		},
+	}
+	payload := struct {
+		Temperature float64 `astarte:"temperature"`
+		Humidity    float64 `astarte:"humidity"`
+	}{21.5, 40}
+	report, err := client.AppEngine.SendGroupDatastream(testRealmName, testGroupName, setpoints, "/kitchen", payload,
+		GroupOperationOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Succeeded != len(testDevices) || report.Err() != nil {
+		t.Errorf("unexpected report %+v", report)
+	}
+	for _, request := range popTestWriteRequests() {
+		data, _ := request.Data.(map[string]interface{})
+		if request.Method != "POST" || data["temperature"] != 21.5 || data["humidity"] != 40.0 {
+			t.Errorf("unexpected request %+v", request)
+		}
+	}
+
+	setpoints.Type = interfaces.PropertiesType
+	if _, err := client.AppEngine.SendGroupDatastream(testRealmName, testGroupName, setpoints, "/kitchen", payload,
+		GroupOperationOptions{}); err == nil {
+		t.Error("datastream sent to properties")
+	}
+}
+
+func TestRunGroupOperationStopOnError(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	failure := errors.New("failure")
+	operation := func(deviceID string) error {
+		if deviceID == testDevices[1] {
+			return failure
+		}
+		return nil
+	}
+
+	report, err := client.AppEngine.RunGroupOperation(testRealmName, testGroupName, GroupOperationOptions{Concurrency: 1, StopOnError: true}, operation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []error{nil, failure, ErrGroupOperationStopped}
+	for i, result := range report.Results {
+		if result.DeviceID != testDevices[i] || result.Err != expected[i] {
+			t.Errorf("unexpected result %+v", result)
+		}
+	}
+
+	report, err = client.AppEngine.RunGroupOperation(testRealmName, testGroupName, GroupOperationOptions{Concurrency: 1}, operation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Succeeded != 2 || report.Failed != 1 || len(report.Err().(DeviceErrors)) != 1 {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
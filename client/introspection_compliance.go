@@ -0,0 +1,301 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// IntrospectionFindingType represents how an Interface announced by a Device differs from the installed ones.
+type IntrospectionFindingType int
+
+const (
+	// MissingInterfaceFinding is an Interface which is not installed in the Realm at all.
+	MissingInterfaceFinding IntrospectionFindingType = iota
+	// UnknownMajorFinding is a major version which is not installed in the Realm.
+	UnknownMajorFinding
+	// UnknownMinorFinding is a minor version newer than the installed one.
+	UnknownMinorFinding
+	// OutdatedMinorFinding is a minor version older than the installed one.
+	OutdatedMinorFinding
+	// OutdatedMajorFinding is an installed major version older than the latest installed one.
+	OutdatedMajorFinding
+)
+
+func (t IntrospectionFindingType) String() string {
+	switch t {
+	case MissingInterfaceFinding:
+		return "missing_interface"
+	case UnknownMajorFinding:
+		return "unknown_major"
+	case UnknownMinorFinding:
+		return "unknown_minor"
+	case OutdatedMinorFinding:
+		return "outdated_minor"
+	case OutdatedMajorFinding:
+		return "outdated_major"
+	}
+	return fmt.Sprintf("IntrospectionFindingType(%d)", int(t))
+}
+
+// IntrospectionFinding is a single difference between an Interface announced by a Device and the installed ones.
+// InstalledMajor and InstalledMinor are the installed version the announced one is compared to: the same major for
+// minor findings, the latest major for OutdatedMajorFinding, and are not set otherwise. Previous is true if the
+// Interface is one of the PreviousInterfaces of the Device, which are only checked for missing Interfaces and
+// unknown majors.
+type IntrospectionFinding struct {
+	Type           IntrospectionFindingType
+	Interface      string
+	Major          int
+	Minor          int
+	InstalledMajor int
+	InstalledMinor int
+	Previous       bool
+}
+
+func (f IntrospectionFinding) String() string {
+	previous := ""
+	if f.Previous {
+		previous = " (previous interface)"
+	}
+	switch f.Type {
+	case MissingInterfaceFinding, UnknownMajorFinding:
+		return fmt.Sprintf("%s %s v%d.%d%s", f.Type, f.Interface, f.Major, f.Minor, previous)
+	}
+	return fmt.Sprintf("%s %s v%d.%d, installed v%d.%d%s", f.Type, f.Interface, f.Major, f.Minor,
+		f.InstalledMajor, f.InstalledMinor, previous)
+}
+
+// DeviceIntrospectionCompliance lists the findings of a single Device, sorted by Interface.
+type DeviceIntrospectionCompliance struct {
+	DeviceID string
+	Findings []IntrospectionFinding
+}
+
+// InterfaceVersionCount is the number of Devices announcing a version of an Interface.
+type InterfaceVersionCount struct {
+	Interface string
+	Major     int
+	Minor     int
+	Devices   int
+	// Installed is true if the major version is installed in the Realm, regardless of the minor
+	Installed bool
+}
+
+// IntrospectionComplianceReport is the outcome of an introspection compliance check. Devices lists only Devices
+// with at least one finding, sorted by Device ID. Distribution counts the current introspection of all Devices,
+// sorted by Interface and version.
+type IntrospectionComplianceReport struct {
+	CheckedDevices int
+	Devices        []DeviceIntrospectionCompliance
+	Distribution   []InterfaceVersionCount
+}
+
+// CheckIntrospectionCompliance sweeps the device list of realm with details, and compares the Introspection and
+// PreviousInterfaces of each Device with the Interfaces installed in the Realm. Interface definitions are retrieved
+// from RealmManagement, hence the Client must have a RealmManagementService available. Each Interface and major
+// version is retrieved at most once. If pageSize is <= 0, the default page size is used.
+func (s *AppEngineService) CheckIntrospectionCompliance(realm string, pageSize int) (IntrospectionComplianceReport, error) {
+	if s.client.RealmManagement == nil {
+		return IntrospectionComplianceReport{}, errors.New("CheckIntrospectionCompliance requires a RealmManagement service")
+	}
+
+	installed, err := s.newInstalledInterfaces(realm)
+	if err != nil {
+		return IntrospectionComplianceReport{}, err
+	}
+	paginator, err := s.GetDeviceListPaginator(realm, pageSize, DeviceDetailsFormat)
+	if err != nil {
+		return IntrospectionComplianceReport{}, err
+	}
+
+	report := IntrospectionComplianceReport{Devices: []DeviceIntrospectionCompliance{}}
+	distribution := map[InterfaceVersionCount]int{}
+	for paginator.HasNextPage() {
+		page := []DeviceDetails{}
+		if err := paginator.GetNextPage(&page); err != nil {
+			return IntrospectionComplianceReport{}, err
+		}
+		for _, device := range page {
+			report.CheckedDevices++
+			findings, err := installed.check(device, distribution)
+			if err != nil {
+				return IntrospectionComplianceReport{}, err
+			}
+			if len(findings) > 0 {
+				report.Devices = append(report.Devices, DeviceIntrospectionCompliance{DeviceID: device.DeviceID, Findings: findings})
+			}
+		}
+	}
+
+	sort.Slice(report.Devices, func(i, j int) bool { return report.Devices[i].DeviceID < report.Devices[j].DeviceID })
+	report.Distribution = make([]InterfaceVersionCount, 0, len(distribution))
+	for version, devices := range distribution {
+		version.Devices = devices
+		report.Distribution = append(report.Distribution, version)
+	}
+	sort.Slice(report.Distribution, func(i, j int) bool {
+		a, b := report.Distribution[i], report.Distribution[j]
+		if a.Interface != b.Interface {
+			return a.Interface < b.Interface
+		}
+		if a.Major != b.Major {
+			return a.Major < b.Major
+		}
+		return a.Minor < b.Minor
+	})
+	return report, nil
+}
+
+// installedInterfaces lazily retrieves the installed versions of the Interfaces of a Realm
+type installedInterfaces struct {
+	appEngine *AppEngineService
+	realm     string
+	// names are the installed Interfaces
+	names map[string]bool
+	// majors are the installed major versions of each Interface, in ascending order
+	majors map[string][]int
+	// minors are the installed minor versions, by Interface and major
+	minors map[string]map[int]int
+}
+
+func (s *AppEngineService) newInstalledInterfaces(realm string) (*installedInterfaces, error) {
+	names, err := s.client.RealmManagement.ListInterfaces(realm)
+	if err != nil {
+		return nil, err
+	}
+	installed := &installedInterfaces{appEngine: s, realm: realm, names: map[string]bool{},
+		majors: map[string][]int{}, minors: map[string]map[int]int{}}
+	for _, name := range names {
+		installed.names[name] = true
+	}
+	return installed, nil
+}
+
+func (i *installedInterfaces) majorVersions(name string) ([]int, error) {
+	if majors, ok := i.majors[name]; ok {
+		return majors, nil
+	}
+	majors, err := i.appEngine.client.RealmManagement.ListInterfaceMajorVersions(i.realm, name)
+	if err != nil {
+		return nil, err
+	}
+	sort.Ints(majors)
+	i.majors[name] = majors
+	return majors, nil
+}
+
+func (i *installedInterfaces) minorVersion(name string, major int) (int, error) {
+	if minor, ok := i.minors[name][major]; ok {
+		return minor, nil
+	}
+	iface, err := i.appEngine.client.RealmManagement.GetInterface(i.realm, name, major)
+	if err != nil {
+		return 0, err
+	}
+	if i.minors[name] == nil {
+		i.minors[name] = map[int]int{}
+	}
+	i.minors[name][major] = iface.MinorVersion
+	return iface.MinorVersion, nil
+}
+
+// check returns the findings of device, and counts its introspection in distribution
+func (i *installedInterfaces) check(device DeviceDetails, distribution map[InterfaceVersionCount]int) ([]IntrospectionFinding, error) {
+	findings := []IntrospectionFinding{}
+	for name, introspection := range device.Introspection {
+		if introspection.Name != "" {
+			name = introspection.Name
+		}
+		finding, installed, err := i.checkInterface(name, introspection.Major, introspection.Minor, false)
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, finding...)
+		distribution[InterfaceVersionCount{Interface: name, Major: introspection.Major, Minor: introspection.Minor, Installed: installed}]++
+	}
+	for _, introspection := range device.PreviousInterfaces {
+		finding, _, err := i.checkInterface(introspection.Name, introspection.Major, introspection.Minor, true)
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, finding...)
+	}
+
+	sort.SliceStable(findings, func(a, b int) bool {
+		if findings[a].Previous != findings[b].Previous {
+			return !findings[a].Previous
+		}
+		if findings[a].Interface != findings[b].Interface {
+			return findings[a].Interface < findings[b].Interface
+		}
+		return findings[a].Type < findings[b].Type
+	})
+	return findings, nil
+}
+
+// checkInterface returns the findings of a version of an Interface, and whether its major is installed
+func (i *installedInterfaces) checkInterface(name string, major, minor int, previous bool) ([]IntrospectionFinding, bool, error) {
+	finding := IntrospectionFinding{Interface: name, Major: major, Minor: minor, Previous: previous}
+	if !i.names[name] {
+		finding.Type = MissingInterfaceFinding
+		return []IntrospectionFinding{finding}, false, nil
+	}
+	majors, err := i.majorVersions(name)
+	if err != nil {
+		return nil, false, err
+	}
+	installed := false
+	for _, m := range majors {
+		installed = installed || m == major
+	}
+	if !installed {
+		finding.Type = UnknownMajorFinding
+		return []IntrospectionFinding{finding}, false, nil
+	}
+	if previous {
+		return nil, true, nil
+	}
+
+	findings := []IntrospectionFinding{}
+	installedMinor, err := i.minorVersion(name, major)
+	if err != nil {
+		return nil, true, err
+	}
+	if minor != installedMinor {
+		minorFinding := finding
+		minorFinding.Type = OutdatedMinorFinding
+		if minor > installedMinor {
+			minorFinding.Type = UnknownMinorFinding
+		}
+		minorFinding.InstalledMajor = major
+		minorFinding.InstalledMinor = installedMinor
+		findings = append(findings, minorFinding)
+	}
+	if latestMajor := majors[len(majors)-1]; latestMajor > major {
+		latestMinor, err := i.minorVersion(name, latestMajor)
+		if err != nil {
+			return nil, true, err
+		}
+		majorFinding := finding
+		majorFinding.Type = OutdatedMajorFinding
+		majorFinding.InstalledMajor = latestMajor
+		majorFinding.InstalledMinor = latestMinor
+		findings = append(findings, majorFinding)
+	}
+	return findings, true, nil
+}
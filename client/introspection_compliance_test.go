@@ -0,0 +1,90 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestIntrospectionCompliance(t *testing.T) {
+	// testAggregateInterfaceName is installed with majors 1 and 2, and testDevices announce 0.1 and 1.0
+	interfacesPath := fmt.Sprintf("/realmmanagement/v1/%s/interfaces", testRealmName)
+	installedMinors := map[int]int{1: 2, 2: 0}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case interfacesPath:
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": []string{testAggregateInterfaceName, "org.astarte-platform.genericsensors.Values"}})
+		case interfacesPath + "/" + testAggregateInterfaceName:
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": []int{2, 1}})
+		case interfacesPath + "/" + testAggregateInterfaceName + "/1", interfacesPath + "/" + testAggregateInterfaceName + "/2":
+			major := int(req.URL.Path[len(req.URL.Path)-1] - '0')
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+				"interface_name": testAggregateInterfaceName, "version_major": major, "version_minor": installedMinors[major],
+				"type": "datastream", "ownership": "device", "aggregation": "object",
+				"mappings": []map[string]string{{"endpoint": "/gps/latitude", "type": "double"}},
+			}})
+		default:
+			astarteAPIMock(w, req)
+		}
+	}))
+	defer server.Close()
+	client, err := NewClient(server.URL, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken(testTokenValue)
+
+	report, err := client.AppEngine.CheckIntrospectionCompliance(testRealmName, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedDevices := []DeviceIntrospectionCompliance{
+		{DeviceID: testDevices[0], Findings: []IntrospectionFinding{
+			{Type: UnknownMajorFinding, Interface: testAggregateInterfaceName, Major: 0, Minor: 1},
+		}},
+		{DeviceID: testDevices[1], Findings: []IntrospectionFinding{
+			{Type: OutdatedMinorFinding, Interface: testAggregateInterfaceName, Major: 1, Minor: 0, InstalledMajor: 1, InstalledMinor: 2},
+			{Type: OutdatedMajorFinding, Interface: testAggregateInterfaceName, Major: 1, Minor: 0, InstalledMajor: 2, InstalledMinor: 0},
+		}},
+	}
+	if report.CheckedDevices != 3 || !reflect.DeepEqual(report.Devices, expectedDevices) {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	expectedDistribution := []InterfaceVersionCount{
+		{Interface: testAggregateInterfaceName, Major: 0, Minor: 1, Devices: 1},
+		{Interface: testAggregateInterfaceName, Major: 1, Minor: 0, Devices: 1, Installed: true},
+	}
+	if !reflect.DeepEqual(report.Distribution, expectedDistribution) {
+		t.Errorf("unexpected distribution %+v", report.Distribution)
+	}
+
+	// With the default mock, the Interface is not installed at all
+	client, server = getTestContext(t)
+	defer server.Close()
+	report, err = client.AppEngine.CheckIntrospectionCompliance(testRealmName, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Devices) != 2 || report.Devices[0].Findings[0].Type != MissingInterfaceFinding {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
@@ -20,12 +20,14 @@ import (
 	"path"
 
 	"github.com/astarte-platform/astarte-go/interfaces"
+	"github.com/astarte-platform/astarte-go/triggers"
 )
 
 // RealmManagementService is the API Client for RealmManagement API
 type RealmManagementService struct {
 	client             *Client
 	realmManagementURL *url.URL
+	deletions          deviceDeletions
 }
 
 // ListInterfaces returns all interfaces in a Realm.
@@ -94,18 +96,24 @@ func (s *RealmManagementService) ListTriggers(realm string) ([]string, error) {
 }
 
 // GetTrigger returns a trigger installed in a Realm
-func (s *RealmManagementService) GetTrigger(realm string, triggerName string) (map[string]interface{}, error) {
+func (s *RealmManagementService) GetTrigger(realm string, triggerName string) (triggers.AstarteTrigger, error) {
 	callURL, _ := url.Parse(s.realmManagementURL.String())
 	callURL.Path = path.Join(callURL.Path, fmt.Sprintf("/v1/%s/triggers/%s", realm, triggerName))
 
-	trigger := map[string]interface{}{}
+	trigger := triggers.AstarteTrigger{}
 	err := s.client.genericJSONDataAPIGET(&trigger, callURL.String(), 200)
 
 	return trigger, err
 }
 
-// InstallTrigger installs a Trigger into the Realm
-func (s *RealmManagementService) InstallTrigger(realm string, triggerPayload interface{}) error {
+// InstallTrigger installs a Trigger into the Realm. If the Trigger uses a Delivery Policy, it must be installed
+// already, or ErrDeliveryPolicyNotFound is returned.
+func (s *RealmManagementService) InstallTrigger(realm string, triggerPayload triggers.AstarteTrigger) error {
+	if triggerPayload.Policy != "" {
+		if err := s.ensureDeliveryPolicyExists(realm, triggerPayload.Policy); err != nil {
+			return err
+		}
+	}
 	callURL, _ := url.Parse(s.realmManagementURL.String())
 	callURL.Path = path.Join(callURL.Path, fmt.Sprintf("/v1/%s/triggers", realm))
 	return s.client.genericJSONDataAPIPost(callURL.String(), triggerPayload, 201)
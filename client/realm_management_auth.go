@@ -0,0 +1,126 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+
+	"github.com/astarte-platform/astarte-go/misc"
+)
+
+// This file contains all API Calls related to the authentication configuration of a Realm
+
+// ErrJWTKeyRotationProbeFailed is returned by RotateJWTKey when the Client cannot access the Realm with the new key.
+var ErrJWTKeyRotationProbeFailed = errors.New("access check with the new JWT key failed")
+
+// GetAuthConfig returns the authentication configuration of a Realm
+func (s *RealmManagementService) GetAuthConfig(realm string) (RealmAuthConfig, error) {
+	callURL, _ := url.Parse(s.realmManagementURL.String())
+	callURL.Path = path.Join(callURL.Path, fmt.Sprintf("/v1/%s/config/auth", realm))
+
+	authConfig := RealmAuthConfig{}
+	err := s.client.genericJSONDataAPIGET(&authConfig, callURL.String(), 200)
+
+	return authConfig, err
+}
+
+// UpdateAuthConfig replaces the authentication configuration of a Realm. Once the JWT public key is replaced,
+// tokens signed with the previous private key are refused.
+func (s *RealmManagementService) UpdateAuthConfig(realm string, authConfig RealmAuthConfig) error {
+	callURL, _ := url.Parse(s.realmManagementURL.String())
+	callURL.Path = path.Join(callURL.Path, fmt.Sprintf("/v1/%s/config/auth", realm))
+	return s.client.genericJSONDataAPIPut(callURL.String(), authConfig, 204)
+}
+
+// JWTKeyRotationOptions customizes RotateJWTKey
+type JWTKeyRotationOptions struct {
+	// ServicesAndClaims and TTLSeconds are used to generate the new token of the Client, as in
+	// SetTokenFromPrivateKeyWithClaims. If ServicesAndClaims is nil, the token has complete access to RealmManagement,
+	// AppEngine, Pairing and Channels.
+	ServicesAndClaims map[misc.AstarteService][]string
+	TTLSeconds        int64
+	// Probe checks that the Client can access the Realm with the new token. Defaults to GetAuthConfig.
+	Probe func(c *Client) error
+}
+
+// JWTKeyRotation is the outcome of RotateJWTKey. PrivateKeyPEM is the only copy of the new private key: store it
+// safely, as it is needed to generate any further token.
+type JWTKeyRotation struct {
+	PrivateKeyPEM        []byte
+	PublicKeyPEM         []byte
+	PreviousPublicKeyPEM string
+}
+
+// RotateJWTKey replaces the JWT key of a Realm: it generates a new key pair, installs the new public key, switches
+// the Client to a token signed with the new private key and checks access with a probe call. If the probe fails,
+// the previous public key and token are restored and ErrJWTKeyRotationProbeFailed is returned. The Client must
+// be allowed to update the authentication configuration of the Realm. Once the new public key is sent, the
+// JWTKeyRotation is returned along with any error: if installing the key failed, for example with a timeout, it
+// might be installed nonetheless, and PrivateKeyPEM is needed to regain access.
+func (s *RealmManagementService) RotateJWTKey(realm string, options JWTKeyRotationOptions) (JWTKeyRotation, error) {
+	servicesAndClaims := options.ServicesAndClaims
+	if servicesAndClaims == nil {
+		servicesAndClaims = map[misc.AstarteService][]string{
+			misc.AppEngine:       {},
+			misc.Channels:        {},
+			misc.Pairing:         {},
+			misc.RealmManagement: {},
+		}
+	}
+	probe := options.Probe
+	if probe == nil {
+		probe = func(c *Client) error {
+			_, err := c.RealmManagement.GetAuthConfig(realm)
+			return err
+		}
+	}
+
+	previousConfig, err := s.GetAuthConfig(realm)
+	if err != nil {
+		return JWTKeyRotation{}, err
+	}
+	privateKeyPEM, publicKeyPEM, err := misc.GenerateAstarteKeyPair()
+	if err != nil {
+		return JWTKeyRotation{}, err
+	}
+	rotation := JWTKeyRotation{PrivateKeyPEM: privateKeyPEM, PublicKeyPEM: publicKeyPEM,
+		PreviousPublicKeyPEM: previousConfig.JwtPublicKeyPEM}
+	newToken, err := misc.GenerateAstarteJWTFromPEMKey(privateKeyPEM, servicesAndClaims, options.TTLSeconds)
+	if err != nil {
+		return JWTKeyRotation{}, err
+	}
+
+	// From now on, the new key might be installed even if a call fails: rotation is always returned
+	if err := s.UpdateAuthConfig(realm, RealmAuthConfig{JwtPublicKeyPEM: string(publicKeyPEM)}); err != nil {
+		return rotation, err
+	}
+	previousToken := s.client.token
+	s.client.SetToken(newToken)
+	probeErr := probe(s.client)
+	if probeErr == nil {
+		return rotation, nil
+	}
+
+	// The new token is still the only one which might be accepted, so use it to restore the previous key
+	if err := s.UpdateAuthConfig(realm, previousConfig); err != nil {
+		return rotation, fmt.Errorf("%w: %v, and restoring the previous key failed: %v", ErrJWTKeyRotationProbeFailed,
+			probeErr, err)
+	}
+	s.client.SetToken(previousToken)
+	return rotation, fmt.Errorf("%w: %v", ErrJWTKeyRotationProbeFailed, probeErr)
+}
@@ -0,0 +1,157 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/astarte-platform/astarte-go/misc"
+	jwt "github.com/cristalhq/jwt/v3"
+)
+
+// authConfigMock mimics the authentication configuration of testRealmName, accepting only tokens signed with the
+// key matching publicKeyPEM
+type authConfigMock struct {
+	lock         sync.Mutex
+	publicKeyPEM string
+	// failUpdates makes updates fail after being applied
+	failUpdates bool
+}
+
+func (m *authConfigMock) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	block, _ := pem.Decode([]byte(m.publicKeyPEM))
+	publicKey, _ := x509.ParsePKIXPublicKey(block.Bytes)
+	verifier, _ := jwt.NewVerifierES(jwt.ES256, publicKey.(*ecdsa.PublicKey))
+	if _, err := jwt.ParseAndVerifyString(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "), verifier); err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": map[string]string{"detail": "Unauthorized"}})
+		return
+	}
+
+	switch {
+	case req.URL.Path != fmt.Sprintf("/realmmanagement/v1/%s/config/auth", testRealmName):
+		w.WriteHeader(http.StatusNotFound)
+	case req.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": RealmAuthConfig{JwtPublicKeyPEM: m.publicKeyPEM}})
+	case req.Method == http.MethodPut:
+		var body struct {
+			Data RealmAuthConfig `json:"data"`
+		}
+		json.NewDecoder(req.Body).Decode(&body)
+		m.publicKeyPEM = body.Data.JwtPublicKeyPEM
+		if m.failUpdates {
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func getAuthConfigTestContext(t *testing.T) (*Client, *authConfigMock, *httptest.Server) {
+	privateKeyPEM, publicKeyPEM, err := misc.GenerateAstarteKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := &authConfigMock{publicKeyPEM: string(publicKeyPEM)}
+	server := httptest.NewServer(mock)
+	client, err := NewClient(server.URL, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetTokenFromPrivateKey(privateKeyPEM); err != nil {
+		t.Fatal(err)
+	}
+	return client, mock, server
+}
+
+func TestRotateJWTKey(t *testing.T) {
+	client, mock, server := getAuthConfigTestContext(t)
+	defer server.Close()
+
+	previousConfig, err := client.RealmManagement.GetAuthConfig(testRealmName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotation, err := client.RealmManagement.RotateJWTKey(testRealmName, JWTKeyRotationOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotation.PreviousPublicKeyPEM != previousConfig.JwtPublicKeyPEM || mock.publicKeyPEM != string(rotation.PublicKeyPEM) {
+		t.Errorf("unexpected rotation %+v", rotation)
+	}
+	if _, err := client.RealmManagement.GetAuthConfig(testRealmName); err != nil {
+		t.Errorf("cannot access the realm after rotation: %v", err)
+	}
+	if err := client.SetTokenFromPrivateKey(rotation.PrivateKeyPEM); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.RealmManagement.GetAuthConfig(testRealmName); err != nil {
+		t.Errorf("new private key is refused: %v", err)
+	}
+}
+
+func TestRotateJWTKeyRollback(t *testing.T) {
+	client, mock, server := getAuthConfigTestContext(t)
+	defer server.Close()
+
+	previousPublicKeyPEM := mock.publicKeyPEM
+	probeErr := errors.New("probe failed")
+	_, err := client.RealmManagement.RotateJWTKey(testRealmName, JWTKeyRotationOptions{
+		Probe: func(*Client) error { return probeErr },
+	})
+	if !errors.Is(err, ErrJWTKeyRotationProbeFailed) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if mock.publicKeyPEM != previousPublicKeyPEM {
+		t.Error("previous public key was not restored")
+	}
+	if _, err := client.RealmManagement.GetAuthConfig(testRealmName); err != nil {
+		t.Errorf("previous token was not restored: %v", err)
+	}
+}
+
+func TestRotateJWTKeyUpdateFailure(t *testing.T) {
+	client, mock, server := getAuthConfigTestContext(t)
+	defer server.Close()
+
+	mock.failUpdates = true
+	rotation, err := client.RealmManagement.RotateJWTKey(testRealmName, JWTKeyRotationOptions{})
+	if err == nil {
+		t.Fatal("failed update was not reported")
+	}
+	// The key was applied anyway: the new private key is the only way back in
+	if mock.publicKeyPEM != string(rotation.PublicKeyPEM) {
+		t.Fatalf("unexpected rotation %+v", rotation)
+	}
+	if err := client.SetTokenFromPrivateKey(rotation.PrivateKeyPEM); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.RealmManagement.GetAuthConfig(testRealmName); err != nil {
+		t.Errorf("new private key is refused: %v", err)
+	}
+}
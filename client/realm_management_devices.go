@@ -0,0 +1,161 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"time"
+)
+
+// This file contains all API Calls related to device deletion
+
+const defaultDeviceDeletionPollInterval = time.Second
+
+var (
+	// ErrDeviceDeletionNotSupported is returned by DeleteDevice when the Astarte cluster does not support device deletion.
+	ErrDeviceDeletionNotSupported = errors.New("device deletion is not supported by this Astarte version")
+	// ErrDeviceDeletionTimeout is returned by WaitForDeviceDeletion when the Device still exists after the timeout.
+	ErrDeviceDeletionTimeout = errors.New("timed out waiting for device deletion")
+)
+
+// DeviceDeletionStatus represents the progress of the deletion of a Device.
+type DeviceDeletionStatus int
+
+const (
+	// DeviceDeletionNotRequested means the Device exists, and its deletion was not requested.
+	DeviceDeletionNotRequested DeviceDeletionStatus = iota
+	// DeviceDeletionPending means the deletion of the Device is in progress.
+	DeviceDeletionPending
+	// DeviceDeletionCompleted means the Device and all its data were deleted, or the Device never existed.
+	DeviceDeletionCompleted
+)
+
+func (s DeviceDeletionStatus) String() string {
+	switch s {
+	case DeviceDeletionNotRequested:
+		return "not requested"
+	case DeviceDeletionPending:
+		return "pending"
+	case DeviceDeletionCompleted:
+		return "completed"
+	}
+	return fmt.Sprintf("DeviceDeletionStatus(%d)", int(s))
+}
+
+// deviceDeletions tracks the Devices whose deletion was requested through a RealmManagementService, until it is
+// found completed. The zero value is ready to use.
+type deviceDeletions struct {
+	lock      sync.Mutex
+	deviceIDs map[string]bool
+}
+
+func (d *deviceDeletions) add(realm, deviceID string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.deviceIDs == nil {
+		d.deviceIDs = map[string]bool{}
+	}
+	d.deviceIDs[path.Join(realm, deviceID)] = true
+}
+
+func (d *deviceDeletions) remove(realm, deviceID string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.deviceIDs, path.Join(realm, deviceID))
+}
+
+func (d *deviceDeletions) has(realm, deviceID string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.deviceIDs[path.Join(realm, deviceID)]
+}
+
+// DeleteDevice starts the deletion of a Device and of all its data from the Realm. Deletion is asynchronous: use
+// GetDeviceDeletionStatus or WaitForDeviceDeletion to know when it completes. ErrDeviceDeletionNotSupported is
+// returned if the Astarte cluster does not support deletion. Older clusters reply with 404 rather than 405, just like
+// for a Device which does not exist: DeleteDevice tells the two apart by retrieving the Device, hence it requires the
+// Client to have an AppEngineService. Without it, the 404 is returned as is.
+func (s *RealmManagementService) DeleteDevice(realm string, deviceID string) error {
+	callURL, _ := url.Parse(s.realmManagementURL.String())
+	callURL.Path = path.Join(callURL.Path, fmt.Sprintf("/v1/%s/devices/%s", realm, deviceID))
+	err := s.client.genericJSONDataAPIDelete(callURL.String(), 204)
+
+	var apiErr *APIError
+	switch {
+	case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusMethodNotAllowed:
+		return ErrDeviceDeletionNotSupported
+	case isNotFound(err) && s.client.AppEngine != nil:
+		if _, getErr := s.client.AppEngine.GetDevice(realm, deviceID, AstarteDeviceID); getErr == nil {
+			return ErrDeviceDeletionNotSupported
+		}
+	case err == nil:
+		s.deletions.add(realm, deviceID)
+	}
+	if s.client.AppEngine != nil && (err == nil || isNotFound(err)) {
+		s.client.AppEngine.aliasCache.evictDevice(realm, deviceID)
+	}
+	return err
+}
+
+// GetDeviceDeletionStatus returns the DeviceDeletionStatus of a Device. The deletion is pending if it was requested
+// through this RealmManagementService, or if AppEngine reports it in progress, and completed once AppEngine does not
+// know about the Device anymore. Hence, the Client must have an AppEngineService available.
+func (s *RealmManagementService) GetDeviceDeletionStatus(realm string, deviceID string) (DeviceDeletionStatus, error) {
+	if s.client.AppEngine == nil {
+		return DeviceDeletionNotRequested, errors.New("GetDeviceDeletionStatus requires an AppEngine service")
+	}
+
+	deviceDetails, err := s.client.AppEngine.GetDevice(realm, deviceID, AstarteDeviceID)
+	switch {
+	case isNotFound(err):
+		s.deletions.remove(realm, deviceID)
+		return DeviceDeletionCompleted, nil
+	case err != nil:
+		return DeviceDeletionNotRequested, err
+	case deviceDetails.DeletionInProgress || s.deletions.has(realm, deviceID):
+		return DeviceDeletionPending, nil
+	}
+	return DeviceDeletionNotRequested, nil
+}
+
+// WaitForDeviceDeletion polls the DeviceDeletionStatus of a Device until its deletion completes, and returns
+// ErrDeviceDeletionTimeout if it did not complete within timeout. Errors while polling are returned immediately.
+// Deletions requested by other clients are waited for too, even if they are not reported as pending.
+func (s *RealmManagementService) WaitForDeviceDeletion(realm string, deviceID string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := s.GetDeviceDeletionStatus(realm, deviceID)
+		if err != nil {
+			return err
+		}
+		if status == DeviceDeletionCompleted {
+			return nil
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return ErrDeviceDeletionTimeout
+		}
+		if wait > defaultDeviceDeletionPollInterval {
+			wait = defaultDeviceDeletionPollInterval
+		}
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeleteDevice(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	// Cache the alias of the Device
+	if _, err := client.AppEngine.GetDeviceIDFromAlias(testRealmName, "sensor-a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.RealmManagement.DeleteDevice(testRealmName, testDevices[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := client.AppEngine.aliasCache.get(testRealmName, "sensor-a"); ok {
+		t.Error("alias of deleted device is still cached")
+	}
+	if status, err := client.RealmManagement.GetDeviceDeletionStatus(testRealmName, testDevices[0]); err != nil ||
+		status != DeviceDeletionPending {
+		t.Errorf("unexpected status %v %v", status, err)
+	}
+	if status, err := client.RealmManagement.GetDeviceDeletionStatus(testRealmName, testDevices[1]); err != nil ||
+		status != DeviceDeletionNotRequested {
+		t.Errorf("unexpected status %v %v", status, err)
+	}
+
+	// The mock never deletes testDevices
+	if err := client.RealmManagement.WaitForDeviceDeletion(testRealmName, testDevices[0], 50*time.Millisecond); err != ErrDeviceDeletionTimeout {
+		t.Errorf("unexpected error %v", err)
+	}
+	if status, err := client.RealmManagement.GetDeviceDeletionStatus(testRealmName, "QwtRYCkWTmiuoQCtYjdA1g"); err != nil ||
+		status != DeviceDeletionCompleted {
+		t.Errorf("unexpected status %v %v", status, err)
+	}
+	if err := client.RealmManagement.WaitForDeviceDeletion(testRealmName, "QwtRYCkWTmiuoQCtYjdA1g", time.Minute); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDeleteDeviceNotSupported(t *testing.T) {
+	status := http.StatusNotFound
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodDelete && strings.HasPrefix(req.URL.Path, "/realmmanagement/") {
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": map[string]string{"detail": "Not found"}})
+			return
+		}
+		astarteAPIMock(w, req)
+	}))
+	defer server.Close()
+	client, err := NewClient(server.URL, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken(testTokenValue)
+
+	if err := client.RealmManagement.DeleteDevice(testRealmName, testDevices[0]); err != ErrDeviceDeletionNotSupported {
+		t.Errorf("unexpected error %v", err)
+	}
+	// Devices which do not exist are reported as such
+	err = client.RealmManagement.DeleteDevice(testRealmName, "QwtRYCkWTmiuoQCtYjdA1g")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// Without AppEngine, 404 is returned as is, while 405 needs no AppEngine to be told apart
+	client.AppEngine = nil
+	if err := client.RealmManagement.DeleteDevice(testRealmName, testDevices[0]); !isNotFound(err) {
+		t.Errorf("unexpected error %v", err)
+	}
+	status = http.StatusMethodNotAllowed
+	if err := client.RealmManagement.DeleteDevice(testRealmName, testDevices[0]); err != ErrDeviceDeletionNotSupported {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+
+	"github.com/astarte-platform/astarte-go/triggers"
+)
+
+// This file contains all API Calls related to trigger delivery policies
+
+// ErrDeliveryPolicyNotFound is returned by InstallTrigger when the trigger uses a Delivery Policy which is not installed.
+var ErrDeliveryPolicyNotFound = errors.New("delivery policy not found")
+
+// ListDeliveryPolicies returns the names of all trigger Delivery Policies in a Realm.
+func (s *RealmManagementService) ListDeliveryPolicies(realm string) ([]string, error) {
+	callURL, _ := url.Parse(s.realmManagementURL.String())
+	callURL.Path = path.Join(callURL.Path, fmt.Sprintf("/v1/%s/policies", realm))
+
+	policies := []string{}
+	err := s.client.genericJSONDataAPIGET(&policies, callURL.String(), 200)
+
+	return policies, err
+}
+
+// GetDeliveryPolicy returns a trigger Delivery Policy installed in a Realm
+func (s *RealmManagementService) GetDeliveryPolicy(realm string, policyName string) (triggers.DeliveryPolicy, error) {
+	callURL, _ := url.Parse(s.realmManagementURL.String())
+	callURL.Path = path.Join(callURL.Path, fmt.Sprintf("/v1/%s/policies/%s", realm, policyName))
+
+	policy := triggers.DeliveryPolicy{}
+	err := s.client.genericJSONDataAPIGET(&policy, callURL.String(), 200)
+
+	return policy, err
+}
+
+// InstallDeliveryPolicy installs a trigger Delivery Policy into the Realm, after validating it
+func (s *RealmManagementService) InstallDeliveryPolicy(realm string, policyPayload triggers.DeliveryPolicy) error {
+	if err := policyPayload.Validate(); err != nil {
+		return fmt.Errorf("invalid delivery policy %s: %w", policyPayload.Name, err)
+	}
+	callURL, _ := url.Parse(s.realmManagementURL.String())
+	callURL.Path = path.Join(callURL.Path, fmt.Sprintf("/v1/%s/policies", realm))
+	return s.client.genericJSONDataAPIPost(callURL.String(), policyPayload, 201)
+}
+
+// DeleteDeliveryPolicy deletes a trigger Delivery Policy from the Realm. Astarte refuses to delete policies which are
+// used by a trigger.
+func (s *RealmManagementService) DeleteDeliveryPolicy(realm string, policyName string) error {
+	callURL, _ := url.Parse(s.realmManagementURL.String())
+	callURL.Path = path.Join(callURL.Path, fmt.Sprintf("/v1/%s/policies/%s", realm, policyName))
+	return s.client.genericJSONDataAPIDelete(callURL.String(), 204)
+}
+
+// ensureDeliveryPolicyExists returns ErrDeliveryPolicyNotFound if policyName is not installed in the Realm
+func (s *RealmManagementService) ensureDeliveryPolicyExists(realm string, policyName string) error {
+	if _, err := s.GetDeliveryPolicy(realm, policyName); isNotFound(err) {
+		return fmt.Errorf("%w: %s", ErrDeliveryPolicyNotFound, policyName)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
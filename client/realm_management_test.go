@@ -0,0 +1,103 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/astarte-platform/astarte-go/triggers"
+)
+
+func TestTriggers(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	trigger, err := client.RealmManagement.GetTrigger(testRealmName, "test-trigger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trigger.SimpleTriggers) != 1 || trigger.SimpleTriggers[0].InterfaceMajor == nil ||
+		trigger.SimpleTriggers[0].KnownValue != json.Number("0") || trigger.Action.IgnoreSSLErrors == nil {
+		t.Errorf("unexpected trigger %+v", trigger)
+	}
+
+	if err := client.RealmManagement.InstallTrigger(testRealmName, trigger); err != nil {
+		t.Fatal(err)
+	}
+	requests := popTestWriteRequests()
+	if len(requests) != 1 {
+		t.Fatalf("unexpected requests %v", requests)
+	}
+	expected := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(testTriggers["test-trigger"]), &expected); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(requests[0].Data, expected) {
+		t.Errorf("unexpected payload %v", requests[0].Data)
+	}
+
+	if _, err := client.RealmManagement.GetTrigger(testRealmName, "missing"); err == nil {
+		t.Error("missing trigger was found")
+	}
+}
+
+func TestDeliveryPolicies(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	policies, err := client.RealmManagement.ListDeliveryPolicies(testRealmName)
+	if err != nil || !reflect.DeepEqual(policies, []string{"test-policy"}) {
+		t.Fatalf("unexpected policies %v %v", policies, err)
+	}
+	policy, err := client.RealmManagement.GetDeliveryPolicy(testRealmName, "test-policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policy.ErrorHandlers) != 2 || policy.ErrorHandlers[0].On.Keyword != triggers.ServerError ||
+		!reflect.DeepEqual(policy.ErrorHandlers[1].On.Codes, []int{404, 410}) || *policy.RetryTimes != 3 {
+		t.Errorf("unexpected policy %+v", policy)
+	}
+
+	if err := client.RealmManagement.InstallDeliveryPolicy(testRealmName, policy); err != nil {
+		t.Fatal(err)
+	}
+	policy.RetryTimes = nil
+	if err := client.RealmManagement.InstallDeliveryPolicy(testRealmName, policy); err == nil {
+		t.Error("invalid policy was installed")
+	}
+	if requests := popTestWriteRequests(); len(requests) != 1 {
+		t.Errorf("unexpected requests %v", requests)
+	}
+
+	trigger, err := client.RealmManagement.GetTrigger(testRealmName, "test-trigger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.RealmManagement.InstallTrigger(testRealmName, trigger.WithPolicy("test-policy")); err != nil {
+		t.Fatal(err)
+	}
+	if requests := popTestWriteRequests(); len(requests) != 1 || requests[0].Data.(map[string]interface{})["policy"] != "test-policy" {
+		t.Errorf("unexpected requests %v", requests)
+	}
+	if err := client.RealmManagement.InstallTrigger(testRealmName, trigger.WithPolicy("missing")); !errors.Is(err, ErrDeliveryPolicyNotFound) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if requests := popTestWriteRequests(); len(requests) != 0 {
+		t.Errorf("trigger with missing policy was installed %v", requests)
+	}
+}
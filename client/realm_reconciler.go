@@ -0,0 +1,832 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/astarte-platform/astarte-go/interfaces"
+	"github.com/astarte-platform/astarte-go/triggers"
+)
+
+// ErrRealmReconciliationRejected is returned by ReconcileRealm when the desired state cannot be reached. Nothing is
+// applied: the rejected actions of the report explain why.
+var ErrRealmReconciliationRejected = errors.New("the desired realm state cannot be applied")
+
+const (
+	realmInterfacesDir = "interfaces"
+	realmTriggersDir   = "triggers"
+	realmPoliciesDir   = "policies"
+)
+
+// RealmDesiredState is the desired configuration of a Realm
+type RealmDesiredState struct {
+	Interfaces []interfaces.AstarteInterface
+	Triggers   []triggers.AstarteTrigger
+	Policies   []triggers.DeliveryPolicy
+}
+
+// LoadRealmDesiredState reads a desired state from dir, which contains an interfaces, a triggers and a policies
+// directory with a JSON file for each Interface, Trigger and Delivery Policy. Missing directories are empty.
+func LoadRealmDesiredState(dir string) (RealmDesiredState, error) {
+	state := RealmDesiredState{}
+	err := forEachJSONFile(filepath.Join(dir, realmInterfacesDir), func(file string) error {
+		iface, err := interfaces.ParseInterfaceFromFile(file)
+		state.Interfaces = append(state.Interfaces, iface)
+		return err
+	})
+	if err != nil {
+		return RealmDesiredState{}, err
+	}
+	err = forEachJSONFile(filepath.Join(dir, realmTriggersDir), func(file string) error {
+		trigger, err := triggers.ParseTriggerFromFile(file)
+		state.Triggers = append(state.Triggers, trigger)
+		return err
+	})
+	if err != nil {
+		return RealmDesiredState{}, err
+	}
+	err = forEachJSONFile(filepath.Join(dir, realmPoliciesDir), func(file string) error {
+		policy, err := triggers.ParseDeliveryPolicyFromFile(file)
+		state.Policies = append(state.Policies, policy)
+		return err
+	})
+	if err != nil {
+		return RealmDesiredState{}, err
+	}
+	return state, state.checkDuplicates()
+}
+
+func forEachJSONFile(dir string, f func(file string) error) error {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		file := filepath.Join(dir, entry.Name())
+		if err := f(file); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+func (d RealmDesiredState) checkDuplicates() error {
+	seen := map[string]bool{}
+	for _, iface := range d.Interfaces {
+		key := fmt.Sprintf("interface %s v%d", iface.Name, iface.MajorVersion)
+		if seen[key] {
+			return fmt.Errorf("duplicate %s", key)
+		}
+		seen[key] = true
+	}
+	for _, trigger := range d.Triggers {
+		if seen["trigger "+trigger.Name] {
+			return fmt.Errorf("duplicate trigger %s", trigger.Name)
+		}
+		seen["trigger "+trigger.Name] = true
+	}
+	for _, policy := range d.Policies {
+		if seen["policy "+policy.Name] {
+			return fmt.Errorf("duplicate policy %s", policy.Name)
+		}
+		seen["policy "+policy.Name] = true
+	}
+	return nil
+}
+
+// RealmObjectKind is the kind of object managed by a RealmAction
+type RealmObjectKind string
+
+const (
+	// InterfaceRealmObject is an Interface, identified by name and major version
+	InterfaceRealmObject RealmObjectKind = "interface"
+	// TriggerRealmObject is a Trigger
+	TriggerRealmObject RealmObjectKind = "trigger"
+	// PolicyRealmObject is a trigger Delivery Policy
+	PolicyRealmObject RealmObjectKind = "policy"
+)
+
+// RealmActionType represents the kind of change needed to reconcile an object of a Realm with its desired state
+type RealmActionType string
+
+const (
+	// InstallRealmAction installs a new object, or a new major version of an Interface
+	InstallRealmAction RealmActionType = "install"
+	// UpdateRealmAction updates an Interface to a new minor version
+	UpdateRealmAction RealmActionType = "update"
+	// ReplaceRealmAction deletes an object and installs its desired version
+	ReplaceRealmAction RealmActionType = "replace"
+	// DeleteRealmAction deletes an object which is not in the desired state
+	DeleteRealmAction RealmActionType = "delete"
+	// RejectRealmAction is a change which Astarte does not allow, such as changing an Interface without bumping
+	// its version. Reason explains why.
+	RejectRealmAction RealmActionType = "reject"
+)
+
+// RealmAction is a single change needed to reconcile a Realm with its desired state. Major and Minor are set for
+// Interfaces: Minor is the desired minor version. Deleted is set once the installed version of a replaced object
+// is deleted: if the action is not Applied, the object is missing from the Realm. Replaced Triggers are installed
+// again in their previous version when the reconciliation fails, and Deleted is reset if that succeeds.
+type RealmAction struct {
+	Type    RealmActionType
+	Kind    RealmObjectKind
+	Name    string
+	Major   *int
+	Minor   *int
+	Reason  string
+	Applied bool
+	Deleted bool
+	Err     error
+
+	iface            interfaces.AstarteInterface
+	trigger          triggers.AstarteTrigger
+	installedTrigger triggers.AstarteTrigger
+	policy           triggers.DeliveryPolicy
+}
+
+// MarshalJSON marshals the action, with Err as its message
+func (a RealmAction) MarshalJSON() ([]byte, error) {
+	action := struct {
+		Type    RealmActionType `json:"type"`
+		Kind    RealmObjectKind `json:"kind"`
+		Name    string          `json:"name"`
+		Major   *int            `json:"major,omitempty"`
+		Minor   *int            `json:"minor,omitempty"`
+		Reason  string          `json:"reason,omitempty"`
+		Applied bool            `json:"applied"`
+		Deleted bool            `json:"deleted,omitempty"`
+		Error   string          `json:"error,omitempty"`
+	}{Type: a.Type, Kind: a.Kind, Name: a.Name, Major: a.Major, Minor: a.Minor, Reason: a.Reason, Applied: a.Applied,
+		Deleted: a.Deleted}
+	if a.Err != nil {
+		action.Error = a.Err.Error()
+	}
+	return json.Marshal(action)
+}
+
+func (a RealmAction) String() string {
+	object := fmt.Sprintf("%s %s", a.Kind, a.Name)
+	if a.Major != nil {
+		object = fmt.Sprintf("%s v%d", object, *a.Major)
+		if a.Minor != nil {
+			object = fmt.Sprintf("%s.%d", object, *a.Minor)
+		}
+	}
+	if a.Reason != "" {
+		return fmt.Sprintf("%s %s: %s", a.Type, object, a.Reason)
+	}
+	return fmt.Sprintf("%s %s", a.Type, object)
+}
+
+// RealmReconciliationReport is the plan, or the outcome if the reconciliation is not a dry run, of ReconcileRealm.
+// Actions are in the order they are applied: if one fails, the following ones are not applied. Replaced Triggers
+// using a replaced Delivery Policy are deleted just before the policy, so their actions might be marked Deleted even
+// if they are not applied. The report can be marshaled to JSON for automated review.
+type RealmReconciliationReport struct {
+	Realm   string        `json:"realm"`
+	Actions []RealmAction `json:"actions"`
+	DryRun  bool          `json:"dry_run"`
+}
+
+// Rejected returns the actions which prevent the desired state from being applied
+func (r RealmReconciliationReport) Rejected() []RealmAction {
+	rejected := []RealmAction{}
+	for _, action := range r.Actions {
+		if action.Type == RejectRealmAction {
+			rejected = append(rejected, action)
+		}
+	}
+	return rejected
+}
+
+// Write writes a human readable plan, or outcome if the report is not a dry run, to w.
+func (r RealmReconciliationReport) Write(w io.Writer) error {
+	if len(r.Actions) == 0 {
+		_, err := fmt.Fprintf(w, "realm %s: up to date\n", r.Realm)
+		return err
+	}
+	for _, action := range r.Actions {
+		status := "planned"
+		switch {
+		case action.Type == RejectRealmAction:
+			status = "rejected"
+		case action.Err != nil:
+			status = "failed"
+		case action.Applied:
+			status = "applied"
+		case action.Deleted:
+			status = "partially applied"
+		case !r.DryRun:
+			status = "skipped"
+		}
+		line := fmt.Sprintf("realm %s: %s %s", r.Realm, status, action)
+		if action.Err != nil {
+			line = fmt.Sprintf("%s: %v", line, action.Err)
+		}
+		if action.Deleted && !action.Applied {
+			line = fmt.Sprintf("%s (deleted, not installed again)", line)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RealmReconciliationOptions configures ReconcileRealm
+type RealmReconciliationOptions struct {
+	// Prune deletes the Interfaces, Triggers and Delivery Policies of the Realm which are not in the desired state.
+	// Only draft Interfaces (major version 0) can be deleted.
+	Prune bool
+	// DryRun only computes the actions needed to reconcile the Realm, without applying them.
+	DryRun bool
+}
+
+// realmState is the current configuration of a Realm, indexed by name (and major version for Interfaces)
+type realmState struct {
+	interfaces map[string]interfaces.AstarteInterface
+	triggers   map[string]triggers.AstarteTrigger
+	policies   map[string]triggers.DeliveryPolicy
+}
+
+func interfaceKey(name string, major int) string {
+	return fmt.Sprintf("%s/%d", name, major)
+}
+
+// ReconcileRealm compares the Interfaces, Triggers and Delivery Policies of realm with desired and applies the
+// needed changes: new objects and Interface majors are installed, Interfaces with a new minor version are updated,
+// and changed Triggers and Delivery Policies are replaced. Triggers using a replaced Delivery Policy are replaced
+// too. Changes Astarte does not allow, such as changing an Interface without bumping its minor version, are
+// rejected: in that case nothing is applied and ErrRealmReconciliationRejected is returned along with the report.
+// Reconciling an up to date Realm is a no-op.
+func (s *RealmManagementService) ReconcileRealm(realm string, desired RealmDesiredState,
+	options RealmReconciliationOptions) (RealmReconciliationReport, error) {
+	report, err := s.planRealmReconciliation(realm, desired, options)
+	if err != nil {
+		return report, err
+	}
+	if len(report.Rejected()) > 0 {
+		return report, ErrRealmReconciliationRejected
+	}
+	if options.DryRun {
+		return report, nil
+	}
+	return report, s.applyRealmReconciliation(&report)
+}
+
+func (s *RealmManagementService) planRealmReconciliation(realm string, desired RealmDesiredState,
+	options RealmReconciliationOptions) (RealmReconciliationReport, error) {
+	if err := desired.checkDuplicates(); err != nil {
+		return RealmReconciliationReport{}, err
+	}
+	current, err := s.getRealmState(realm, len(desired.Policies) > 0)
+	if err != nil {
+		return RealmReconciliationReport{}, err
+	}
+	return RealmReconciliationReport{Realm: realm, Actions: planRealmActions(desired, current, options.Prune),
+		DryRun: options.DryRun}, nil
+}
+
+func (s *RealmManagementService) applyRealmReconciliation(report *RealmReconciliationReport) error {
+	for i := range report.Actions {
+		action := &report.Actions[i]
+		if action.Kind == PolicyRealmObject && action.Type == ReplaceRealmAction {
+			if err := s.deleteReplacedTriggers(report, action.Name); err != nil {
+				s.restoreReplacedTriggers(report)
+				return err
+			}
+		}
+		if action.Err = s.applyRealmAction(report.Realm, action); action.Err != nil {
+			s.restoreReplacedTriggers(report)
+			return fmt.Errorf("%s: %w", action, action.Err)
+		}
+		action.Applied = true
+	}
+	return nil
+}
+
+// deleteReplacedTriggers deletes the replaced Triggers whose installed version uses policy, since a Delivery Policy
+// cannot be deleted while it is in use. The other replaced Triggers are deleted just before being installed again.
+func (s *RealmManagementService) deleteReplacedTriggers(report *RealmReconciliationReport, policy string) error {
+	for i := range report.Actions {
+		action := &report.Actions[i]
+		if action.Kind != TriggerRealmObject || action.Type != ReplaceRealmAction || action.installedTrigger.Policy != policy {
+			continue
+		}
+		if action.Err = s.DeleteTrigger(report.Realm, action.Name); action.Err != nil {
+			return fmt.Errorf("%s: %w", action, action.Err)
+		}
+		action.Deleted = true
+	}
+	return nil
+}
+
+// restoreReplacedTriggers installs again the previous version of the replaced Triggers which were deleted and not
+// installed again. This is best effort: the Triggers which cannot be restored stay Deleted.
+func (s *RealmManagementService) restoreReplacedTriggers(report *RealmReconciliationReport) {
+	for i := range report.Actions {
+		action := &report.Actions[i]
+		if action.Kind == TriggerRealmObject && action.Deleted && !action.Applied &&
+			s.InstallTrigger(report.Realm, action.installedTrigger) == nil {
+			action.Deleted = false
+		}
+	}
+}
+
+func (s *RealmManagementService) getRealmState(realm string, needsPolicies bool) (realmState, error) {
+	state := realmState{
+		interfaces: map[string]interfaces.AstarteInterface{},
+		triggers:   map[string]triggers.AstarteTrigger{},
+		policies:   map[string]triggers.DeliveryPolicy{},
+	}
+
+	interfaceNames, err := s.ListInterfaces(realm)
+	if err != nil {
+		return realmState{}, err
+	}
+	for _, name := range interfaceNames {
+		majors, err := s.ListInterfaceMajorVersions(realm, name)
+		if err != nil {
+			return realmState{}, err
+		}
+		for _, major := range majors {
+			iface, err := s.GetInterface(realm, name, major)
+			if err != nil {
+				return realmState{}, err
+			}
+			state.interfaces[interfaceKey(name, major)] = iface
+		}
+	}
+
+	triggerNames, err := s.ListTriggers(realm)
+	if err != nil {
+		return realmState{}, err
+	}
+	for _, name := range triggerNames {
+		if state.triggers[name], err = s.GetTrigger(realm, name); err != nil {
+			return realmState{}, err
+		}
+	}
+
+	policyNames, err := s.ListDeliveryPolicies(realm)
+	// Astarte versions without Delivery Policies are fine, as long as none is needed
+	if isNotFound(err) && !needsPolicies {
+		return state, nil
+	} else if err != nil {
+		return realmState{}, err
+	}
+	for _, name := range policyNames {
+		if state.policies[name], err = s.GetDeliveryPolicy(realm, name); err != nil {
+			return realmState{}, err
+		}
+	}
+	return state, nil
+}
+
+// realmPlanner collects the actions needed to reconcile a Realm. The planners of each kind of object record which
+// objects are desired or replaced, since the planners of the objects using them depend on it.
+type realmPlanner struct {
+	desired RealmDesiredState
+	current realmState
+	prune   bool
+	actions []RealmAction
+
+	desiredInterfaces map[string]bool
+	desiredPolicies   map[string]bool
+	replacedPolicies  map[string]bool
+	desiredTriggers   map[string]bool
+}
+
+func planRealmActions(desired RealmDesiredState, current realmState, prune bool) []RealmAction {
+	p := &realmPlanner{
+		desired:           desired,
+		current:           current,
+		prune:             prune,
+		actions:           []RealmAction{},
+		desiredInterfaces: map[string]bool{},
+		desiredPolicies:   map[string]bool{},
+		replacedPolicies:  map[string]bool{},
+		desiredTriggers:   map[string]bool{},
+	}
+	p.planInterfaces()
+	p.planPolicies()
+	p.planTriggers()
+	p.planUnmanagedTriggers()
+	if prune {
+		p.prunePolicies()
+		p.pruneInterfaces()
+	}
+
+	sort.SliceStable(p.actions, func(i, j int) bool {
+		return realmActionPhase(p.actions[i]) < realmActionPhase(p.actions[j])
+	})
+	return p.actions
+}
+
+func (p *realmPlanner) planInterfaces() {
+	for _, iface := range p.desired.Interfaces {
+		p.desiredInterfaces[interfaceKey(iface.Name, iface.MajorVersion)] = true
+		p.actions = append(p.actions, planInterface(iface, p.current)...)
+	}
+}
+
+func (p *realmPlanner) planPolicies() {
+	for _, policy := range p.desired.Policies {
+		p.desiredPolicies[policy.Name] = true
+		installed, ok := p.current.policies[policy.Name]
+		action := RealmAction{Kind: PolicyRealmObject, Name: policy.Name, policy: policy}
+		validationErr := policy.Validate()
+		switch {
+		case validationErr != nil:
+			action.Type, action.Reason = RejectRealmAction, validationErr.Error()
+		case !ok:
+			action.Type = InstallRealmAction
+		case !samePolicy(installed, policy):
+			action.Type = ReplaceRealmAction
+			p.replacedPolicies[policy.Name] = true
+		default:
+			continue
+		}
+		p.actions = append(p.actions, action)
+	}
+}
+
+func (p *realmPlanner) planTriggers() {
+	// Triggers are validated against the Interfaces the Realm will have
+	availableInterfaces := append([]interfaces.AstarteInterface{}, p.desired.Interfaces...)
+	for key, iface := range p.current.interfaces {
+		if !p.desiredInterfaces[key] && !p.prune {
+			availableInterfaces = append(availableInterfaces, iface)
+		}
+	}
+	for _, trigger := range p.desired.Triggers {
+		p.desiredTriggers[trigger.Name] = true
+		if action, needed := p.planTrigger(trigger, availableInterfaces); needed {
+			p.actions = append(p.actions, action)
+		}
+	}
+}
+
+// planTrigger returns the action reconciling trigger, and whether it is needed
+func (p *realmPlanner) planTrigger(trigger triggers.AstarteTrigger, availableInterfaces []interfaces.AstarteInterface) (RealmAction, bool) {
+	installed, ok := p.current.triggers[trigger.Name]
+	action := RealmAction{Kind: TriggerRealmObject, Name: trigger.Name, trigger: trigger, installedTrigger: installed}
+	findings := triggers.Validate(trigger, availableInterfaces)
+	switch {
+	case findings.HasErrors():
+		action.Type, action.Reason = RejectRealmAction, firstValidationError(findings)
+	case trigger.Policy != "" && !p.policyAvailable(trigger.Policy):
+		action.Type, action.Reason = RejectRealmAction, fmt.Sprintf("delivery policy %s is not available", trigger.Policy)
+	case !ok:
+		action.Type = InstallRealmAction
+	case !sameTrigger(installed, trigger):
+		action.Type = ReplaceRealmAction
+	case p.replacedPolicies[installed.Policy]:
+		action.Type, action.Reason = ReplaceRealmAction, fmt.Sprintf("delivery policy %s is replaced", installed.Policy)
+	default:
+		return RealmAction{}, false
+	}
+	return action, true
+}
+
+// policyAvailable returns whether a Delivery Policy will be installed after the reconciliation
+func (p *realmPlanner) policyAvailable(name string) bool {
+	_, installed := p.current.policies[name]
+	return p.desiredPolicies[name] || (installed && !p.prune)
+}
+
+// planUnmanagedTriggers deletes the Triggers which are not in the desired state when pruning, and rejects
+// replacing the Delivery Policies they use otherwise
+func (p *realmPlanner) planUnmanagedTriggers() {
+	for _, name := range sortedTriggerNames(p.current.triggers) {
+		trigger := p.current.triggers[name]
+		switch {
+		case p.desiredTriggers[name]:
+		case p.prune:
+			p.actions = append(p.actions, RealmAction{Type: DeleteRealmAction, Kind: TriggerRealmObject, Name: name})
+		case p.replacedPolicies[trigger.Policy]:
+			p.actions = append(p.actions, RealmAction{Type: RejectRealmAction, Kind: PolicyRealmObject, Name: trigger.Policy,
+				Reason: fmt.Sprintf("it is used by unmanaged trigger %s", name)})
+		}
+	}
+}
+
+func (p *realmPlanner) prunePolicies() {
+	policyNames := []string{}
+	for name := range p.current.policies {
+		if !p.desiredPolicies[name] {
+			policyNames = append(policyNames, name)
+		}
+	}
+	sort.Strings(policyNames)
+	for _, name := range policyNames {
+		p.actions = append(p.actions, RealmAction{Type: DeleteRealmAction, Kind: PolicyRealmObject, Name: name})
+	}
+}
+
+func (p *realmPlanner) pruneInterfaces() {
+	interfaceKeys := []string{}
+	for key := range p.current.interfaces {
+		if !p.desiredInterfaces[key] {
+			interfaceKeys = append(interfaceKeys, key)
+		}
+	}
+	sort.Strings(interfaceKeys)
+	for _, key := range interfaceKeys {
+		iface := p.current.interfaces[key]
+		action := RealmAction{Type: DeleteRealmAction, Kind: InterfaceRealmObject, Name: iface.Name,
+			Major: intPtr(iface.MajorVersion)}
+		if iface.MajorVersion > 0 {
+			action.Type, action.Reason = RejectRealmAction, "only draft interfaces (major version 0) can be deleted"
+		}
+		p.actions = append(p.actions, action)
+	}
+}
+
+func sortedTriggerNames(installed map[string]triggers.AstarteTrigger) []string {
+	names := []string{}
+	for name := range installed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func planInterface(iface interfaces.AstarteInterface, current realmState) []RealmAction {
+	action := RealmAction{Kind: InterfaceRealmObject, Name: iface.Name, Major: intPtr(iface.MajorVersion),
+		Minor: intPtr(iface.MinorVersion), iface: iface}
+	installed, ok := current.interfaces[interfaceKey(iface.Name, iface.MajorVersion)]
+	switch {
+	case !ok:
+		action.Type = InstallRealmAction
+	case iface.MinorVersion < installed.MinorVersion:
+		action.Type = RejectRealmAction
+		action.Reason = fmt.Sprintf("minor version %d is lower than the installed %d", iface.MinorVersion, installed.MinorVersion)
+	case iface.MinorVersion == installed.MinorVersion:
+		if sameInterface(installed, iface) {
+			return nil
+		}
+		action.Type, action.Reason = RejectRealmAction, "changed without bumping the minor version"
+	default:
+		if problem := interfaceUpdateProblem(installed, iface); problem != "" {
+			action.Type, action.Reason = RejectRealmAction, problem
+		} else {
+			action.Type = UpdateRealmAction
+		}
+	}
+	return []RealmAction{action}
+}
+
+// interfaceUpdateProblem returns why updating installed to a new minor version is not allowed, or an empty string.
+// Minor versions can only add mappings and change descriptions.
+func interfaceUpdateProblem(installed, updated interfaces.AstarteInterface) string {
+	if installed.Type != updated.Type || installed.Ownership != updated.Ownership ||
+		installed.Aggregation != updated.Aggregation || installed.ExplicitTimestamp != updated.ExplicitTimestamp ||
+		installed.HasMetadata != updated.HasMetadata {
+		return "type, ownership, aggregation and timestamping cannot change in a minor version"
+	}
+	updatedMappings := map[string]interfaces.AstarteInterfaceMapping{}
+	for _, mapping := range updated.Mappings {
+		updatedMappings[mapping.Endpoint] = mapping
+	}
+	for _, mapping := range installed.Mappings {
+		updatedMapping, ok := updatedMappings[mapping.Endpoint]
+		if !ok {
+			return fmt.Sprintf("mapping %s cannot be removed in a minor version", mapping.Endpoint)
+		}
+		mapping.Description, mapping.Documentation = updatedMapping.Description, updatedMapping.Documentation
+		if mapping != updatedMapping {
+			return fmt.Sprintf("mapping %s cannot change in a minor version", mapping.Endpoint)
+		}
+	}
+	return ""
+}
+
+// realmActionPhase orders actions so that each one can be applied: Triggers are deleted before the Delivery
+// Policies and Interfaces they use, and installed after them
+func realmActionPhase(action RealmAction) int {
+	switch {
+	case action.Type == RejectRealmAction:
+		return 0
+	case action.Kind == TriggerRealmObject && action.Type == DeleteRealmAction:
+		return 1
+	case action.Kind == PolicyRealmObject:
+		return 2
+	case action.Kind == InterfaceRealmObject && action.Type != DeleteRealmAction:
+		return 3
+	case action.Kind == TriggerRealmObject:
+		return 4
+	}
+	return 5
+}
+
+// applyRealmAction applies action, setting Deleted once the installed version of a replaced object is deleted
+func (s *RealmManagementService) applyRealmAction(realm string, action *RealmAction) error {
+	switch action.Kind {
+	case InterfaceRealmObject:
+		switch action.Type {
+		case InstallRealmAction:
+			return s.InstallInterface(realm, action.iface)
+		case UpdateRealmAction:
+			return s.UpdateInterface(realm, action.Name, *action.Major, action.iface)
+		case DeleteRealmAction:
+			return s.DeleteInterface(realm, action.Name, *action.Major)
+		}
+	case TriggerRealmObject:
+		return s.applyTriggerAction(realm, action)
+	case PolicyRealmObject:
+		switch action.Type {
+		case InstallRealmAction:
+			return s.InstallDeliveryPolicy(realm, action.policy)
+		case ReplaceRealmAction:
+			if err := s.DeleteDeliveryPolicy(realm, action.Name); err != nil {
+				return err
+			}
+			action.Deleted = true
+			return s.InstallDeliveryPolicy(realm, action.policy)
+		case DeleteRealmAction:
+			return s.DeleteDeliveryPolicy(realm, action.Name)
+		}
+	}
+	return errors.New("unknown action")
+}
+
+func (s *RealmManagementService) applyTriggerAction(realm string, action *RealmAction) error {
+	switch action.Type {
+	case InstallRealmAction:
+		return s.InstallTrigger(realm, action.trigger)
+	case ReplaceRealmAction:
+		// Triggers using a replaced Delivery Policy are already deleted
+		if !action.Deleted {
+			if err := s.DeleteTrigger(realm, action.Name); err != nil {
+				return err
+			}
+			action.Deleted = true
+		}
+		return s.InstallTrigger(realm, action.trigger)
+	case DeleteRealmAction:
+		return s.DeleteTrigger(realm, action.Name)
+	}
+	return errors.New("unknown action")
+}
+
+// sameInterface returns whether a and b are the same Interface once defaults are set, regardless of the order
+// of their mappings
+func sameInterface(a, b interfaces.AstarteInterface) bool {
+	a, b = interfaces.EnsureInterfaceDefaults(a), interfaces.EnsureInterfaceDefaults(b)
+	if len(a.Mappings) != len(b.Mappings) {
+		return false
+	}
+	bMappings := map[string]interfaces.AstarteInterfaceMapping{}
+	for _, mapping := range b.Mappings {
+		bMappings[mapping.Endpoint] = mapping
+	}
+	for _, mapping := range a.Mappings {
+		if bMapping, ok := bMappings[mapping.Endpoint]; !ok || bMapping != mapping {
+			return false
+		}
+	}
+	a.Mappings, b.Mappings = nil, nil
+	return reflect.DeepEqual(a, b)
+}
+
+// sameTrigger returns whether a and b are the same Trigger once the defaults Astarte applies are set
+func sameTrigger(a, b triggers.AstarteTrigger) bool {
+	return reflect.DeepEqual(normalizeTrigger(a), normalizeTrigger(b))
+}
+
+// normalizeTrigger returns a copy of trigger with the defaults Astarte applies set, without null or empty fields
+func normalizeTrigger(trigger triggers.AstarteTrigger) triggers.AstarteTrigger {
+	trigger.Extra = withoutNullFields(trigger.Extra)
+	trigger.Action = normalizeTriggerAction(trigger.Action)
+	simpleTriggers := []triggers.SimpleTrigger{}
+	for _, simpleTrigger := range trigger.SimpleTriggers {
+		simpleTrigger.Extra = withoutNullFields(simpleTrigger.Extra)
+		if simpleTrigger.Type == triggers.DataTriggerType && simpleTrigger.ValueMatchOperator == "" {
+			simpleTrigger.ValueMatchOperator = triggers.AnyValueOperator
+		}
+		simpleTrigger.KnownValue = normalizeKnownValue(simpleTrigger.KnownValue)
+		simpleTriggers = append(simpleTriggers, simpleTrigger)
+	}
+	trigger.SimpleTriggers = simpleTriggers
+	return trigger
+}
+
+func normalizeTriggerAction(action triggers.TriggerAction) triggers.TriggerAction {
+	action.Extra = withoutNullFields(action.Extra)
+	if action.HTTPPostURL != "" && action.HTTPURL == "" {
+		action.HTTPURL, action.HTTPMethod, action.HTTPPostURL = action.HTTPPostURL, "post", ""
+	}
+	action.HTTPMethod = strings.ToLower(action.HTTPMethod)
+	if action.IsHTTP() && action.IgnoreSSLErrors == nil {
+		ignoreSSLErrors := false
+		action.IgnoreSSLErrors = &ignoreSSLErrors
+	}
+	if len(action.HTTPStaticHeaders) == 0 {
+		action.HTTPStaticHeaders = nil
+	}
+	if len(action.AMQPStaticHeaders) == 0 {
+		action.AMQPStaticHeaders = nil
+	}
+	return action
+}
+
+// normalizeKnownValue returns value as decoded from JSON, so that numbers are compared by their representation
+func normalizeKnownValue(value interface{}) interface{} {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var normalized interface{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if decoder.Decode(&normalized) != nil {
+		return value
+	}
+	return normalized
+}
+
+func withoutNullFields(fields map[string]json.RawMessage) map[string]json.RawMessage {
+	normalized := map[string]json.RawMessage{}
+	for name, value := range fields {
+		if strings.TrimSpace(string(value)) != "null" {
+			normalized[name] = value
+		}
+	}
+	if len(normalized) == 0 {
+		return nil
+	}
+	return normalized
+}
+
+// samePolicy returns whether a and b are the same Delivery Policy, regardless of the order of their error handlers
+// and of the status codes they handle
+func samePolicy(a, b triggers.DeliveryPolicy) bool {
+	return reflect.DeepEqual(normalizePolicy(a), normalizePolicy(b))
+}
+
+func normalizePolicy(policy triggers.DeliveryPolicy) triggers.DeliveryPolicy {
+	handlers := []triggers.ErrorHandler{}
+	for _, handler := range policy.ErrorHandlers {
+		if len(handler.On.Codes) > 0 {
+			handler.On.Codes = append([]int{}, handler.On.Codes...)
+			sort.Ints(handler.On.Codes)
+		} else {
+			handler.On.Codes = nil
+		}
+		handlers = append(handlers, handler)
+	}
+	sort.SliceStable(handlers, func(i, j int) bool {
+		return errorHandlerSortKey(handlers[i]) < errorHandlerSortKey(handlers[j])
+	})
+	policy.ErrorHandlers = handlers
+	return policy
+}
+
+func errorHandlerSortKey(handler triggers.ErrorHandler) string {
+	if handler.On.Keyword != "" {
+		return string(handler.On.Keyword)
+	}
+	return fmt.Sprint(handler.On.Codes)
+}
+
+func firstValidationError(findings triggers.ValidationFindings) string {
+	for _, finding := range findings {
+		if finding.Severity == triggers.ErrorSeverity {
+			return fmt.Sprintf("%s: %s", finding.Field, finding.Message)
+		}
+	}
+	return ""
+}
+
+func intPtr(i int) *int {
+	return &i
+}
@@ -0,0 +1,303 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/astarte-platform/astarte-go/interfaces"
+)
+
+// writeTestRealmDir writes files, indexed by their path relative to the returned directory
+func writeTestRealmDir(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "realm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// testRealmFiles returns the configuration of the mocked realm as a desired state directory
+func testRealmFiles() map[string]string {
+	files := map[string]string{}
+	for name, iface := range testInterfaces {
+		files["interfaces/"+name+".json"] = iface
+	}
+	for name, trigger := range testTriggers {
+		files["triggers/"+name+".json"] = trigger
+	}
+	for name, policy := range testDeliveryPolicies {
+		files["policies/"+name+".json"] = policy
+	}
+	return files
+}
+
+func TestReconcileRealm(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	files := testRealmFiles()
+	delete(files, "interfaces/org.astarte-platform.genericsensors.SamplingRate.json")
+	files["interfaces/org.astarte-platform.genericsensors.Values.json"] = strings.Replace(
+		strings.Replace(testInterfaces["org.astarte-platform.genericsensors.Values"], `"version_minor": 1`, `"version_minor": 2`, 1),
+		`"mappings": [`, `"mappings": [{"endpoint": "/%{sensor_id}/raw", "type": "integer"},`, 1)
+	files["interfaces/org.example.Status.json"] = `{"interface_name": "org.example.Status", "version_major": 1,
+		"version_minor": 0, "type": "properties", "ownership": "device", "mappings": [{"endpoint": "/online", "type": "boolean"}]}`
+	files["triggers/status.json"] = `{"name": "status", "action": {"http_url": "https://example.com/status", "http_method": "post"},
+		"simple_triggers": [{"type": "data_trigger", "on": "value_change", "interface_name": "org.example.Status",
+		"interface_major": 1, "match_path": "/online", "value_match_operator": "==", "known_value": false}]}`
+	files["triggers/test-trigger.json"] = strings.Replace(testTriggers["test-trigger"], `"name": "test-trigger",`,
+		`"name": "test-trigger", "policy": "test-policy",`, 1)
+	dir := writeTestRealmDir(t, files)
+	defer os.RemoveAll(dir)
+
+	desired, err := LoadRealmDesiredState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := client.RealmManagement.ReconcileRealm(testRealmName, desired, RealmReconciliationOptions{Prune: true, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := []string{}
+	for _, action := range report.Actions {
+		actions = append(actions, action.String())
+	}
+	expectedActions := []string{
+		"update interface org.astarte-platform.genericsensors.Values v0.2",
+		"install interface org.example.Status v1.0",
+		"install trigger status",
+		"replace trigger test-trigger",
+		"delete interface org.astarte-platform.genericsensors.SamplingRate v0",
+	}
+	if !reflect.DeepEqual(actions, expectedActions) {
+		t.Errorf("unexpected plan %v", actions)
+	}
+	if requests := popTestWriteRequests(); len(requests) != 0 {
+		t.Errorf("dry run sent requests %v", requests)
+	}
+	marshaled, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plan struct {
+		Actions []map[string]interface{} `json:"actions"`
+	}
+	if err := json.Unmarshal(marshaled, &plan); err != nil || plan.Actions[0]["type"] != "update" || plan.Actions[0]["minor"] != 2.0 {
+		t.Errorf("unexpected JSON plan %s", marshaled)
+	}
+
+	report, err = client.RealmManagement.ReconcileRealm(testRealmName, desired, RealmReconciliationOptions{Prune: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	realmManagementPath := "/realmmanagement/v1/" + testRealmName
+	expectedPaths := []string{
+		realmManagementPath + "/interfaces/org.astarte-platform.genericsensors.Values/0",
+		realmManagementPath + "/interfaces",
+		realmManagementPath + "/triggers",
+		realmManagementPath + "/triggers/test-trigger",
+		realmManagementPath + "/triggers",
+		realmManagementPath + "/interfaces/org.astarte-platform.genericsensors.SamplingRate/0",
+	}
+	if paths := testWriteRequestPaths(popTestWriteRequests()); !reflect.DeepEqual(paths, expectedPaths) {
+		t.Errorf("unexpected requests %v", paths)
+	}
+	for _, action := range report.Actions {
+		if !action.Applied {
+			t.Errorf("%s was not applied", action)
+		}
+	}
+}
+
+func TestReconcileRealmUpToDate(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	dir := writeTestRealmDir(t, testRealmFiles())
+	defer os.RemoveAll(dir)
+	desired, err := LoadRealmDesiredState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := client.RealmManagement.ReconcileRealm(testRealmName, desired, RealmReconciliationOptions{Prune: true})
+	if err != nil || len(report.Actions) != 0 {
+		t.Errorf("unexpected report %v %v", report, err)
+	}
+}
+
+func TestReconcileRealmNormalized(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	// Mappings and error handlers in a different order, and defaults Astarte applies left implicit, are no changes
+	files := testRealmFiles()
+	iface, err := interfaces.ParseInterfaceFromString(testInterfaces["org.astarte-platform.genericsensors.AvailableSensors"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	iface.Mappings[0], iface.Mappings[1] = iface.Mappings[1], iface.Mappings[0]
+	ifaceJSON, _ := json.Marshal(iface)
+	files["interfaces/org.astarte-platform.genericsensors.AvailableSensors.json"] = string(ifaceJSON)
+	files["triggers/test-trigger.json"] = strings.Replace(testTriggers["test-trigger"], `"ignore_ssl_errors": false`,
+		`"http_static_headers": {}, "template": null`, 1)
+	files["policies/test-policy.json"] = `{"name": "test-policy", "maximum_capacity": 100, "retry_times": 3, "event_ttl": 60,
+		"error_handlers": [{"on": [410, 404], "strategy": "discard"}, {"on": "server_error", "strategy": "retry"}]}`
+	dir := writeTestRealmDir(t, files)
+	defer os.RemoveAll(dir)
+	desired, err := LoadRealmDesiredState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := client.RealmManagement.ReconcileRealm(testRealmName, desired, RealmReconciliationOptions{})
+	if err != nil || len(report.Actions) != 0 {
+		t.Errorf("unexpected report %v %v", report, err)
+	}
+}
+
+func TestReconcileRealmRejected(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	files := testRealmFiles()
+	files["interfaces/org.astarte-platform.genericsensors.AvailableSensors.json"] = strings.Replace(
+		testInterfaces["org.astarte-platform.genericsensors.AvailableSensors"], `"type": "string"`, `"type": "integer"`, 1)
+	files["policies/test-policy.json"] = strings.Replace(testDeliveryPolicies["test-policy"], `"maximum_capacity": 100`,
+		`"maximum_capacity": 200`, 1)
+	dir := writeTestRealmDir(t, files)
+	defer os.RemoveAll(dir)
+	desired, err := LoadRealmDesiredState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := client.RealmManagement.ReconcileRealm(testRealmName, desired, RealmReconciliationOptions{})
+	if !errors.Is(err, ErrRealmReconciliationRejected) {
+		t.Errorf("unexpected error %v", err)
+	}
+	rejected := report.Rejected()
+	if len(rejected) != 1 || rejected[0].Name != "org.astarte-platform.genericsensors.AvailableSensors" {
+		t.Errorf("unexpected rejections %v", rejected)
+	}
+	if requests := popTestWriteRequests(); len(requests) != 0 {
+		t.Errorf("rejected plan sent requests %v", requests)
+	}
+}
+
+func TestReconcileRealmPartialFailure(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	// The mock does not keep installed policies, so the Trigger using it cannot be installed again
+	files := testRealmFiles()
+	files["policies/new-policy.json"] = strings.Replace(testDeliveryPolicies["test-policy"], `"name": "test-policy"`,
+		`"name": "new-policy"`, 1)
+	files["triggers/test-trigger.json"] = strings.Replace(testTriggers["test-trigger"], `"name": "test-trigger",`,
+		`"name": "test-trigger", "policy": "new-policy",`, 1)
+	dir := writeTestRealmDir(t, files)
+	defer os.RemoveAll(dir)
+	desired, err := LoadRealmDesiredState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := client.RealmManagement.ReconcileRealm(testRealmName, desired, RealmReconciliationOptions{})
+	if !errors.Is(err, ErrDeliveryPolicyNotFound) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// The previous version of the Trigger is installed again
+	requests := popTestWriteRequests()
+	restore := requests[len(requests)-1]
+	restored, _ := restore.Data.(map[string]interface{})
+	if restore.Method != http.MethodPost || restored["name"] != "test-trigger" || restored["policy"] != nil {
+		t.Errorf("unexpected restore request %+v", restore)
+	}
+	action := report.Actions[len(report.Actions)-1]
+	if action.Name != "test-trigger" || action.Applied || action.Deleted {
+		t.Errorf("unexpected action %+v", action)
+	}
+	var output strings.Builder
+	if err := report.Write(&output); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output.String(), "failed replace trigger test-trigger") ||
+		strings.Contains(output.String(), "(deleted, not installed again)") {
+		t.Errorf("unexpected output %s", output.String())
+	}
+}
+
+func TestReconcileRealmFailureKeepsTriggers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/interfaces") {
+			http.Error(w, `{"errors": {"detail": "Internal server error"}}`, http.StatusInternalServerError)
+			return
+		}
+		astarteAPIMock(w, req)
+	}))
+	defer server.Close()
+	client, err := NewClient(server.URL, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken(testTokenValue)
+	popTestWriteRequests()
+
+	// The replaced Trigger is deleted only when it is installed again, after the failing Interface
+	files := testRealmFiles()
+	files["interfaces/org.example.Status.json"] = `{"interface_name": "org.example.Status", "version_major": 1,
+		"version_minor": 0, "type": "properties", "ownership": "device", "mappings": [{"endpoint": "/online", "type": "boolean"}]}`
+	files["triggers/test-trigger.json"] = strings.Replace(testTriggers["test-trigger"], `"value_match_operator": ">"`,
+		`"value_match_operator": ">="`, 1)
+	dir := writeTestRealmDir(t, files)
+	defer os.RemoveAll(dir)
+	desired, err := LoadRealmDesiredState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := client.RealmManagement.ReconcileRealm(testRealmName, desired, RealmReconciliationOptions{})
+	if err == nil {
+		t.Fatal("failing reconciliation succeeded")
+	}
+	for _, request := range popTestWriteRequests() {
+		if request.Method == http.MethodDelete {
+			t.Errorf("unexpected request %+v", request)
+		}
+	}
+	for _, action := range report.Actions {
+		if action.Deleted {
+			t.Errorf("%s was deleted", action)
+		}
+	}
+}
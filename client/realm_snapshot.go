@@ -0,0 +1,596 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// RealmSnapshotFormatVersion is the version of the snapshot layout written by this library
+	RealmSnapshotFormatVersion = 1
+
+	realmSnapshotManifestFile = "snapshot.json"
+	realmSnapshotDevicesFile  = "devices.json"
+)
+
+var (
+	// ErrRealmRestoreConflict is returned by RestoreRealm with FailOnRestoreConflicts when the snapshot conflicts with
+	// the Realm. Nothing is restored: the Conflicts of the report list them.
+	ErrRealmRestoreConflict = errors.New("the snapshot conflicts with the realm")
+)
+
+// RealmSnapshot is a copy of everything configurable in a Realm: its Interfaces, Triggers and Delivery Policies, and
+// the aliases, attributes, credentials inhibition and group memberships of its Devices. Device data is not included.
+//
+// On disk, a snapshot is a directory (or a tar.gz archive of it) with a snapshot.json manifest, a devices.json
+// manifest as read by ParseDeviceManifest, and the interfaces, triggers and policies directories read by
+// LoadRealmDesiredState, so that it can be reconciled with ReconcileRealm too.
+type RealmSnapshot struct {
+	FormatVersion int       `json:"format_version"`
+	Realm         string    `json:"realm"`
+	CreatedAt     time.Time `json:"created_at"`
+
+	Configuration RealmDesiredState     `json:"-"`
+	Devices       []DeviceManifestEntry `json:"-"`
+}
+
+// SnapshotRealm takes a snapshot of realm, listing its Devices pageSize at a time. Taking a snapshot requires an
+// AppEngineService.
+func (s *RealmManagementService) SnapshotRealm(realm string, pageSize int) (RealmSnapshot, error) {
+	if s.client.AppEngine == nil {
+		return RealmSnapshot{}, errors.New("taking a realm snapshot requires an AppEngine service")
+	}
+	snapshot := RealmSnapshot{FormatVersion: RealmSnapshotFormatVersion, Realm: realm, CreatedAt: time.Now().UTC()}
+
+	current, err := s.getRealmState(realm, false)
+	if err != nil {
+		return RealmSnapshot{}, err
+	}
+	for _, iface := range current.interfaces {
+		snapshot.Configuration.Interfaces = append(snapshot.Configuration.Interfaces, iface)
+	}
+	sort.Slice(snapshot.Configuration.Interfaces, func(i, j int) bool {
+		a, b := snapshot.Configuration.Interfaces[i], snapshot.Configuration.Interfaces[j]
+		return a.Name < b.Name || (a.Name == b.Name && a.MajorVersion < b.MajorVersion)
+	})
+	for _, trigger := range current.triggers {
+		snapshot.Configuration.Triggers = append(snapshot.Configuration.Triggers, trigger)
+	}
+	sort.Slice(snapshot.Configuration.Triggers, func(i, j int) bool {
+		return snapshot.Configuration.Triggers[i].Name < snapshot.Configuration.Triggers[j].Name
+	})
+	for _, policy := range current.policies {
+		snapshot.Configuration.Policies = append(snapshot.Configuration.Policies, policy)
+	}
+	sort.Slice(snapshot.Configuration.Policies, func(i, j int) bool {
+		return snapshot.Configuration.Policies[i].Name < snapshot.Configuration.Policies[j].Name
+	})
+
+	snapshot.Devices, err = s.client.AppEngine.snapshotDevices(realm, pageSize)
+	if err != nil {
+		return RealmSnapshot{}, err
+	}
+	return snapshot, nil
+}
+
+func (s *AppEngineService) snapshotDevices(realm string, pageSize int) ([]DeviceManifestEntry, error) {
+	paginator, err := s.GetDeviceListPaginator(realm, pageSize, DeviceDetailsFormat)
+	if err != nil {
+		return nil, err
+	}
+	devices := map[string]*DeviceManifestEntry{}
+	for paginator.HasNextPage() {
+		page := []DeviceDetails{}
+		if err := paginator.GetNextPage(&page); err != nil {
+			return nil, err
+		}
+		for _, device := range page {
+			inhibited := device.CredentialsInhibited
+			devices[device.DeviceID] = &DeviceManifestEntry{DeviceID: device.DeviceID, Aliases: device.Aliases,
+				Attributes: device.Attributes, CredentialsInhibited: &inhibited}
+		}
+	}
+
+	// Device details do not include groups in all Astarte versions
+	groups, err := s.ListGroups(realm)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(groups)
+	for _, group := range groups {
+		members, err := s.ListGroupDevices(realm, group)
+		if err != nil {
+			return nil, err
+		}
+		for _, deviceID := range members {
+			if devices[deviceID] == nil {
+				devices[deviceID] = &DeviceManifestEntry{DeviceID: deviceID}
+			}
+			devices[deviceID].Groups = append(devices[deviceID].Groups, group)
+		}
+	}
+
+	entries := []DeviceManifestEntry{}
+	for _, entry := range devices {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].DeviceID < entries[j].DeviceID })
+	return entries, nil
+}
+
+// files returns the content of each file of the snapshot, indexed by its slash-separated path
+func (r RealmSnapshot) files() (map[string][]byte, error) {
+	files := map[string][]byte{}
+	add := func(name string, v interface{}) error {
+		b, err := json.MarshalIndent(v, "", "  ")
+		files[name] = append(b, '\n')
+		return err
+	}
+
+	if err := add(realmSnapshotManifestFile, r); err != nil {
+		return nil, err
+	}
+	for _, iface := range r.Configuration.Interfaces {
+		name := fmt.Sprintf("%s_v%d.json", url.PathEscape(iface.Name), iface.MajorVersion)
+		if err := add(path.Join(realmInterfacesDir, name), iface); err != nil {
+			return nil, err
+		}
+	}
+	for _, trigger := range r.Configuration.Triggers {
+		if err := add(path.Join(realmTriggersDir, url.PathEscape(trigger.Name)+".json"), trigger); err != nil {
+			return nil, err
+		}
+	}
+	for _, policy := range r.Configuration.Policies {
+		if err := add(path.Join(realmPoliciesDir, url.PathEscape(policy.Name)+".json"), policy); err != nil {
+			return nil, err
+		}
+	}
+	devices := r.Devices
+	if devices == nil {
+		devices = []DeviceManifestEntry{}
+	}
+	if err := add(realmSnapshotDevicesFile, devices); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// WriteDir writes the snapshot to dir, which must be empty or not exist.
+func (r RealmSnapshot) WriteDir(dir string) error {
+	if entries, err := ioutil.ReadDir(dir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("%s is not empty", dir)
+	}
+	files, err := r.files()
+	if err != nil {
+		return err
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(file, content, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTarGz writes the snapshot to w as a gzip-compressed tar archive.
+func (r RealmSnapshot) WriteTarGz(w io.Writer) error {
+	files, err := r.files()
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	gzipWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, name := range names {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), ModTime: r.CreatedAt}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tarWriter.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
+
+// LoadRealmSnapshot reads a snapshot written by WriteDir or WriteTarGz. snapshotPath is either the directory or the
+// archive.
+func LoadRealmSnapshot(snapshotPath string) (RealmSnapshot, error) {
+	info, err := os.Stat(snapshotPath)
+	if err != nil {
+		return RealmSnapshot{}, err
+	}
+	if info.IsDir() {
+		return loadRealmSnapshotDir(snapshotPath)
+	}
+
+	dir, err := ioutil.TempDir("", "astarte-realm-snapshot")
+	if err != nil {
+		return RealmSnapshot{}, err
+	}
+	defer os.RemoveAll(dir)
+	if err := extractTarGz(snapshotPath, dir); err != nil {
+		return RealmSnapshot{}, err
+	}
+	return loadRealmSnapshotDir(dir)
+}
+
+func loadRealmSnapshotDir(dir string) (RealmSnapshot, error) {
+	snapshot := RealmSnapshot{}
+	b, err := ioutil.ReadFile(filepath.Join(dir, realmSnapshotManifestFile))
+	if err != nil {
+		return RealmSnapshot{}, err
+	}
+	if err := json.Unmarshal(b, &snapshot); err != nil {
+		return RealmSnapshot{}, err
+	}
+	if snapshot.FormatVersion < 1 || snapshot.FormatVersion > RealmSnapshotFormatVersion {
+		return RealmSnapshot{}, fmt.Errorf("unsupported snapshot format version %d", snapshot.FormatVersion)
+	}
+
+	if snapshot.Configuration, err = LoadRealmDesiredState(dir); err != nil {
+		return RealmSnapshot{}, err
+	}
+	devicesFile, err := os.Open(filepath.Join(dir, realmSnapshotDevicesFile))
+	if err != nil {
+		return RealmSnapshot{}, err
+	}
+	defer devicesFile.Close()
+	snapshot.Devices, err = ParseDeviceManifest(devicesFile, JSONDeviceManifestFormat)
+	return snapshot, err
+}
+
+func extractTarGz(archive, dir string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gzipReader, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("invalid file %s in snapshot", header.Name)
+		}
+
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+		out, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, tarReader)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// RestoreConflictPolicy is what RestoreRealm does with objects which exist in the Realm with a different content
+// than in the snapshot.
+type RestoreConflictPolicy int
+
+const (
+	// SkipRestoreConflicts keeps the Realm's version of conflicting objects
+	SkipRestoreConflicts RestoreConflictPolicy = iota
+	// OverwriteRestoreConflicts replaces conflicting Triggers and Delivery Policies, and sets conflicting aliases,
+	// attributes and credentials inhibition. Interfaces cannot be overwritten: conflicting Interfaces fail the
+	// restore with ErrRealmReconciliationRejected.
+	OverwriteRestoreConflicts
+	// FailOnRestoreConflicts restores nothing if there is any conflict, and returns ErrRealmRestoreConflict
+	FailOnRestoreConflicts
+)
+
+func (p RestoreConflictPolicy) String() string {
+	switch p {
+	case SkipRestoreConflicts:
+		return "skip"
+	case OverwriteRestoreConflicts:
+		return "overwrite"
+	case FailOnRestoreConflicts:
+		return "fail"
+	}
+	return fmt.Sprintf("RestoreConflictPolicy(%d)", int(p))
+}
+
+// RealmRestoreOptions configures RestoreRealm
+type RealmRestoreOptions struct {
+	Conflicts RestoreConflictPolicy
+	// RegisterMissingDevices registers the Devices of the snapshot which are not registered in the Realm. They get
+	// new Credentials Secrets, returned in the report. Otherwise, they are not restored.
+	RegisterMissingDevices bool
+	// Concurrency is the maximum number of Devices restored concurrently. If <= 0, a default concurrency is used.
+	Concurrency int
+	// DryRun only computes what needs to be restored, without applying it.
+	DryRun bool
+}
+
+// RealmRestoreReport is the plan, or the outcome if the restore is not a dry run, of RestoreRealm.
+type RealmRestoreReport struct {
+	Configuration RealmReconciliationReport
+	// Conflicts describes the objects of the snapshot which differ from the Realm
+	Conflicts []string
+	// RegisteredDevices maps the Devices registered by the restore to their new Credentials Secret, which is empty
+	// in dry runs
+	RegisteredDevices map[string]string
+	// MissingDevices are the Devices of the snapshot which are not registered in the Realm, and were not restored
+	MissingDevices []string
+	Devices        DeviceManifestReport
+	DryRun         bool
+}
+
+// RestoreRealm replays snapshot into realm, which can be either empty or existing. Delivery Policies, Interfaces and
+// Triggers are restored first, in dependency order, then Devices are added to their groups, which are created if
+// needed, and get their aliases, attributes and credentials inhibition back. Nothing is removed from the Realm.
+// Conflicts are handled according to options.Conflicts. Restoring requires an AppEngineService, and a
+// PairingService to register missing Devices.
+func (s *RealmManagementService) RestoreRealm(realm string, snapshot RealmSnapshot, options RealmRestoreOptions) (RealmRestoreReport, error) {
+	appEngine := s.client.AppEngine
+	if appEngine == nil {
+		return RealmRestoreReport{}, errors.New("restoring a realm requires an AppEngine service")
+	}
+	if options.RegisterMissingDevices && s.client.Pairing == nil {
+		return RealmRestoreReport{}, errors.New("registering devices requires a Pairing service")
+	}
+	if options.Concurrency <= 0 {
+		options.Concurrency = defaultGroupConcurrency
+	}
+
+	configuration, err := s.planRealmReconciliation(realm, snapshot.Configuration, RealmReconciliationOptions{DryRun: options.DryRun})
+	if err != nil {
+		return RealmRestoreReport{}, err
+	}
+	report := RealmRestoreReport{Conflicts: []string{}, RegisteredDevices: map[string]string{}, MissingDevices: []string{},
+		DryRun: options.DryRun}
+	rejected := report.triageConfiguration(configuration, options.Conflicts)
+
+	entries, err := appEngine.planDeviceRestore(realm, snapshot.Devices, options, &report)
+	if err != nil {
+		return report, err
+	}
+	switch {
+	case options.Conflicts == FailOnRestoreConflicts && len(report.Conflicts) > 0:
+		return report, ErrRealmRestoreConflict
+	case options.Conflicts == OverwriteRestoreConflicts && rejected:
+		return report, ErrRealmReconciliationRejected
+	}
+
+	return report, s.applyRealmRestore(realm, entries, options, &report)
+}
+
+// triageConfiguration sets the Configuration of the report to the actions of configuration which must be applied,
+// recording replaced and rejected objects as conflicts. It returns whether any action was rejected.
+func (r *RealmRestoreReport) triageConfiguration(configuration RealmReconciliationReport, policy RestoreConflictPolicy) bool {
+	actions := []RealmAction{}
+	rejected := false
+	for _, action := range configuration.Actions {
+		switch action.Type {
+		case RejectRealmAction:
+			rejected = true
+		case ReplaceRealmAction:
+			if policy == OverwriteRestoreConflicts {
+				actions = append(actions, action)
+			}
+		default:
+			actions = append(actions, action)
+			continue
+		}
+		r.Conflicts = append(r.Conflicts, action.String())
+	}
+	configuration.Actions = actions
+	r.Configuration = configuration
+	return rejected
+}
+
+// applyRealmRestore applies the configuration of report, registers missing Devices and restores entries. In dry
+// runs, only entries of registered Devices are planned.
+func (s *RealmManagementService) applyRealmRestore(realm string, entries []DeviceManifestEntry, options RealmRestoreOptions,
+	report *RealmRestoreReport) error {
+	var err error
+	if !options.DryRun {
+		if err := s.applyRealmReconciliation(&report.Configuration); err != nil {
+			return err
+		}
+		for deviceID := range report.RegisteredDevices {
+			if report.RegisteredDevices[deviceID], err = s.client.Pairing.RegisterDevice(realm, deviceID); err != nil {
+				return fmt.Errorf("registering %s: %w", deviceID, err)
+			}
+		}
+	} else {
+		// Devices to be registered cannot be planned
+		plannable := []DeviceManifestEntry{}
+		for _, entry := range entries {
+			if _, ok := report.RegisteredDevices[entry.DeviceID]; !ok {
+				plannable = append(plannable, entry)
+			}
+		}
+		entries = plannable
+	}
+	report.Devices, err = s.client.AppEngine.ApplyDeviceManifest(realm, entries,
+		DeviceManifestOptions{Concurrency: options.Concurrency, DryRun: options.DryRun})
+	return err
+}
+
+// planDeviceRestore returns the manifest entries restoring the Devices of the snapshot, recording missing Devices
+// and conflicts in report. With SkipRestoreConflicts, conflicting aliases, attributes and credentials inhibition
+// are left out of the entries. Aliases owned by other Devices are always left out, as they cannot be set.
+func (s *AppEngineService) planDeviceRestore(realm string, devices []DeviceManifestEntry, options RealmRestoreOptions,
+	report *RealmRestoreReport) ([]DeviceManifestEntry, error) {
+	var lock sync.Mutex
+	entries := map[string]DeviceManifestEntry{}
+	conflicts := []string{}
+	deviceIDs := []string{}
+	snapshotEntries := map[string]DeviceManifestEntry{}
+	for _, entry := range devices {
+		deviceIDs = append(deviceIDs, entry.DeviceID)
+		snapshotEntries[entry.DeviceID] = entry
+	}
+
+	err := forEachDevice(deviceIDs, options.Concurrency, func(deviceID string) error {
+		plan, err := s.planDeviceEntryRestore(realm, snapshotEntries[deviceID], options)
+		if err != nil {
+			return err
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		switch {
+		case plan.missing:
+			report.MissingDevices = append(report.MissingDevices, deviceID)
+			return nil
+		case plan.register:
+			report.RegisteredDevices[deviceID] = ""
+		}
+		conflicts = append(conflicts, plan.conflicts...)
+		entries[deviceID] = plan.entry
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(conflicts)
+	report.Conflicts = append(report.Conflicts, conflicts...)
+	sort.Strings(report.MissingDevices)
+	ret := []DeviceManifestEntry{}
+	for _, deviceID := range deviceIDs {
+		if entry, ok := entries[deviceID]; ok {
+			ret = append(ret, entry)
+		}
+	}
+	return ret, nil
+}
+
+// deviceRestorePlan is how a Device of a snapshot is restored
+type deviceRestorePlan struct {
+	entry     DeviceManifestEntry
+	conflicts []string
+	// missing is set if the Device is not registered, and is not going to be
+	missing bool
+	// register is set if the Device is not registered, and is going to be
+	register bool
+}
+
+// planDeviceEntryRestore compares entry with the Device in the Realm, and leaves out of it what must not be restored
+func (s *AppEngineService) planDeviceEntryRestore(realm string, entry DeviceManifestEntry, options RealmRestoreOptions) (deviceRestorePlan, error) {
+	plan := deviceRestorePlan{entry: entry}
+	details, err := s.GetDevice(realm, entry.DeviceID, AstarteDeviceID)
+	switch {
+	case isNotFound(err) && options.RegisterMissingDevices:
+		plan.register = true
+	case isNotFound(err):
+		plan.missing = true
+		return plan, nil
+	case err != nil:
+		return plan, err
+	}
+
+	if plan.entry.Aliases, err = s.planDeviceAliasesRestore(realm, entry, details, options, &plan.conflicts); err != nil {
+		return plan, err
+	}
+	plan.entry.Attributes = map[string]string{}
+	for key, value := range entry.Attributes {
+		if current, ok := details.Attributes[key]; ok && current != value {
+			plan.conflicts = append(plan.conflicts, fmt.Sprintf("device %s attribute %s", entry.DeviceID, key))
+			if options.Conflicts == SkipRestoreConflicts {
+				continue
+			}
+		}
+		plan.entry.Attributes[key] = value
+	}
+	if entry.CredentialsInhibited != nil && *entry.CredentialsInhibited != details.CredentialsInhibited {
+		plan.conflicts = append(plan.conflicts, fmt.Sprintf("device %s credentials inhibition", entry.DeviceID))
+		if options.Conflicts == SkipRestoreConflicts {
+			plan.entry.CredentialsInhibited = nil
+		}
+	}
+	return plan, nil
+}
+
+// planDeviceAliasesRestore returns the aliases of entry which must be restored. Each alias which the Device does not
+// have already is resolved, to find out whether it belongs to another Device.
+func (s *AppEngineService) planDeviceAliasesRestore(realm string, entry DeviceManifestEntry, details DeviceDetails,
+	options RealmRestoreOptions, conflicts *[]string) (map[string]string, error) {
+	aliases := map[string]string{}
+	for tag, alias := range entry.Aliases {
+		current, ok := details.Aliases[tag]
+		if ok && current == alias {
+			aliases[tag] = alias
+			continue
+		}
+		owner, err := s.GetDeviceIDFromDeviceIdentifier(realm, alias, AstarteDeviceAlias)
+		switch {
+		case err != nil && !isNotFound(err):
+			return nil, err
+		case err == nil && owner != entry.DeviceID:
+			*conflicts = append(*conflicts, fmt.Sprintf("device %s alias %s (owned by device %s)", entry.DeviceID, tag, owner))
+			continue
+		case ok:
+			*conflicts = append(*conflicts, fmt.Sprintf("device %s alias %s", entry.DeviceID, tag))
+			if options.Conflicts == SkipRestoreConflicts {
+				continue
+			}
+		}
+		aliases[tag] = alias
+	}
+	return aliases, nil
+}
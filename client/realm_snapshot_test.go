@@ -0,0 +1,160 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRealmSnapshot(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	snapshot, err := client.RealmManagement.SnapshotRealm(testRealmName, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Configuration.Interfaces) != len(testInterfaces) || len(snapshot.Configuration.Triggers) != 1 ||
+		len(snapshot.Configuration.Policies) != 1 || len(snapshot.Devices) != len(testDevices) {
+		t.Fatalf("unexpected snapshot %+v", snapshot)
+	}
+	if device := snapshot.Devices[0]; device.DeviceID != testDevices[0] || device.Aliases["name"] != "sensor-a" ||
+		!reflect.DeepEqual(device.Groups, []string{testGroupName}) || *device.CredentialsInhibited {
+		t.Errorf("unexpected device %+v", device)
+	}
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	snapshotDir := filepath.Join(dir, "snapshot")
+	if err := snapshot.WriteDir(snapshotDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := snapshot.WriteDir(snapshotDir); err == nil {
+		t.Error("snapshot overwrote a directory")
+	}
+	loaded, err := LoadRealmSnapshot(snapshotDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, snapshot) {
+		t.Errorf("snapshot changed after writing it to a directory: %+v", loaded)
+	}
+
+	archive := filepath.Join(dir, "snapshot.tar.gz")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := snapshot.WriteTarGz(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if loaded, err = LoadRealmSnapshot(archive); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, snapshot) {
+		t.Errorf("snapshot changed after writing it to an archive: %+v", loaded)
+	}
+
+	// Snapshots are desired states too
+	desired, err := LoadRealmDesiredState(snapshotDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report, err := client.RealmManagement.ReconcileRealm(testRealmName, desired, RealmReconciliationOptions{Prune: true}); err != nil ||
+		len(report.Actions) != 0 {
+		t.Errorf("unexpected reconciliation %v %v", report, err)
+	}
+}
+
+func TestRestoreRealm(t *testing.T) {
+	client, server := getTestContext(t)
+	defer server.Close()
+
+	snapshot, err := client.RealmManagement.SnapshotRealm(testRealmName, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot.Configuration.Triggers[0].Policy = "test-policy"
+	inhibited := false
+	missingDevice := "QwtRYCkWTmiuoQCtYjdA1g"
+	snapshot.Devices = []DeviceManifestEntry{
+		{DeviceID: testDevices[0], Aliases: map[string]string{"name": "sensor-z", "other": "sensor-b"},
+			Attributes: map[string]string{"site": "north", "floor": "1"}, Groups: []string{testGroupName}, CredentialsInhibited: &inhibited},
+		{DeviceID: missingDevice, Aliases: map[string]string{"name": "sensor-q"}},
+	}
+	expectedConflicts := []string{"replace trigger test-trigger", fmt.Sprintf("device %s alias name", testDevices[0]),
+		fmt.Sprintf("device %s alias other (owned by device %s)", testDevices[0], testDevices[1])}
+	devicePath := fmt.Sprintf("/appengine/v1/%s/devices/%s", testRealmName, testDevices[0])
+
+	report, err := client.RealmManagement.RestoreRealm(testRealmName, snapshot, RealmRestoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.Conflicts, expectedConflicts) || !reflect.DeepEqual(report.MissingDevices, []string{missingDevice}) {
+		t.Errorf("unexpected report %+v", report)
+	}
+	// Only the new attribute is restored
+	requests := popTestWriteRequests()
+	if paths := testWriteRequestPaths(requests); !reflect.DeepEqual(paths, []string{devicePath}) {
+		t.Fatalf("unexpected requests %v", paths)
+	}
+	if data := requests[0].Data; !reflect.DeepEqual(data, map[string]interface{}{"attributes": map[string]interface{}{"floor": "1"}}) {
+		t.Errorf("unexpected request %v", data)
+	}
+
+	if _, err := client.RealmManagement.RestoreRealm(testRealmName, snapshot, RealmRestoreOptions{Conflicts: FailOnRestoreConflicts}); !errors.Is(err, ErrRealmRestoreConflict) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if requests := popTestWriteRequests(); len(requests) != 0 {
+		t.Errorf("conflicting restore sent requests %v", requests)
+	}
+
+	report, err = client.RealmManagement.RestoreRealm(testRealmName, snapshot,
+		RealmRestoreOptions{Conflicts: OverwriteRestoreConflicts, RegisterMissingDevices: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := report.RegisteredDevices[missingDevice]; !ok || len(report.MissingDevices) != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	expectedPaths := []string{
+		fmt.Sprintf("/realmmanagement/v1/%s/triggers/test-trigger", testRealmName),
+		fmt.Sprintf("/realmmanagement/v1/%s/triggers", testRealmName),
+		fmt.Sprintf("/pairing/v1/%s/agent/devices", testRealmName),
+		devicePath,
+		devicePath,
+	}
+	requests = popTestWriteRequests()
+	if paths := testWriteRequestPaths(requests); !reflect.DeepEqual(paths, expectedPaths) {
+		t.Errorf("unexpected requests %v", paths)
+	}
+	// The alias of another device is never set
+	for _, request := range requests {
+		if strings.Contains(fmt.Sprint(request.Data), "sensor-b") {
+			t.Errorf("unexpected request %v", request)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interfaces
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file contains helpers to encode and decode aggregate messages from and to Go structs. Struct fields
+// are mapped to endpoints by means of the `astarte` tag, which must contain the last token of the endpoint, e.g.:
+//
+//	type Geolocation struct {
+//		Latitude  float64   `astarte:"latitude"`
+//		Longitude float64   `astarte:"longitude"`
+//		Fix       time.Time `astarte:"fixTime"`
+//	}
+//
+// Fields without an `astarte` tag, or tagged with `astarte:"-"`, are ignored.
+
+const astarteStructTag = "astarte"
+
+// AggregateFieldsError is returned when a struct does not match the endpoints of an aggregate interface.
+// Missing contains the endpoints which have no matching field, Extra contains the fields (or values) which
+// do not match any endpoint.
+type AggregateFieldsError struct {
+	Missing []string
+	Extra   []string
+}
+
+func (e *AggregateFieldsError) Error() string {
+	messages := []string{}
+	if len(e.Missing) > 0 {
+		messages = append(messages, fmt.Sprintf("missing fields: %s", strings.Join(e.Missing, ", ")))
+	}
+	if len(e.Extra) > 0 {
+		messages = append(messages, fmt.Sprintf("extra fields: %s", strings.Join(e.Extra, ", ")))
+	}
+	return fmt.Sprintf("aggregate does not match interface: %s", strings.Join(messages, "; "))
+}
+
+// MarshalAggregateMessage encodes v, which must be a struct (or a pointer to a struct) with `astarte` tags, into an
+// aggregate message for interfacePath. The struct must have exactly one field for each endpoint of the aggregate,
+// otherwise an *AggregateFieldsError is returned. Pointer fields are dereferenced, and nil ones are left out of
+// the message. The resulting message is validated with ValidateAggregateMessage.
+func MarshalAggregateMessage(astarteInterface AstarteInterface, interfacePath string, v interface{}) (map[string]interface{}, error) {
+	structValue, err := structValueOf(v)
+	if err != nil {
+		return nil, err
+	}
+	leaves, err := aggregateEndpointLeaves(astarteInterface, interfacePath)
+	if err != nil {
+		return nil, err
+	}
+	fields := taggedFields(structValue)
+	if err := checkAggregateFields(leaves, fields); err != nil {
+		return nil, err
+	}
+
+	values := map[string]interface{}{}
+	for leaf, field := range fields {
+		if field.Kind() == reflect.Ptr {
+			if field.IsNil() {
+				continue
+			}
+			field = field.Elem()
+		}
+		values[leaf] = field.Interface()
+	}
+	if err := ValidateAggregateMessage(astarteInterface, interfacePath, values); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// UnmarshalAggregateMessage decodes an aggregate message received on interfacePath into v, which must be a pointer
+// to a struct with `astarte` tags. Values are converted from their wire representation (e.g. JSON numbers, base64
+// strings, RFC3339 timestamps) to the type of the fields according to the mapping type. The struct must have exactly
+// one field for each endpoint of the aggregate, and values must not contain unknown endpoints, otherwise an
+// *AggregateFieldsError is returned. Fields with no value in values are left untouched.
+func UnmarshalAggregateMessage(astarteInterface AstarteInterface, interfacePath string, values map[string]interface{}, v interface{}) error {
+	if reflect.ValueOf(v).Kind() != reflect.Ptr {
+		return errors.New("v must be a pointer to a struct")
+	}
+	structValue, err := structValueOf(v)
+	if err != nil {
+		return err
+	}
+	leaves, err := aggregateEndpointLeaves(astarteInterface, interfacePath)
+	if err != nil {
+		return err
+	}
+	fields := taggedFields(structValue)
+	if err := checkAggregateFields(leaves, fields); err != nil {
+		return err
+	}
+	extraValues := []string{}
+	for k := range values {
+		if _, ok := leaves[k]; !ok {
+			extraValues = append(extraValues, k)
+		}
+	}
+	if len(extraValues) > 0 {
+		sort.Strings(extraValues)
+		return &AggregateFieldsError{Extra: extraValues}
+	}
+
+	for leaf, field := range fields {
+		raw, ok := values[leaf]
+		if !ok {
+			continue
+		}
+		if err := decodeValue(leaves[leaf].Type, raw, field); err != nil {
+			return fmt.Errorf("could not decode %s: %w", leaf, err)
+		}
+	}
+
+	return nil
+}
+
+func structValueOf(v interface{}) (reflect.Value, error) {
+	value := reflect.ValueOf(v)
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return reflect.Value{}, errors.New("v must not be a nil pointer")
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return reflect.Value{}, errors.New("v must be a struct or a pointer to a struct")
+	}
+	return value, nil
+}
+
+// taggedFields returns the fields of structValue with an `astarte` tag, keyed by the tag name
+func taggedFields(structValue reflect.Value) map[string]reflect.Value {
+	fields := map[string]reflect.Value{}
+	structType := structValue.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		tag := structType.Field(i).Tag.Get(astarteStructTag)
+		if tag == "" || tag == "-" || structType.Field(i).PkgPath != "" {
+			// Untagged, ignored or unexported
+			continue
+		}
+		fields[tag] = structValue.Field(i)
+	}
+	return fields
+}
+
+// aggregateEndpointLeaves returns all mappings which can be sent or received on interfacePath, keyed by the
+// last token of their endpoint
+func aggregateEndpointLeaves(astarteInterface AstarteInterface, interfacePath string) (map[string]AstarteInterfaceMapping, error) {
+	if astarteInterface.Aggregation != ObjectAggregation {
+		return nil, fmt.Errorf("interface %s is not an object aggregate", astarteInterface.Name)
+	}
+	leaves := map[string]AstarteInterfaceMapping{}
+	for _, m := range astarteInterface.Mappings {
+		leaf := path.Base(m.Endpoint)
+		mapping, err := InterfaceMappingFromPath(astarteInterface, path.Join(interfacePath, leaf))
+		if err != nil || mapping.Endpoint != m.Endpoint {
+			continue
+		}
+		leaves[leaf] = m
+	}
+	if len(leaves) == 0 {
+		return nil, fmt.Errorf("path %s does not match any aggregate on interface %s", interfacePath, astarteInterface.Name)
+	}
+	return leaves, nil
+}
+
+func checkAggregateFields(leaves map[string]AstarteInterfaceMapping, fields map[string]reflect.Value) error {
+	fieldsError := &AggregateFieldsError{}
+	for leaf := range leaves {
+		if _, ok := fields[leaf]; !ok {
+			fieldsError.Missing = append(fieldsError.Missing, leaf)
+		}
+	}
+	for field := range fields {
+		if _, ok := leaves[field]; !ok {
+			fieldsError.Extra = append(fieldsError.Extra, field)
+		}
+	}
+	if len(fieldsError.Missing) == 0 && len(fieldsError.Extra) == 0 {
+		return nil
+	}
+	sort.Strings(fieldsError.Missing)
+	sort.Strings(fieldsError.Extra)
+	return fieldsError
+}
+
+func arrayElementType(mappingType AstarteMappingType) (AstarteMappingType, bool) {
+	switch mappingType {
+	case DoubleArray:
+		return Double, true
+	case IntegerArray:
+		return Integer, true
+	case BooleanArray:
+		return Boolean, true
+	case LongIntegerArray:
+		return LongInteger, true
+	case StringArray:
+		return String, true
+	case BinaryBlobArray:
+		return BinaryBlob, true
+	case DateTimeArray:
+		return DateTime, true
+	}
+	return mappingType, false
+}
+
+// decodeValue sets target to raw, converting it according to mappingType
+func decodeValue(mappingType AstarteMappingType, raw interface{}, target reflect.Value) error {
+	if raw == nil {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+	if target.Kind() == reflect.Ptr {
+		if target.IsNil() {
+			target.Set(reflect.New(target.Type().Elem()))
+		}
+		return decodeValue(mappingType, raw, target.Elem())
+	}
+	if elementType, isArray := arrayElementType(mappingType); isArray {
+		return decodeArray(mappingType, elementType, raw, target)
+	}
+
+	// Always convert according to the mapping type, so that e.g. an interface{} target gets a time.Time
+	// rather than a string for a datetime
+	converted, err := convertScalar(mappingType, raw)
+	if err != nil {
+		return err
+	}
+	return setScalar(converted, target)
+}
+
+// decodeArray sets target to the elements of raw, converting each of them according to elementType. An
+// interface{} target gets a []interface{}.
+func decodeArray(mappingType, elementType AstarteMappingType, raw interface{}, target reflect.Value) error {
+	rawValue := reflect.ValueOf(raw)
+	sliceType := target.Type()
+	if target.Kind() == reflect.Interface {
+		sliceType = reflect.TypeOf([]interface{}{})
+	}
+	if rawValue.Kind() != reflect.Slice || sliceType.Kind() != reflect.Slice || !sliceType.AssignableTo(target.Type()) {
+		return fmt.Errorf("cannot decode %T into %s for %s mapping", raw, target.Type(), mappingType)
+	}
+	slice := reflect.MakeSlice(sliceType, rawValue.Len(), rawValue.Len())
+	for i := 0; i < rawValue.Len(); i++ {
+		if err := decodeValue(elementType, rawValue.Index(i).Interface(), slice.Index(i)); err != nil {
+			return err
+		}
+	}
+	target.Set(slice)
+	return nil
+}
+
+// convertScalar converts raw to the canonical Go type for mappingType: float64, int64, bool, string, []byte or time.Time
+func convertScalar(mappingType AstarteMappingType, raw interface{}) (interface{}, error) {
+	switch mappingType {
+	case Double:
+		return toFloat64(raw)
+	case Integer, LongInteger:
+		return toInt64(raw)
+	case Boolean:
+		if b, ok := raw.(bool); ok {
+			return b, nil
+		}
+	case String:
+		if s, ok := raw.(string); ok {
+			return s, nil
+		}
+	case BinaryBlob:
+		switch b := raw.(type) {
+		case []byte:
+			return b, nil
+		case string:
+			return base64.StdEncoding.DecodeString(b)
+		}
+	case DateTime:
+		return toTime(raw)
+	}
+	return nil, fmt.Errorf("cannot convert %T to %s", raw, mappingType)
+}
+
+func toTime(raw interface{}) (time.Time, error) {
+	switch t := raw.(type) {
+	case time.Time:
+		return t, nil
+	case string:
+		return time.Parse(time.RFC3339Nano, t)
+	case float64:
+		// Milliseconds since the epoch
+		return time.Unix(0, int64(t)*int64(time.Millisecond)).UTC(), nil
+	case json.Number:
+		millis, err := t.Int64()
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(0, millis*int64(time.Millisecond)).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("cannot convert %T to %s", raw, DateTime)
+}
+
+func toFloat64(raw interface{}) (float64, error) {
+	switch n := raw.(type) {
+	case json.Number:
+		return n.Float64()
+	case string:
+		return strconv.ParseFloat(n, 64)
+	}
+	value := reflect.ValueOf(raw)
+	switch value.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), nil
+	}
+	return 0, fmt.Errorf("cannot convert %T to a number", raw)
+}
+
+func toInt64(raw interface{}) (int64, error) {
+	switch n := raw.(type) {
+	case json.Number:
+		return n.Int64()
+	case string:
+		// Long integers might be encoded as strings to avoid losing precision
+		return strconv.ParseInt(n, 10, 64)
+	}
+	value := reflect.ValueOf(raw)
+	switch value.Kind() {
+	case reflect.Float32, reflect.Float64:
+		f := value.Float()
+		if f != math.Trunc(f) || f > math.MaxInt64 || f < math.MinInt64 {
+			return 0, fmt.Errorf("%v is not an integer", f)
+		}
+		return int64(f), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if value.Uint() > math.MaxInt64 {
+			return 0, fmt.Errorf("%v overflows a long integer", value.Uint())
+		}
+		return int64(value.Uint()), nil
+	}
+	return 0, fmt.Errorf("cannot convert %T to an integer", raw)
+}
+
+func setScalar(converted interface{}, target reflect.Value) error {
+	switch c := converted.(type) {
+	case float64:
+		switch target.Kind() {
+		case reflect.Float32, reflect.Float64:
+			target.SetFloat(c)
+			return nil
+		}
+	case int64:
+		if set, err := setInteger(c, target); set || err != nil {
+			return err
+		}
+	case bool:
+		if target.Kind() == reflect.Bool {
+			target.SetBool(c)
+			return nil
+		}
+	case string:
+		if target.Kind() == reflect.String {
+			target.SetString(c)
+			return nil
+		}
+	}
+
+	convertedValue := reflect.ValueOf(converted)
+	if convertedValue.Type().ConvertibleTo(target.Type()) && target.Kind() != reflect.String {
+		target.Set(convertedValue.Convert(target.Type()))
+		return nil
+	}
+	return fmt.Errorf("cannot decode %T into %s", converted, target.Type())
+}
+
+// setInteger sets target to c if target is a number, and reports whether it did
+func setInteger(c int64, target reflect.Value) (bool, error) {
+	switch target.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if target.OverflowInt(c) {
+			return false, fmt.Errorf("%v overflows %s", c, target.Type())
+		}
+		target.SetInt(c)
+		return true, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if c < 0 || target.OverflowUint(uint64(c)) {
+			return false, fmt.Errorf("%v overflows %s", c, target.Type())
+		}
+		target.SetUint(uint64(c))
+		return true, nil
+	case reflect.Float32, reflect.Float64:
+		target.SetFloat(float64(c))
+		return true, nil
+	}
+	return false, nil
+}
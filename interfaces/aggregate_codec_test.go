@@ -0,0 +1,187 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interfaces
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const codecTestInterface = `
+{
+	"interface_name": "org.astarte-platform.tests.Codec",
+	"version_major": 1,
+	"version_minor": 0,
+	"type": "datastream",
+	"ownership": "server",
+	"aggregation": "object",
+	"mappings": [
+		{"endpoint": "/%{sensor_id}/temperature", "type": "double"},
+		{"endpoint": "/%{sensor_id}/samples", "type": "integer"},
+		{"endpoint": "/%{sensor_id}/label", "type": "string"},
+		{"endpoint": "/%{sensor_id}/raw", "type": "binaryblob"},
+		{"endpoint": "/%{sensor_id}/sampledAt", "type": "datetime"},
+		{"endpoint": "/%{sensor_id}/history", "type": "longintegerarray"}
+	]
+}`
+
+type codecTestSample struct {
+	Temperature float64   `astarte:"temperature"`
+	Samples     int       `astarte:"samples"`
+	Label       string    `astarte:"label"`
+	Raw         []byte    `astarte:"raw"`
+	SampledAt   time.Time `astarte:"sampledAt"`
+	History     []int64   `astarte:"history"`
+	Ignored     string
+}
+
+func TestAggregateCodecRoundTrip(t *testing.T) {
+	i, err := ParseInterfaceFromString(codecTestInterface)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sample := codecTestSample{
+		Temperature: 21.5,
+		Samples:     12,
+		Label:       "kitchen",
+		Raw:         []byte{0xde, 0xad, 0xbe, 0xef},
+		SampledAt:   time.Date(2021, 3, 4, 10, 11, 12, 0, time.UTC),
+		History:     []int64{1, 2, 3},
+		Ignored:     "ignored",
+	}
+	values, err := MarshalAggregateMessage(i, "/sensor1", &sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 6 {
+		t.Errorf("unexpected values: %v", values)
+	}
+
+	// Simulate the wire: normalize and go through JSON
+	encoded, err := json.Marshal(NormalizePayload(values, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodedValues := map[string]interface{}{}
+	if err := json.Unmarshal(encoded, &decodedValues); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := codecTestSample{}
+	if err := UnmarshalAggregateMessage(i, "/sensor1", decodedValues, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	sample.Ignored = ""
+	if !reflect.DeepEqual(sample, decoded) {
+		t.Errorf("expected %v, got %v", sample, decoded)
+	}
+}
+
+func TestAggregateCodecFieldMismatch(t *testing.T) {
+	i, err := ParseInterfaceFromString(codecTestInterface)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	partial := struct {
+		Temperature float64 `astarte:"temperature"`
+		Unknown     string  `astarte:"unknown"`
+	}{}
+	_, err = MarshalAggregateMessage(i, "/sensor1", partial)
+	fieldsError := &AggregateFieldsError{}
+	if !errors.As(err, &fieldsError) {
+		t.Fatalf("expected an AggregateFieldsError, got %v", err)
+	}
+	if !reflect.DeepEqual(fieldsError.Missing, []string{"history", "label", "raw", "sampledAt", "samples"}) {
+		t.Errorf("unexpected missing fields: %v", fieldsError.Missing)
+	}
+	if !reflect.DeepEqual(fieldsError.Extra, []string{"unknown"}) {
+		t.Errorf("unexpected extra fields: %v", fieldsError.Extra)
+	}
+
+	decoded := codecTestSample{}
+	err = UnmarshalAggregateMessage(i, "/sensor1", map[string]interface{}{"temperature": 1.0, "humidity": 2.0}, &decoded)
+	if !errors.As(err, &fieldsError) || !reflect.DeepEqual(fieldsError.Extra, []string{"humidity"}) {
+		t.Errorf("expected humidity to be reported as extra, got %v", err)
+	}
+}
+
+func TestAggregateCodecWrongTypes(t *testing.T) {
+	i, err := ParseInterfaceFromString(codecTestInterface)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := codecTestSample{}
+	if err := UnmarshalAggregateMessage(i, "/sensor1", map[string]interface{}{"samples": 1.5}, &decoded); err == nil {
+		t.Error("a non-integral value was decoded as integer")
+	}
+	if err := UnmarshalAggregateMessage(i, "/sensor1", map[string]interface{}{"label": 1.0}, &decoded); err == nil {
+		t.Error("a number was decoded as string")
+	}
+	if err := UnmarshalAggregateMessage(i, "/sensor1", map[string]interface{}{"label": "test"}, decoded); err == nil {
+		t.Error("decoding into a non-pointer succeeded")
+	}
+	if _, err := MarshalAggregateMessage(i, "/sensor1", map[string]interface{}{}); err == nil {
+		t.Error("a map was marshaled as a struct")
+	}
+}
+
+func TestAggregateCodecPointersAndInterfaces(t *testing.T) {
+	i, err := ParseInterfaceFromString(codecTestInterface)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	temperature := 21.5
+	sample := struct {
+		Temperature *float64   `astarte:"temperature"`
+		Samples     *int       `astarte:"samples"`
+		Label       string     `astarte:"label"`
+		Raw         []byte     `astarte:"raw"`
+		SampledAt   *time.Time `astarte:"sampledAt"`
+		History     []int64    `astarte:"history"`
+	}{Temperature: &temperature, Label: "kitchen"}
+	values, err := MarshalAggregateMessage(i, "/sensor1", sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := values["samples"]; ok || values["temperature"] != 21.5 {
+		t.Errorf("unexpected values: %v", values)
+	}
+
+	// interface{} fields get values converted according to the mapping type
+	decoded := struct {
+		Temperature interface{} `astarte:"temperature"`
+		Samples     interface{} `astarte:"samples"`
+		Label       interface{} `astarte:"label"`
+		Raw         interface{} `astarte:"raw"`
+		SampledAt   interface{} `astarte:"sampledAt"`
+		History     interface{} `astarte:"history"`
+	}{}
+	received := map[string]interface{}{"sampledAt": "2021-03-04T10:11:12Z", "raw": "3q2+7w==", "history": []interface{}{"1", 2.0}}
+	if err := UnmarshalAggregateMessage(i, "/sensor1", received, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.SampledAt != time.Date(2021, 3, 4, 10, 11, 12, 0, time.UTC) ||
+		!reflect.DeepEqual(decoded.Raw, []byte{0xde, 0xad, 0xbe, 0xef}) ||
+		!reflect.DeepEqual(decoded.History, []interface{}{int64(1), int64(2)}) {
+		t.Errorf("unexpected decoded values %+v", decoded)
+	}
+}
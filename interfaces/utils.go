@@ -16,10 +16,13 @@ package interfaces
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"path"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -147,6 +150,45 @@ func InterfaceMappingFromPath(astarteInterface AstarteInterface, interfacePath s
 
 }
 
+// InterfaceMappingAndParametersFromPath works like InterfaceMappingFromPath, but also returns the values of the
+// parameters of the matched mapping, keyed by parameter name. The map is empty for non-parametric mappings.
+func InterfaceMappingAndParametersFromPath(astarteInterface AstarteInterface, interfacePath string) (AstarteInterfaceMapping, map[string]string, error) {
+	if !astarteInterface.IsParametric() {
+		mapping, err := simpleMappingValidation(astarteInterface, interfacePath)
+		return mapping, map[string]string{}, err
+	}
+
+	return parametricMappingMatch(astarteInterface, interfacePath)
+}
+
+// BuildInterfacePath renders a concrete path from a mapping endpoint, replacing each parameter with its value in
+// parameters. It returns an error if a parameter is missing from parameters or if its value is empty or contains
+// '/', '+' or '#', or if parameters contains values for parameters not in the endpoint.
+func BuildInterfacePath(endpoint string, parameters map[string]string) (string, error) {
+	tokens := strings.Split(endpoint, "/")
+	used := 0
+	for i, token := range tokens {
+		name, ok := endpointParameterName(token)
+		if !ok {
+			continue
+		}
+		value, ok := parameters[name]
+		if !ok {
+			return "", fmt.Errorf("missing value for parameter %s of endpoint %s", name, endpoint)
+		}
+		if value == "" || strings.ContainsAny(value, "/+#") {
+			return "", fmt.Errorf("invalid value %q for parameter %s", value, name)
+		}
+		tokens[i] = value
+		used++
+	}
+	if used != len(parameters) {
+		return "", fmt.Errorf("parameters do not match endpoint %s", endpoint)
+	}
+
+	return strings.Join(tokens, "/"), nil
+}
+
 // ValidateInterfacePath validates path against the structure of astarteInterface, and returns a meaningful error
 // the path cannot be resolved.
 func ValidateInterfacePath(astarteInterface AstarteInterface, interfacePath string) error {
@@ -210,6 +252,81 @@ func NormalizePayload(payload interface{}, encodeBytes bool) interface{} {
 	return payload
 }
 
+// CoerceValue converts value to a Go type compatible with mappingType, so that it can be validated and sent. This is
+// useful when dealing with values decoded from JSON (e.g. float64 for all numbers, base64 strings for binary blobs)
+// or parsed from text: in that case, strings are parsed according to mappingType, and arrays can be given as
+// JSON-encoded strings.
+func CoerceValue(mappingType AstarteMappingType, value interface{}) (interface{}, error) {
+	if elementType, isArray := arrayElementType(mappingType); isArray {
+		if s, ok := value.(string); ok {
+			decoded := []interface{}{}
+			// Numbers are kept as json.Number, so that long integers do not lose precision
+			decoder := json.NewDecoder(strings.NewReader(s))
+			decoder.UseNumber()
+			if err := decoder.Decode(&decoded); err != nil {
+				return nil, fmt.Errorf("cannot parse %s as %s: %w", s, mappingType, err)
+			}
+			value = decoded
+		}
+		v := reflect.ValueOf(value)
+		if v.Kind() != reflect.Slice {
+			return nil, fmt.Errorf("cannot convert %T to %s", value, mappingType)
+		}
+		elements := []interface{}{}
+		for i := 0; i < v.Len(); i++ {
+			element, err := CoerceValue(elementType, v.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, element)
+		}
+		return typedSlice(elementType, elements), nil
+	}
+
+	if s, ok := value.(string); ok && mappingType == Boolean {
+		return strconv.ParseBool(s)
+	}
+	converted, err := convertScalar(mappingType, value)
+	if err != nil {
+		return nil, err
+	}
+	if mappingType == Integer {
+		i := converted.(int64)
+		if i > math.MaxInt32 || i < math.MinInt32 {
+			return nil, fmt.Errorf("%v overflows %s", i, mappingType)
+		}
+		return int(i), nil
+	}
+	return converted, nil
+}
+
+// typedSlice converts elements, already coerced to elementType, to a slice of the matching Go type
+func typedSlice(elementType AstarteMappingType, elements []interface{}) interface{} {
+	var slice reflect.Value
+	switch elementType {
+	case Double:
+		slice = reflect.ValueOf([]float64{})
+	case Integer:
+		slice = reflect.ValueOf([]int{})
+	case LongInteger:
+		slice = reflect.ValueOf([]int64{})
+	case Boolean:
+		slice = reflect.ValueOf([]bool{})
+	case String:
+		slice = reflect.ValueOf([]string{})
+	case BinaryBlob:
+		slice = reflect.ValueOf([][]byte{})
+	case DateTime:
+		slice = reflect.ValueOf([]time.Time{})
+	default:
+		return elements
+	}
+	for _, e := range elements {
+		slice = reflect.Append(slice, reflect.ValueOf(e))
+	}
+	return slice.Interface()
+}
+
 func simpleMappingValidation(astarteInterface AstarteInterface, interfacePath string) (AstarteInterfaceMapping, error) {
 	// Is the path valid?
 	for _, mapping := range astarteInterface.Mappings {
@@ -221,6 +338,12 @@ func simpleMappingValidation(astarteInterface AstarteInterface, interfacePath st
 }
 
 func parametricMappingValidation(astarteInterface AstarteInterface, interfacePath string) (AstarteInterfaceMapping, error) {
+	mapping, _, err := parametricMappingMatch(astarteInterface, interfacePath)
+	return mapping, err
+}
+
+// parametricMappingMatch returns the mapping matching interfacePath, together with the values of its parameters
+func parametricMappingMatch(astarteInterface AstarteInterface, interfacePath string) (AstarteInterfaceMapping, map[string]string, error) {
 	// Is the path valid?
 	interfacePathTokens := strings.Split(interfacePath, "/")
 	for _, mapping := range astarteInterface.Mappings {
@@ -230,17 +353,35 @@ func parametricMappingValidation(astarteInterface AstarteInterface, interfacePat
 		}
 		// Iterate
 		matchFound := true
+		parameters := map[string]string{}
 		for index, token := range mappingTokens {
-			if interfacePathTokens[index] != token && !strings.HasPrefix(token, "%{") {
+			if name, ok := endpointParameterName(token); ok {
+				// Parameters cannot be empty, e.g. /a//value does not match /a/%{id}/value
+				if interfacePathTokens[index] == "" {
+					matchFound = false
+					break
+				}
+				parameters[name] = interfacePathTokens[index]
+				continue
+			}
+			if interfacePathTokens[index] != token {
 				matchFound = false
 				break
 			}
 		}
 		if matchFound {
-			return mapping, nil
+			return mapping, parameters, nil
 		}
 	}
-	return AstarteInterfaceMapping{}, fmt.Errorf("Path %s does not exist on Interface %s", interfacePath, astarteInterface.Name)
+	return AstarteInterfaceMapping{}, nil, fmt.Errorf("Path %s does not exist on Interface %s", interfacePath, astarteInterface.Name)
+}
+
+// endpointParameterName returns the name of the parameter of an endpoint token, if the token is parametric
+func endpointParameterName(token string) (string, bool) {
+	if !strings.HasPrefix(token, "%{") {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(token, "%{"), "}"), true
 }
 
 func processGenericSlice(mappingType AstarteMappingType, c []interface{}) error {
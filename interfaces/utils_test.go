@@ -573,3 +573,111 @@ func TestPayloadNormalization(t *testing.T) {
 		t.Error("Multimap conversion failed", NormalizePayload(inMultiMap, false), outMultiMapNonEncoded)
 	}
 }
+
+func TestValueCoercion(t *testing.T) {
+	timestamp := time.Date(2021, 3, 4, 10, 11, 12, 0, time.UTC)
+	cases := []struct {
+		mappingType AstarteMappingType
+		value       interface{}
+		expected    interface{}
+	}{
+		{Double, 12.5, 12.5},
+		{Double, "12.5", 12.5},
+		{Integer, 12.0, 12},
+		{Integer, "12", 12},
+		{LongInteger, "9007199254740993", int64(9007199254740993)},
+		{LongInteger, json.Number("9007199254740993"), int64(9007199254740993)},
+		{Boolean, "true", true},
+		{String, "12", "12"},
+		{BinaryBlob, "3q2+7w==", []byte{0xde, 0xad, 0xbe, 0xef}},
+		{DateTime, "2021-03-04T10:11:12Z", timestamp},
+		{DateTime, json.Number("1614852672000"), timestamp},
+		{IntegerArray, []interface{}{1.0, 2.0}, []int{1, 2}},
+		{StringArray, `["a", "b"]`, []string{"a", "b"}},
+		{LongIntegerArray, `[9007199254740993]`, []int64{9007199254740993}},
+		{DateTimeArray, []interface{}{"2021-03-04T10:11:12Z"}, []time.Time{timestamp}},
+	}
+
+	for _, c := range cases {
+		coerced, err := CoerceValue(c.mappingType, c.value)
+		if err != nil {
+			t.Errorf("%v as %s: %v", c.value, c.mappingType, err)
+			continue
+		}
+		if !reflect.DeepEqual(coerced, c.expected) {
+			t.Errorf("%v as %s: expected %#v, got %#v", c.value, c.mappingType, c.expected, coerced)
+		}
+		if err := validateType(c.mappingType, coerced); err != nil {
+			t.Errorf("%v as %s: coerced value does not validate: %v", c.value, c.mappingType, err)
+		}
+	}
+
+	failing := []struct {
+		mappingType AstarteMappingType
+		value       interface{}
+	}{
+		{Integer, 12.5},
+		{Integer, 3000000000.0},
+		{Boolean, "maybe"},
+		{DoubleArray, "not an array"},
+		{DateTime, "yesterday"},
+	}
+	for _, c := range failing {
+		if coerced, err := CoerceValue(c.mappingType, c.value); err == nil {
+			t.Errorf("%v as %s: expected failure, got %#v", c.value, c.mappingType, coerced)
+		}
+	}
+}
+
+func TestInterfacePathParameters(t *testing.T) {
+	iface := AstarteInterface{
+		Name:        "org.astarte-platform.genericsensors.AvailableSensors",
+		Type:        PropertiesType,
+		Aggregation: IndividualAggregation,
+		Mappings: []AstarteInterfaceMapping{
+			{Endpoint: "/%{sensor_id}/name", Type: String},
+			{Endpoint: "/%{sensor_id}/%{channel}/unit", Type: String},
+		},
+	}
+
+	interfacePath, err := BuildInterfacePath(iface.Mappings[1].Endpoint, map[string]string{"sensor_id": "s1", "channel": "c2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if interfacePath != "/s1/c2/unit" {
+		t.Errorf("unexpected path %s", interfacePath)
+	}
+
+	mapping, parameters, err := InterfaceMappingAndParametersFromPath(iface, interfacePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapping.Endpoint != iface.Mappings[1].Endpoint || !reflect.DeepEqual(parameters, map[string]string{"sensor_id": "s1", "channel": "c2"}) {
+		t.Errorf("unexpected mapping %v with parameters %v", mapping.Endpoint, parameters)
+	}
+	if _, _, err := InterfaceMappingAndParametersFromPath(iface, "/s1/unit"); err == nil {
+		t.Error("unknown path was matched")
+	}
+	for _, emptyParameterPath := range []string{"//name", "/s1//unit", "///unit"} {
+		if _, _, err := InterfaceMappingAndParametersFromPath(iface, emptyParameterPath); err == nil {
+			t.Errorf("%s was matched with an empty parameter", emptyParameterPath)
+		}
+		if err := ValidateInterfacePath(iface, emptyParameterPath); err == nil {
+			t.Errorf("%s is valid", emptyParameterPath)
+		}
+	}
+
+	invalidParameters := []map[string]string{
+		{"sensor_id": "s1"},
+		{"sensor_id": "s1", "channel": ""},
+		{"sensor_id": "s/1", "channel": "c2"},
+		{"sensor_id": "s+", "channel": "c2"},
+		{"sensor_id": "s1", "channel": "#"},
+		{"sensor_id": "s1", "channel": "c2", "extra": "e"},
+	}
+	for _, p := range invalidParameters {
+		if _, err := BuildInterfacePath(iface.Mappings[1].Endpoint, p); err == nil {
+			t.Errorf("parameters %v were accepted", p)
+		}
+	}
+}
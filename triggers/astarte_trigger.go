@@ -0,0 +1,414 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package triggers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Enum types are not checked when unmarshaling, so that triggers using values introduced by newer Astarte
+// versions can still be retrieved and installed again. Use IsValid to check them. Likewise, fields unknown to
+// this package are kept in Extra and marshaled back as they are.
+
+// SimpleTriggerType represents the kind of a Simple Trigger
+type SimpleTriggerType string
+
+const (
+	// DataTriggerType represents a trigger on data exchanged on an Interface
+	DataTriggerType SimpleTriggerType = "data_trigger"
+	// DeviceTriggerType represents a trigger on a Device event
+	DeviceTriggerType SimpleTriggerType = "device_trigger"
+)
+
+// IsValid returns an error if SimpleTriggerType does not represent a valid Simple Trigger type
+func (t SimpleTriggerType) IsValid() error {
+	switch t {
+	case DataTriggerType, DeviceTriggerType:
+		return nil
+	}
+	return errors.New("invalid Simple Trigger type")
+}
+
+// TriggerCondition represents the condition ("on") a Simple Trigger fires on
+type TriggerCondition string
+
+const (
+	// IncomingDataCondition fires on every value received on the matching path
+	IncomingDataCondition TriggerCondition = "incoming_data"
+	// ValueChangeCondition fires when the value on the matching path changes
+	ValueChangeCondition TriggerCondition = "value_change"
+	// ValueChangeAppliedCondition fires after a changed value on the matching path is applied
+	ValueChangeAppliedCondition TriggerCondition = "value_change_applied"
+	// PathCreatedCondition fires when the matching path is set for the first time
+	PathCreatedCondition TriggerCondition = "path_created"
+	// PathRemovedCondition fires when the matching path is unset
+	PathRemovedCondition TriggerCondition = "path_removed"
+	// ValueStoredCondition fires after a value on the matching path is stored
+	ValueStoredCondition TriggerCondition = "value_stored"
+
+	// DeviceConnectedCondition fires when a Device connects
+	DeviceConnectedCondition TriggerCondition = "device_connected"
+	// DeviceDisconnectedCondition fires when a Device disconnects
+	DeviceDisconnectedCondition TriggerCondition = "device_disconnected"
+	// DeviceErrorCondition fires when a Device causes an error
+	DeviceErrorCondition TriggerCondition = "device_error"
+	// DeviceEmptyCacheReceivedCondition fires when a Device sends an empty cache message
+	DeviceEmptyCacheReceivedCondition TriggerCondition = "device_empty_cache_received"
+	// IncomingIntrospectionCondition fires when a Device sends its introspection
+	IncomingIntrospectionCondition TriggerCondition = "incoming_introspection"
+	// InterfaceAddedCondition fires when an Interface is added to the introspection of a Device
+	InterfaceAddedCondition TriggerCondition = "interface_added"
+	// InterfaceRemovedCondition fires when an Interface is removed from the introspection of a Device
+	InterfaceRemovedCondition TriggerCondition = "interface_removed"
+	// InterfaceMinorUpdatedCondition fires when the minor version of an Interface in the introspection of a Device changes
+	InterfaceMinorUpdatedCondition TriggerCondition = "interface_minor_updated"
+)
+
+// IsValid returns an error if TriggerCondition is not a valid condition for a Simple Trigger of type t
+func (c TriggerCondition) IsValid(t SimpleTriggerType) error {
+	switch {
+	case t == DataTriggerType && c.IsDataCondition(), t == DeviceTriggerType && c.IsDeviceCondition():
+		return nil
+	}
+	return errors.New("invalid Simple Trigger condition")
+}
+
+// IsDataCondition returns whether TriggerCondition is a condition of data triggers
+func (c TriggerCondition) IsDataCondition() bool {
+	switch c {
+	case IncomingDataCondition, ValueChangeCondition, ValueChangeAppliedCondition, PathCreatedCondition,
+		PathRemovedCondition, ValueStoredCondition:
+		return true
+	}
+	return false
+}
+
+// IsDeviceCondition returns whether TriggerCondition is a condition of device triggers
+func (c TriggerCondition) IsDeviceCondition() bool {
+	switch c {
+	case DeviceConnectedCondition, DeviceDisconnectedCondition, DeviceErrorCondition, DeviceEmptyCacheReceivedCondition,
+		IncomingIntrospectionCondition, InterfaceAddedCondition, InterfaceRemovedCondition, InterfaceMinorUpdatedCondition:
+		return true
+	}
+	return false
+}
+
+// IsIntrospectionCondition returns whether TriggerCondition is a device condition on introspection changes,
+// which can refer to an Interface
+func (c TriggerCondition) IsIntrospectionCondition() bool {
+	switch c {
+	case InterfaceAddedCondition, InterfaceRemovedCondition, InterfaceMinorUpdatedCondition:
+		return true
+	}
+	return false
+}
+
+// ValueMatchOperator represents how the value of a data trigger is compared with its known value
+type ValueMatchOperator string
+
+const (
+	// AnyValueOperator matches any value, and requires no known value
+	AnyValueOperator ValueMatchOperator = "*"
+	// EqualToOperator matches values equal to the known value
+	EqualToOperator ValueMatchOperator = "=="
+	// NotEqualToOperator matches values not equal to the known value
+	NotEqualToOperator ValueMatchOperator = "!="
+	// GreaterThanOperator matches values greater than the known value
+	GreaterThanOperator ValueMatchOperator = ">"
+	// GreaterOrEqualToOperator matches values greater than or equal to the known value
+	GreaterOrEqualToOperator ValueMatchOperator = ">="
+	// LessThanOperator matches values less than the known value
+	LessThanOperator ValueMatchOperator = "<"
+	// LessOrEqualToOperator matches values less than or equal to the known value
+	LessOrEqualToOperator ValueMatchOperator = "<="
+	// ContainsOperator matches strings or arrays containing the known value
+	ContainsOperator ValueMatchOperator = "contains"
+	// NotContainsOperator matches strings or arrays not containing the known value
+	NotContainsOperator ValueMatchOperator = "not_contains"
+)
+
+// IsValid returns an error if ValueMatchOperator does not represent a valid value match operator
+func (o ValueMatchOperator) IsValid() error {
+	switch o {
+	case AnyValueOperator, EqualToOperator, NotEqualToOperator, GreaterThanOperator, GreaterOrEqualToOperator,
+		LessThanOperator, LessOrEqualToOperator, ContainsOperator, NotContainsOperator:
+		return nil
+	}
+	return errors.New("invalid value match operator")
+}
+
+// MustacheTemplateType is the only template type supported by HTTP actions
+const MustacheTemplateType = "mustache"
+
+// SimpleTrigger is a condition of an AstarteTrigger. Data triggers use InterfaceName ("*" for any Interface),
+// InterfaceMajor, MatchPath ("/*" for any path), ValueMatchOperator and KnownValue. Device triggers on
+// introspection changes can use InterfaceName and InterfaceMajor. DeviceID or GroupName restrict the trigger
+// to a single Device or group. Optional numbers are pointers, so that zero values survive JSON round-trips.
+// Numbers in KnownValue are decoded as json.Number, to preserve their exact representation.
+type SimpleTrigger struct {
+	Type               SimpleTriggerType  `json:"type"`
+	On                 TriggerCondition   `json:"on"`
+	InterfaceName      string             `json:"interface_name,omitempty"`
+	InterfaceMajor     *int               `json:"interface_major,omitempty"`
+	MatchPath          string             `json:"match_path,omitempty"`
+	ValueMatchOperator ValueMatchOperator `json:"value_match_operator,omitempty"`
+	KnownValue         interface{}        `json:"known_value,omitempty"`
+	DeviceID           string             `json:"device_id,omitempty"`
+	GroupName          string             `json:"group_name,omitempty"`
+	// Extra holds the fields unknown to this package
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// UnmarshalJSON unmarshals a Simple Trigger, keeping the exact representation of numbers in KnownValue
+func (t *SimpleTrigger) UnmarshalJSON(b []byte) error {
+	type simpleTrigger SimpleTrigger
+	var raw struct {
+		simpleTrigger
+		KnownValue json.RawMessage `json:"known_value"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	extra, err := unknownFields(b, raw.simpleTrigger)
+	if err != nil {
+		return err
+	}
+
+	*t = SimpleTrigger(raw.simpleTrigger)
+	t.Extra = extra
+	t.KnownValue = nil
+	if len(raw.KnownValue) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(raw.KnownValue))
+		decoder.UseNumber()
+		if err := decoder.Decode(&t.KnownValue); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarshalJSON marshals a Simple Trigger, including its Extra fields
+func (t SimpleTrigger) MarshalJSON() ([]byte, error) {
+	type simpleTrigger SimpleTrigger
+	return marshalWithExtra(simpleTrigger(t), t.Extra)
+}
+
+// TriggerAction is what an AstarteTrigger does when it fires: either an HTTP request or an AMQP message.
+// Optional booleans and numbers are pointers, so that zero values survive JSON round-trips.
+type TriggerAction struct {
+	HTTPURL string `json:"http_url,omitempty"`
+	// HTTPPostURL is the legacy form of an HTTP action, equivalent to HTTPURL with the POST method
+	HTTPPostURL       string            `json:"http_post_url,omitempty"`
+	HTTPMethod        string            `json:"http_method,omitempty"`
+	HTTPStaticHeaders map[string]string `json:"http_static_headers,omitempty"`
+	Template          string            `json:"template,omitempty"`
+	TemplateType      string            `json:"template_type,omitempty"`
+	IgnoreSSLErrors   *bool             `json:"ignore_ssl_errors,omitempty"`
+
+	AMQPExchange                string            `json:"amqp_exchange,omitempty"`
+	AMQPRoutingKey              string            `json:"amqp_routing_key,omitempty"`
+	AMQPMessagePersistent       *bool             `json:"amqp_message_persistent,omitempty"`
+	AMQPMessageExpirationMillis *int              `json:"amqp_message_expiration_ms,omitempty"`
+	AMQPMessagePriority         *int              `json:"amqp_message_priority,omitempty"`
+	AMQPStaticHeaders           map[string]string `json:"amqp_static_headers,omitempty"`
+
+	// Extra holds the fields unknown to this package
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// UnmarshalJSON unmarshals a Trigger Action, keeping unknown fields in Extra
+func (a *TriggerAction) UnmarshalJSON(b []byte) error {
+	type triggerAction TriggerAction
+	raw := triggerAction{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	extra, err := unknownFields(b, raw)
+	if err != nil {
+		return err
+	}
+	*a = TriggerAction(raw)
+	a.Extra = extra
+	return nil
+}
+
+// MarshalJSON marshals a Trigger Action, including its Extra fields
+func (a TriggerAction) MarshalJSON() ([]byte, error) {
+	type triggerAction TriggerAction
+	return marshalWithExtra(triggerAction(a), a.Extra)
+}
+
+// IsHTTP returns whether the action sends an HTTP request
+func (a TriggerAction) IsHTTP() bool {
+	return a.HTTPURL != "" || a.HTTPPostURL != ""
+}
+
+// IsAMQP returns whether the action sends an AMQP message
+func (a TriggerAction) IsAMQP() bool {
+	return a.AMQPExchange != ""
+}
+
+// AstarteTrigger represents an Astarte Trigger. Policy is the name of the DeliveryPolicy of an HTTP trigger.
+type AstarteTrigger struct {
+	Name           string          `json:"name"`
+	Action         TriggerAction   `json:"action"`
+	SimpleTriggers []SimpleTrigger `json:"simple_triggers"`
+	Policy         string          `json:"policy,omitempty"`
+	// Extra holds the fields unknown to this package
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// UnmarshalJSON unmarshals a Trigger, keeping unknown fields in Extra
+func (t *AstarteTrigger) UnmarshalJSON(b []byte) error {
+	type astarteTrigger AstarteTrigger
+	raw := astarteTrigger{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	extra, err := unknownFields(b, raw)
+	if err != nil {
+		return err
+	}
+	*t = AstarteTrigger(raw)
+	t.Extra = extra
+	return nil
+}
+
+// MarshalJSON marshals a Trigger, including its Extra fields
+func (t AstarteTrigger) MarshalJSON() ([]byte, error) {
+	type astarteTrigger AstarteTrigger
+	return marshalWithExtra(astarteTrigger(t), t.Extra)
+}
+
+// ParseTriggerFromFile is a convenience function to call ParseTrigger with a file as input
+func ParseTriggerFromFile(triggerFile string) (AstarteTrigger, error) {
+	b, err := ioutil.ReadFile(triggerFile)
+	if err != nil {
+		return AstarteTrigger{}, err
+	}
+	return ParseTrigger(b)
+}
+
+// ParseTrigger parses a trigger from a JSON string and returns an AstarteTrigger object when successful
+func ParseTrigger(triggerContent []byte) (AstarteTrigger, error) {
+	trigger := AstarteTrigger{}
+	err := json.Unmarshal(triggerContent, &trigger)
+	return trigger, err
+}
+
+// NewTrigger returns an AstarteTrigger named name, running action when any of simpleTriggers fires
+func NewTrigger(name string, action TriggerAction, simpleTriggers ...SimpleTrigger) AstarteTrigger {
+	return AstarteTrigger{Name: name, Action: action, SimpleTriggers: append([]SimpleTrigger{}, simpleTriggers...)}
+}
+
+// WithPolicy returns a copy of the trigger delivering its events according to the Delivery Policy policyName
+func (t AstarteTrigger) WithPolicy(policyName string) AstarteTrigger {
+	t.Policy = policyName
+	return t
+}
+
+// NewDataSimpleTrigger returns a data trigger firing on any value on matchPath of a major version of an Interface.
+// Use WithValueMatch to restrict the values it fires on.
+func NewDataSimpleTrigger(on TriggerCondition, interfaceName string, interfaceMajor int, matchPath string) SimpleTrigger {
+	return SimpleTrigger{Type: DataTriggerType, On: on, InterfaceName: interfaceName, InterfaceMajor: &interfaceMajor,
+		MatchPath: matchPath, ValueMatchOperator: AnyValueOperator}
+}
+
+// NewDeviceSimpleTrigger returns a device trigger firing on a Device event of any Device
+func NewDeviceSimpleTrigger(on TriggerCondition) SimpleTrigger {
+	return SimpleTrigger{Type: DeviceTriggerType, On: on}
+}
+
+// WithValueMatch returns a copy of a data trigger firing only on values matching operator and knownValue
+func (t SimpleTrigger) WithValueMatch(operator ValueMatchOperator, knownValue interface{}) SimpleTrigger {
+	t.ValueMatchOperator = operator
+	t.KnownValue = knownValue
+	return t
+}
+
+// ForDevice returns a copy of the Simple Trigger restricted to a single Device
+func (t SimpleTrigger) ForDevice(deviceID string) SimpleTrigger {
+	t.DeviceID = deviceID
+	return t
+}
+
+// ForGroup returns a copy of the Simple Trigger restricted to the Devices of a group
+func (t SimpleTrigger) ForGroup(groupName string) SimpleTrigger {
+	t.GroupName = groupName
+	return t
+}
+
+// NewHTTPAction returns an action sending an HTTP request with method to url. The default payload is a JSON
+// describing the event: use WithTemplate to customize it.
+func NewHTTPAction(url, method string) TriggerAction {
+	return TriggerAction{HTTPURL: url, HTTPMethod: method}
+}
+
+// WithTemplate returns a copy of an HTTP action sending a Mustache template as payload
+func (a TriggerAction) WithTemplate(template string) TriggerAction {
+	a.Template = template
+	a.TemplateType = MustacheTemplateType
+	return a
+}
+
+// NewAMQPAction returns an action publishing a message on exchange with routingKey. Messages expire after
+// expiration, and are persisted if persistent is true.
+func NewAMQPAction(exchange, routingKey string, persistent bool, expiration time.Duration) TriggerAction {
+	expirationMillis := int(expiration / time.Millisecond)
+	return TriggerAction{AMQPExchange: exchange, AMQPRoutingKey: routingKey, AMQPMessagePersistent: &persistent,
+		AMQPMessageExpirationMillis: &expirationMillis}
+}
+
+// unknownFields returns the fields of the JSON object b which don't match a field of the struct v, or nil if
+// there are none
+func unknownFields(b []byte, v interface{}) (map[string]json.RawMessage, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		delete(fields, name)
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return fields, nil
+}
+
+// marshalWithExtra marshals v, adding the extra fields which are not fields of v
+func marshalWithExtra(v interface{}, extra map[string]json.RawMessage) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return b, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	for name, value := range extra {
+		if _, ok := fields[name]; !ok {
+			fields[name] = value
+		}
+	}
+	return json.Marshal(fields)
+}
@@ -0,0 +1,169 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package triggers
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func decodeJSONWithNumbers(t *testing.T, b []byte) interface{} {
+	var ret interface{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&ret); err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+// testTriggerWithUnknownFields has fields unknown to this package at each level
+const testTriggerWithUnknownFields = `{
+	"name": "future",
+	"action": {"http_url": "https://example.com/hook", "http_method": "post", "http_retries": {"max": 3}},
+	"simple_triggers": [
+		{"type": "device_trigger", "on": "device_connected", "realm_wide": true, "tags": ["a", "b"]}
+	],
+	"policy": "retry",
+	"labels": {"team": "sensors"}
+}`
+
+func TestTriggerRoundTrip(t *testing.T) {
+	triggers := []string{`{
+		"name": "overheat",
+		"action": {
+			"http_url": "https://example.com/hook",
+			"http_method": "put",
+			"http_static_headers": {"Authorization": "Bearer token"},
+			"template": "{{ value }}",
+			"template_type": "mustache",
+			"ignore_ssl_errors": false
+		},
+		"simple_triggers": [
+			{
+				"type": "data_trigger",
+				"on": "incoming_data",
+				"interface_name": "org.astarte-platform.genericsensors.Values",
+				"interface_major": 0,
+				"match_path": "/%{sensor_id}/value",
+				"value_match_operator": ">",
+				"known_value": 12345678901234567890
+			},
+			{
+				"type": "data_trigger",
+				"on": "value_change",
+				"interface_name": "*",
+				"match_path": "/*",
+				"value_match_operator": "==",
+				"known_value": false,
+				"group_name": "sensors"
+			}
+		]
+	}`, `{
+		"name": "connections",
+		"action": {
+			"amqp_exchange": "astarte_events_test_connections",
+			"amqp_routing_key": "connections",
+			"amqp_message_persistent": false,
+			"amqp_message_expiration_ms": 0,
+			"amqp_message_priority": 0,
+			"amqp_static_headers": {"source": "astarte"}
+		},
+		"simple_triggers": [
+			{"type": "device_trigger", "on": "device_connected", "device_id": "1vMeFtaJQF259nMsnis3sw"},
+			{"type": "device_trigger", "on": "interface_added", "interface_name": "org.astarte-platform.genericsensors.Values", "interface_major": 1}
+		]
+	}`, `{
+		"name": "legacy",
+		"action": {"http_post_url": "http://example.com"},
+		"simple_triggers": [{"type": "device_trigger", "on": "device_error"}]
+	}`, testTriggerWithUnknownFields}
+
+	for _, triggerJSON := range triggers {
+		trigger, err := ParseTrigger([]byte(triggerJSON))
+		if err != nil {
+			t.Fatal(err)
+		}
+		marshaled, err := json.Marshal(trigger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if original, roundTripped := decodeJSONWithNumbers(t, []byte(triggerJSON)), decodeJSONWithNumbers(t, marshaled); !reflect.DeepEqual(original, roundTripped) {
+			t.Errorf("trigger changed after round trip: %s", marshaled)
+		}
+	}
+}
+
+func TestTriggerConstructors(t *testing.T) {
+	trigger := NewTrigger("overheat", NewHTTPAction("https://example.com/hook", "post").WithTemplate("{{ value }}"),
+		NewDataSimpleTrigger(IncomingDataCondition, "org.astarte-platform.genericsensors.Values", 0, "/%{sensor_id}/value").
+			WithValueMatch(GreaterThanOperator, 80.5).ForGroup("sensors"),
+		NewDeviceSimpleTrigger(DeviceDisconnectedCondition).ForDevice("1vMeFtaJQF259nMsnis3sw"))
+	marshaled, err := json.Marshal(trigger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{
+		"name": "overheat",
+		"action": {"http_url": "https://example.com/hook", "http_method": "post", "template": "{{ value }}", "template_type": "mustache"},
+		"simple_triggers": [
+			{"type": "data_trigger", "on": "incoming_data", "interface_name": "org.astarte-platform.genericsensors.Values",
+			 "interface_major": 0, "match_path": "/%{sensor_id}/value", "value_match_operator": ">", "known_value": 80.5,
+			 "group_name": "sensors"},
+			{"type": "device_trigger", "on": "device_disconnected", "device_id": "1vMeFtaJQF259nMsnis3sw"}
+		]
+	}`
+	if !reflect.DeepEqual(decodeJSONWithNumbers(t, marshaled), decodeJSONWithNumbers(t, []byte(expected))) {
+		t.Errorf("unexpected trigger %s", marshaled)
+	}
+
+	action := NewAMQPAction("astarte_events_test_exchange", "key", true, 2*time.Second)
+	if !action.IsAMQP() || action.IsHTTP() || *action.AMQPMessageExpirationMillis != 2000 || !*action.AMQPMessagePersistent {
+		t.Errorf("unexpected action %+v", action)
+	}
+	if err := DeviceConnectedCondition.IsValid(DataTriggerType); err == nil {
+		t.Error("device condition is valid for data triggers")
+	}
+}
+
+func TestTriggerUnknownFields(t *testing.T) {
+	trigger, err := ParseTrigger([]byte(testTriggerWithUnknownFields))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trigger.Extra) != 1 || string(trigger.Extra["labels"]) != `{"team": "sensors"}` ||
+		len(trigger.Action.Extra) != 1 || len(trigger.SimpleTriggers[0].Extra) != 2 {
+		t.Errorf("unexpected unknown fields %v %v %v", trigger.Extra, trigger.Action.Extra, trigger.SimpleTriggers[0].Extra)
+	}
+
+	// Known fields are marshaled from the model
+	trigger.Policy = ""
+	trigger.SimpleTriggers[0].On = DeviceDisconnectedCondition
+	marshaled, err := json.Marshal(trigger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTripped, err := ParseTrigger(marshaled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roundTripped.Policy != "" || roundTripped.SimpleTriggers[0].On != DeviceDisconnectedCondition ||
+		!reflect.DeepEqual(roundTripped.SimpleTriggers[0].Extra["tags"], json.RawMessage(`["a","b"]`)) {
+		t.Errorf("unexpected trigger %s", marshaled)
+	}
+}
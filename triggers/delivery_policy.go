@@ -0,0 +1,223 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package triggers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// ErrorKeyword is a class of HTTP errors handled by an ErrorHandler
+type ErrorKeyword string
+
+const (
+	// AnyError matches any 4xx or 5xx status code
+	AnyError ErrorKeyword = "any_error"
+	// ClientError matches 4xx status codes
+	ClientError ErrorKeyword = "client_error"
+	// ServerError matches 5xx status codes
+	ServerError ErrorKeyword = "server_error"
+)
+
+// IsValid returns an error if ErrorKeyword does not represent a valid class of errors
+func (k ErrorKeyword) IsValid() error {
+	switch k {
+	case AnyError, ClientError, ServerError:
+		return nil
+	}
+	return errors.New("invalid error keyword")
+}
+
+// codes returns the range of status codes matched by the keyword
+func (k ErrorKeyword) codes() (int, int) {
+	switch k {
+	case ClientError:
+		return 400, 499
+	case ServerError:
+		return 500, 599
+	}
+	return 400, 599
+}
+
+// ErrorHandlerStrategy is what a Delivery Policy does with an event whose delivery failed
+type ErrorHandlerStrategy string
+
+const (
+	// DiscardStrategy drops the event
+	DiscardStrategy ErrorHandlerStrategy = "discard"
+	// RetryStrategy tries to deliver the event again, up to the RetryTimes of the Delivery Policy
+	RetryStrategy ErrorHandlerStrategy = "retry"
+)
+
+// IsValid returns an error if ErrorHandlerStrategy does not represent a valid strategy
+func (s ErrorHandlerStrategy) IsValid() error {
+	switch s {
+	case DiscardStrategy, RetryStrategy:
+		return nil
+	}
+	return errors.New("invalid error handler strategy")
+}
+
+// ErrorHandlerCondition is the set of status codes an ErrorHandler applies to: either a class of errors, or a list
+// of custom status codes. In JSON, it is either a string or an array of numbers.
+type ErrorHandlerCondition struct {
+	Keyword ErrorKeyword
+	Codes   []int
+}
+
+// MarshalJSON marshals the condition as a keyword, or as its list of status codes
+func (c ErrorHandlerCondition) MarshalJSON() ([]byte, error) {
+	if c.Keyword != "" {
+		return json.Marshal(c.Keyword)
+	}
+	return json.Marshal(c.Codes)
+}
+
+// UnmarshalJSON unmarshals either a keyword or a list of status codes
+func (c *ErrorHandlerCondition) UnmarshalJSON(b []byte) error {
+	*c = ErrorHandlerCondition{}
+	if strings.HasPrefix(strings.TrimSpace(string(b)), "[") {
+		return json.Unmarshal(b, &c.Codes)
+	}
+	return json.Unmarshal(b, &c.Keyword)
+}
+
+// ErrorHandler is a Strategy applied to events whose delivery failed with a status code matching On
+type ErrorHandler struct {
+	On       ErrorHandlerCondition `json:"on"`
+	Strategy ErrorHandlerStrategy  `json:"strategy"`
+}
+
+// DeliveryPolicy represents an Astarte Trigger Delivery Policy, which governs how events of the HTTP triggers
+// using it are queued and retried. Optional numbers are pointers, so that zero values survive JSON round-trips.
+type DeliveryPolicy struct {
+	Name          string         `json:"name"`
+	ErrorHandlers []ErrorHandler `json:"error_handlers"`
+	// MaximumCapacity is the maximum number of events queued by the policy
+	MaximumCapacity int `json:"maximum_capacity"`
+	// RetryTimes is how many times an event is retried, if any ErrorHandler uses RetryStrategy
+	RetryTimes *int `json:"retry_times,omitempty"`
+	// EventTTL is how many seconds events are kept in the queue
+	EventTTL *int `json:"event_ttl,omitempty"`
+	// PrefetchCount is how many events are delivered concurrently
+	PrefetchCount *int `json:"prefetch_count,omitempty"`
+}
+
+// Validate returns an error if the Delivery Policy would be refused by Astarte
+func (p DeliveryPolicy) Validate() error {
+	if p.Name == "" {
+		return errors.New("name is empty")
+	}
+	if len(p.Name) > 128 {
+		return errors.New("name is longer than 128 characters")
+	}
+	if strings.HasPrefix(p.Name, "@") {
+		return errors.New("names starting with '@' are reserved")
+	}
+	retries, err := validateErrorHandlers(p.ErrorHandlers)
+	if err != nil {
+		return err
+	}
+
+	if p.MaximumCapacity <= 0 {
+		return errors.New("maximum_capacity must be positive")
+	}
+	switch {
+	case retries && (p.RetryTimes == nil || *p.RetryTimes <= 0):
+		return errors.New("retry_times must be positive when retrying")
+	case !retries && p.RetryTimes != nil:
+		return errors.New("retry_times can be set only when retrying")
+	}
+	if p.EventTTL != nil && *p.EventTTL < 0 {
+		return errors.New("event_ttl must not be negative")
+	}
+	if p.PrefetchCount != nil && *p.PrefetchCount <= 0 {
+		return errors.New("prefetch_count must be positive")
+	}
+	return nil
+}
+
+// validateErrorHandlers checks that handlers handle distinct error status codes, and returns whether any of them retries
+func validateErrorHandlers(handlers []ErrorHandler) (bool, error) {
+	if len(handlers) == 0 {
+		return false, errors.New("at least one error handler is needed")
+	}
+
+	handled := map[int]bool{}
+	retries := false
+	for i, handler := range handlers {
+		if err := handler.Strategy.IsValid(); err != nil {
+			return false, fmt.Errorf("error handler %d: %w", i, err)
+		}
+		retries = retries || handler.Strategy == RetryStrategy
+
+		codes, err := handler.On.statusCodes()
+		if err != nil {
+			return false, fmt.Errorf("error handler %d: %w", i, err)
+		}
+		for _, code := range codes {
+			if handled[code] {
+				return false, fmt.Errorf("error handler %d: status code %d is already handled", i, code)
+			}
+			handled[code] = true
+		}
+	}
+	return retries, nil
+}
+
+// statusCodes returns all the status codes matched by the condition, expanding its keyword
+func (c ErrorHandlerCondition) statusCodes() ([]int, error) {
+	switch {
+	case c.Keyword != "" && len(c.Codes) > 0:
+		return nil, errors.New("keyword and codes cannot be both set")
+	case c.Keyword != "":
+		if err := c.Keyword.IsValid(); err != nil {
+			return nil, err
+		}
+		codes := []int{}
+		for first, last := c.Keyword.codes(); first <= last; first++ {
+			codes = append(codes, first)
+		}
+		return codes, nil
+	case len(c.Codes) == 0:
+		return nil, errors.New("no error codes")
+	}
+	for _, code := range c.Codes {
+		if code < 400 || code > 599 {
+			return nil, fmt.Errorf("%d is not an error status code", code)
+		}
+	}
+	return c.Codes, nil
+}
+
+// ParseDeliveryPolicyFromFile is a convenience function to call ParseDeliveryPolicy with a file as input
+func ParseDeliveryPolicyFromFile(policyFile string) (DeliveryPolicy, error) {
+	b, err := ioutil.ReadFile(policyFile)
+	if err != nil {
+		return DeliveryPolicy{}, err
+	}
+	return ParseDeliveryPolicy(b)
+}
+
+// ParseDeliveryPolicy parses a Delivery Policy from a JSON string and returns a DeliveryPolicy object when
+// successful
+func ParseDeliveryPolicy(policyContent []byte) (DeliveryPolicy, error) {
+	policy := DeliveryPolicy{}
+	err := json.Unmarshal(policyContent, &policy)
+	return policy, err
+}
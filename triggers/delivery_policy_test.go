@@ -0,0 +1,89 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package triggers
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const testPolicy = `{
+	"name": "retry-server-errors",
+	"error_handlers": [
+		{"on": "server_error", "strategy": "retry"},
+		{"on": [401, 403], "strategy": "discard"}
+	],
+	"maximum_capacity": 100,
+	"retry_times": 0,
+	"event_ttl": 0,
+	"prefetch_count": 10
+}`
+
+func TestDeliveryPolicyRoundTrip(t *testing.T) {
+	policy, err := ParseDeliveryPolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.ErrorHandlers[0].On.Keyword != ServerError || !reflect.DeepEqual(policy.ErrorHandlers[1].On.Codes, []int{401, 403}) {
+		t.Errorf("unexpected error handlers %+v", policy.ErrorHandlers)
+	}
+	marshaled, err := json.Marshal(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decodeJSONWithNumbers(t, marshaled), decodeJSONWithNumbers(t, []byte(testPolicy))) {
+		t.Errorf("policy changed after round trip: %s", marshaled)
+	}
+}
+
+func TestDeliveryPolicyValidate(t *testing.T) {
+	validPolicy := func() DeliveryPolicy {
+		policy, err := ParseDeliveryPolicy([]byte(testPolicy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		retryTimes := 5
+		policy.RetryTimes = &retryTimes
+		return policy
+	}
+	if err := validPolicy().Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	invalidPolicies := map[string]func(p *DeliveryPolicy){
+		"reserved name": func(p *DeliveryPolicy) { p.Name = "@default" },
+		"no handlers":   func(p *DeliveryPolicy) { p.ErrorHandlers = nil },
+		"overlapping handlers": func(p *DeliveryPolicy) {
+			p.ErrorHandlers = append(p.ErrorHandlers, ErrorHandler{On: ErrorHandlerCondition{Codes: []int{503}}, Strategy: DiscardStrategy})
+		},
+		"not an error":     func(p *DeliveryPolicy) { p.ErrorHandlers[1].On.Codes = []int{302} },
+		"invalid strategy": func(p *DeliveryPolicy) { p.ErrorHandlers[0].Strategy = "ignore" },
+		"invalid keyword":  func(p *DeliveryPolicy) { p.ErrorHandlers[0].On.Keyword = "some_error" },
+		"no capacity":      func(p *DeliveryPolicy) { p.MaximumCapacity = 0 },
+		"no retry times":   func(p *DeliveryPolicy) { p.RetryTimes = nil },
+		"useless retry times": func(p *DeliveryPolicy) {
+			p.ErrorHandlers = p.ErrorHandlers[1:]
+		},
+		"no prefetch": func(p *DeliveryPolicy) { *p.PrefetchCount = 0 },
+	}
+	for name, invalidate := range invalidPolicies {
+		policy := validPolicy()
+		invalidate(&policy)
+		if err := policy.Validate(); err == nil {
+			t.Errorf("%s: policy is valid", name)
+		}
+	}
+}
@@ -0,0 +1,426 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package triggers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/astarte-platform/astarte-go/interfaces"
+	"github.com/astarte-platform/astarte-go/misc"
+)
+
+// FindingSeverity represents how serious a ValidationFinding is
+type FindingSeverity int
+
+const (
+	// ErrorSeverity is a finding which makes Astarte refuse the trigger, or makes it never fire
+	ErrorSeverity FindingSeverity = iota
+	// WarningSeverity is a finding which is likely a mistake, but does not prevent the trigger from working
+	WarningSeverity
+)
+
+func (s FindingSeverity) String() string {
+	switch s {
+	case ErrorSeverity:
+		return "error"
+	case WarningSeverity:
+		return "warning"
+	}
+	return fmt.Sprintf("FindingSeverity(%d)", int(s))
+}
+
+// ValidationFinding is a single problem found in a trigger. Field is the JSON path of the offending field,
+// e.g. "simple_triggers[0].match_path".
+type ValidationFinding struct {
+	Severity FindingSeverity
+	Field    string
+	Message  string
+}
+
+func (f ValidationFinding) String() string {
+	return fmt.Sprintf("%s: %s: %s", f.Severity, f.Field, f.Message)
+}
+
+// ValidationFindings is the outcome of Validate
+type ValidationFindings []ValidationFinding
+
+// HasErrors returns whether any of the findings has ErrorSeverity
+func (f ValidationFindings) HasErrors() bool {
+	for _, finding := range f {
+		if finding.Severity == ErrorSeverity {
+			return true
+		}
+	}
+	return false
+}
+
+// propertiesOnlyConditions are data trigger conditions which only make sense for properties
+var propertiesOnlyConditions = map[TriggerCondition]bool{
+	ValueChangeCondition:        true,
+	ValueChangeAppliedCondition: true,
+	PathCreatedCondition:        true,
+	PathRemovedCondition:        true,
+}
+
+var validHTTPMethods = map[string]bool{
+	"get": true, "head": true, "post": true, "put": true, "patch": true, "delete": true, "options": true,
+	"connect": true, "trace": true,
+}
+
+type validator struct {
+	findings ValidationFindings
+}
+
+func (v *validator) add(severity FindingSeverity, field, format string, args ...interface{}) {
+	v.findings = append(v.findings, ValidationFinding{Severity: severity, Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks trigger without contacting Astarte, using availableInterfaces to resolve the Interfaces referenced
+// by its data triggers, and returns all findings. Interfaces are matched by name and major version.
+func Validate(trigger AstarteTrigger, availableInterfaces []interfaces.AstarteInterface) ValidationFindings {
+	v := &validator{findings: ValidationFindings{}}
+	if trigger.Name == "" {
+		v.add(ErrorSeverity, "name", "name is empty")
+	}
+	if len(trigger.SimpleTriggers) == 0 {
+		v.add(ErrorSeverity, "simple_triggers", "at least one simple trigger is needed")
+	}
+	for i, simpleTrigger := range trigger.SimpleTriggers {
+		v.validateSimpleTrigger(fmt.Sprintf("simple_triggers[%d]", i), simpleTrigger, availableInterfaces)
+	}
+	v.validateAction(trigger.Action)
+	if trigger.Policy != "" && trigger.Action.IsAMQP() {
+		v.add(ErrorSeverity, "policy", "delivery policies can be used only by HTTP actions")
+	}
+	return v.findings
+}
+
+func (v *validator) validateSimpleTrigger(field string, t SimpleTrigger, availableInterfaces []interfaces.AstarteInterface) {
+	if err := t.Type.IsValid(); err != nil {
+		v.add(ErrorSeverity, field+".type", "%q is not a valid simple trigger type", t.Type)
+		return
+	}
+	if err := t.On.IsValid(t.Type); err != nil {
+		v.add(ErrorSeverity, field+".on", "%q is not a valid condition for a %s", t.On, t.Type)
+	}
+	if t.DeviceID != "" && t.GroupName != "" {
+		v.add(ErrorSeverity, field, "device_id and group_name cannot be both set")
+	}
+	if t.DeviceID != "" && !misc.IsValidAstarteDeviceID(t.DeviceID) {
+		v.add(ErrorSeverity, field+".device_id", "%q is not a valid Device ID", t.DeviceID)
+	}
+
+	if t.Type == DeviceTriggerType {
+		if t.MatchPath != "" || t.ValueMatchOperator != "" || t.KnownValue != nil {
+			v.add(WarningSeverity, field, "match_path, value_match_operator and known_value are ignored by device triggers")
+		}
+		if t.InterfaceName != "" && !t.On.IsIntrospectionCondition() {
+			v.add(WarningSeverity, field+".interface_name", "interface_name is ignored by %s device triggers", t.On)
+		}
+		return
+	}
+	v.validateDataTrigger(field, t, availableInterfaces)
+}
+
+func (v *validator) validateDataTrigger(field string, t SimpleTrigger, availableInterfaces []interfaces.AstarteInterface) {
+	if !v.validateValueMatchOperator(field, t) {
+		return
+	}
+	if t.InterfaceName == "*" {
+		if t.MatchPath != "/*" {
+			v.add(ErrorSeverity, field+".match_path", "triggers on any interface must match any path (\"/*\")")
+		}
+		if t.ValueMatchOperator != AnyValueOperator {
+			v.add(ErrorSeverity, field+".value_match_operator", "triggers on any interface must match any value")
+		}
+		return
+	}
+	if iface := v.findDataTriggerInterface(field, t, availableInterfaces); iface != nil {
+		v.validateDataTriggerOnInterface(field, t, *iface)
+	}
+}
+
+// validateValueMatchOperator checks the operator and the presence of known_value, and returns false if the operator
+// is invalid
+func (v *validator) validateValueMatchOperator(field string, t SimpleTrigger) bool {
+	if t.ValueMatchOperator == "" {
+		v.add(ErrorSeverity, field+".value_match_operator", "value_match_operator is missing")
+	} else if err := t.ValueMatchOperator.IsValid(); err != nil {
+		v.add(ErrorSeverity, field+".value_match_operator", "%q is not a valid value match operator", t.ValueMatchOperator)
+		return false
+	}
+	if t.ValueMatchOperator == AnyValueOperator && t.KnownValue != nil {
+		v.add(WarningSeverity, field+".known_value", "known_value is ignored by the %q operator", AnyValueOperator)
+	}
+	if t.ValueMatchOperator != AnyValueOperator && t.ValueMatchOperator != "" && t.KnownValue == nil {
+		v.add(ErrorSeverity, field+".known_value", "the %q operator needs a known_value", t.ValueMatchOperator)
+	}
+	return true
+}
+
+// findDataTriggerInterface returns the Interface a data trigger refers to, or nil if it cannot be found
+func (v *validator) findDataTriggerInterface(field string, t SimpleTrigger,
+	availableInterfaces []interfaces.AstarteInterface) *interfaces.AstarteInterface {
+	if t.InterfaceName == "" {
+		v.add(ErrorSeverity, field+".interface_name", "interface_name is missing")
+		return nil
+	}
+	if t.InterfaceMajor == nil {
+		v.add(ErrorSeverity, field+".interface_major", "interface_major is missing")
+		return nil
+	}
+	for i := range availableInterfaces {
+		if availableInterfaces[i].Name == t.InterfaceName && availableInterfaces[i].MajorVersion == *t.InterfaceMajor {
+			return &availableInterfaces[i]
+		}
+	}
+	v.add(ErrorSeverity, field+".interface_name", "interface %s v%d does not exist", t.InterfaceName, *t.InterfaceMajor)
+	return nil
+}
+
+// validateDataTriggerOnInterface checks the condition, match path and value match of a data trigger against iface
+func (v *validator) validateDataTriggerOnInterface(field string, t SimpleTrigger, iface interfaces.AstarteInterface) {
+	if iface.Type == interfaces.DatastreamType && propertiesOnlyConditions[t.On] {
+		v.add(ErrorSeverity, field+".on", "%s only applies to properties, but %s is a datastream", t.On, iface.Name)
+	}
+	if iface.Ownership == interfaces.ServerOwnership {
+		v.add(WarningSeverity, field+".interface_name", "%s is server owned, and data triggers only fire on data sent by devices",
+			iface.Name)
+	}
+
+	mapping, ok := v.validateMatchPath(field+".match_path", t.MatchPath, iface)
+	if !ok || t.ValueMatchOperator == AnyValueOperator || t.ValueMatchOperator == "" {
+		return
+	}
+	if iface.Aggregation == interfaces.ObjectAggregation {
+		v.add(ErrorSeverity, field+".value_match_operator", "value match operators cannot be used on aggregates")
+		return
+	}
+	if mapping == nil {
+		v.add(ErrorSeverity, field+".value_match_operator", "value match operators need a match_path matching a single mapping")
+		return
+	}
+	v.validateValueMatch(field, t.ValueMatchOperator, t.KnownValue, mapping.Type)
+}
+
+// validateMatchPath returns the mapping matched by matchPath, if it matches a single one, and whether it is valid
+func (v *validator) validateMatchPath(field, matchPath string, iface interfaces.AstarteInterface) (*interfaces.AstarteInterfaceMapping, bool) {
+	if matchPath == "/*" {
+		return nil, true
+	}
+	if !strings.HasPrefix(matchPath, "/") {
+		v.add(ErrorSeverity, field, "%q must start with '/'", matchPath)
+		return nil, false
+	}
+	if mapping, err := interfaces.InterfaceMappingFromPath(iface, matchPath); err == nil {
+		return &mapping, true
+	}
+	if err := interfaces.ValidateQuery(iface, matchPath); err != nil {
+		v.add(ErrorSeverity, field, "%q does not match any mapping of %s: %v", matchPath, iface.Name, err)
+		return nil, false
+	}
+	return nil, true
+}
+
+func (v *validator) validateValueMatch(field string, operator ValueMatchOperator, knownValue interface{},
+	mappingType interfaces.AstarteMappingType) {
+	isArray := strings.HasSuffix(string(mappingType), "array")
+	valueType := mappingType
+	switch operator {
+	case GreaterThanOperator, GreaterOrEqualToOperator, LessThanOperator, LessOrEqualToOperator:
+		if !isNumericType(mappingType) {
+			v.add(ErrorSeverity, field+".value_match_operator", "%q needs a numeric mapping, not %s", operator, mappingType)
+			return
+		}
+	case ContainsOperator, NotContainsOperator:
+		switch {
+		case isArray:
+			valueType = interfaces.AstarteMappingType(strings.TrimSuffix(string(mappingType), "array"))
+		case mappingType == interfaces.String, mappingType == interfaces.BinaryBlob:
+		default:
+			v.add(ErrorSeverity, field+".value_match_operator", "%q needs a string, binaryblob or array mapping, not %s",
+				operator, mappingType)
+			return
+		}
+	}
+
+	if err := checkKnownValue(valueType, knownValue); err != nil {
+		v.add(ErrorSeverity, field+".known_value", "known_value is not a valid %s: %v", valueType, err)
+	}
+}
+
+func isNumericType(mappingType interfaces.AstarteMappingType) bool {
+	return mappingType == interfaces.Double || mappingType == interfaces.Integer || mappingType == interfaces.LongInteger
+}
+
+// checkKnownValue checks that a JSON-decoded knownValue has the JSON representation of mappingType
+func checkKnownValue(mappingType interfaces.AstarteMappingType, knownValue interface{}) error {
+	// Astarte does not parse strings into numbers, booleans or arrays
+	if _, isString := knownValue.(string); isString && (isNumericType(mappingType) || mappingType == interfaces.Boolean ||
+		strings.HasSuffix(string(mappingType), "array")) {
+		return fmt.Errorf("%q is a string", knownValue)
+	}
+	if n, isNumber := knownValue.(json.Number); isNumber && (mappingType == interfaces.Integer || mappingType == interfaces.LongInteger) {
+		if _, err := n.Int64(); err != nil {
+			return fmt.Errorf("%v is not an integer", n)
+		}
+	}
+	_, err := interfaces.CoerceValue(mappingType, knownValue)
+	return err
+}
+
+func (v *validator) validateAction(a TriggerAction) {
+	kinds := 0
+	for _, set := range []bool{a.HTTPURL != "", a.HTTPPostURL != "", a.IsAMQP()} {
+		if set {
+			kinds++
+		}
+	}
+	switch {
+	case kinds == 0:
+		v.add(ErrorSeverity, "action", "action needs either http_url, http_post_url or amqp_exchange")
+		return
+	case kinds > 1:
+		v.add(ErrorSeverity, "action", "only one of http_url, http_post_url and amqp_exchange can be set")
+		return
+	}
+
+	if a.IsHTTP() {
+		v.validateHTTPAction(a)
+		return
+	}
+	v.validateAMQPAction(a)
+}
+
+func (v *validator) validateHTTPAction(a TriggerAction) {
+	v.validateHTTPTarget(a)
+	if a.Template != "" || a.TemplateType != "" {
+		if a.TemplateType != MustacheTemplateType {
+			v.add(ErrorSeverity, "action.template_type", "template_type must be %q", MustacheTemplateType)
+		}
+		if err := checkMustacheTemplate(a.Template); err != nil {
+			v.add(ErrorSeverity, "action.template", "template is malformed: %v", err)
+		}
+	}
+	if len(a.AMQPStaticHeaders) > 0 || a.AMQPRoutingKey != "" || a.AMQPMessagePersistent != nil ||
+		a.AMQPMessageExpirationMillis != nil || a.AMQPMessagePriority != nil {
+		v.add(WarningSeverity, "action", "AMQP fields are ignored by HTTP actions")
+	}
+}
+
+// validateHTTPTarget checks the method and URL of an HTTP action
+func (v *validator) validateHTTPTarget(a TriggerAction) {
+	field, rawURL := "action.http_url", a.HTTPURL
+	if a.HTTPPostURL != "" {
+		field, rawURL = "action.http_post_url", a.HTTPPostURL
+		if a.HTTPMethod != "" {
+			v.add(WarningSeverity, "action.http_method", "http_method is ignored with http_post_url")
+		}
+	} else if a.HTTPMethod == "" {
+		v.add(ErrorSeverity, "action.http_method", "http_method is missing")
+	} else if !validHTTPMethods[strings.ToLower(a.HTTPMethod)] {
+		v.add(ErrorSeverity, "action.http_method", "%q is not a valid HTTP method", a.HTTPMethod)
+	}
+	if parsedURL, err := url.Parse(rawURL); err != nil {
+		v.add(ErrorSeverity, field, "%q is not a valid URL: %v", rawURL, err)
+	} else if (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+		v.add(ErrorSeverity, field, "%q is not an absolute http or https URL", rawURL)
+	}
+}
+
+func (v *validator) validateAMQPAction(a TriggerAction) {
+	if !isValidAMQPExchange(a.AMQPExchange) {
+		v.add(ErrorSeverity, "action.amqp_exchange", "%q is not in the astarte_events_<realm>_<name> form", a.AMQPExchange)
+	}
+	if strings.ContainsAny(a.AMQPRoutingKey, "{}") {
+		v.add(ErrorSeverity, "action.amqp_routing_key", "%q must not contain '{' or '}'", a.AMQPRoutingKey)
+	}
+	if a.AMQPMessagePersistent == nil {
+		v.add(ErrorSeverity, "action.amqp_message_persistent", "amqp_message_persistent is missing")
+	}
+	if a.AMQPMessageExpirationMillis == nil {
+		v.add(ErrorSeverity, "action.amqp_message_expiration_ms", "amqp_message_expiration_ms is missing")
+	} else if *a.AMQPMessageExpirationMillis < 0 {
+		v.add(ErrorSeverity, "action.amqp_message_expiration_ms", "amqp_message_expiration_ms must not be negative")
+	}
+	if a.AMQPMessagePriority != nil && (*a.AMQPMessagePriority < 0 || *a.AMQPMessagePriority > 9) {
+		v.add(ErrorSeverity, "action.amqp_message_priority", "amqp_message_priority must be between 0 and 9")
+	}
+	if a.HTTPMethod != "" || len(a.HTTPStaticHeaders) > 0 || a.Template != "" || a.IgnoreSSLErrors != nil {
+		v.add(WarningSeverity, "action", "HTTP fields are ignored by AMQP actions")
+	}
+}
+
+// isValidAMQPExchange returns whether exchange has the astarte_events_<realm>_<name> form. The realm of the trigger is
+// not known, so any realm is accepted: realm names have no '_', hence the first one ends the realm.
+func isValidAMQPExchange(exchange string) bool {
+	if !strings.HasPrefix(exchange, "astarte_events_") {
+		return false
+	}
+	tokens := strings.SplitN(strings.TrimPrefix(exchange, "astarte_events_"), "_", 2)
+	return len(tokens) == 2 && tokens[0] != "" && tokens[1] != ""
+}
+
+// checkMustacheTemplate checks that all tags of template are closed, and that sections are balanced
+func checkMustacheTemplate(template string) error {
+	sections := []string{}
+	for rest := template; ; {
+		start := strings.Index(rest, "{{")
+		if start < 0 {
+			if strings.Contains(rest, "}}") {
+				return fmt.Errorf("unexpected '}}'")
+			}
+			break
+		}
+		if strings.Contains(rest[:start], "}}") {
+			return fmt.Errorf("unexpected '}}'")
+		}
+		end := strings.Index(rest[start+2:], "}}")
+		if end < 0 {
+			return fmt.Errorf("unclosed tag at %q", rest[start:])
+		}
+		tag := strings.TrimSpace(rest[start+2 : start+2+end])
+		rest = rest[start+2+end+2:]
+		// Triple mustaches leave a trailing '}'
+		if strings.HasPrefix(tag, "{") {
+			if !strings.HasPrefix(rest, "}") {
+				return fmt.Errorf("unclosed tag {{%s}}", tag)
+			}
+			rest = rest[1:]
+			tag = strings.TrimSpace(strings.TrimPrefix(tag, "{"))
+		}
+		if tag == "" {
+			return fmt.Errorf("empty tag")
+		}
+
+		switch tag[0] {
+		case '#', '^':
+			sections = append(sections, strings.TrimSpace(tag[1:]))
+		case '/':
+			name := strings.TrimSpace(tag[1:])
+			if len(sections) == 0 || sections[len(sections)-1] != name {
+				return fmt.Errorf("unexpected closing of section %s", name)
+			}
+			sections = sections[:len(sections)-1]
+		}
+	}
+	if len(sections) > 0 {
+		return fmt.Errorf("section %s is not closed", sections[len(sections)-1])
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+// Copyright © 2021 Ispirata Srl
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package triggers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/astarte-platform/astarte-go/interfaces"
+)
+
+const (
+	testValuesInterface = `{
+		"interface_name": "org.astarte-platform.genericsensors.Values",
+		"version_major": 0,
+		"version_minor": 1,
+		"type": "datastream",
+		"ownership": "device",
+		"mappings": [
+			{"endpoint": "/%{sensor_id}/value", "type": "double"},
+			{"endpoint": "/%{sensor_id}/tags", "type": "stringarray"}
+		]
+	}`
+	testAvailableSensorsInterface = `{
+		"interface_name": "org.astarte-platform.genericsensors.AvailableSensors",
+		"version_major": 1,
+		"version_minor": 0,
+		"type": "properties",
+		"ownership": "device",
+		"mappings": [
+			{"endpoint": "/%{sensor_id}/name", "type": "string"},
+			{"endpoint": "/%{sensor_id}/unit", "type": "string"},
+			{"endpoint": "/%{sensor_id}/threshold", "type": "integer"}
+		]
+	}`
+)
+
+func testValidationInterfaces(t *testing.T) []interfaces.AstarteInterface {
+	ret := []interfaces.AstarteInterface{}
+	for _, content := range []string{testValuesInterface, testAvailableSensorsInterface} {
+		iface, err := interfaces.ParseInterfaceFromString(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret = append(ret, iface)
+	}
+	return ret
+}
+
+func findingFields(findings ValidationFindings) []string {
+	fields := []string{}
+	for _, finding := range findings {
+		fields = append(fields, finding.Field)
+	}
+	return fields
+}
+
+func TestValidateValidTriggers(t *testing.T) {
+	ifaces := testValidationInterfaces(t)
+	validTriggers := []AstarteTrigger{
+		NewTrigger("overheat", NewHTTPAction("https://example.com/hook", "post").WithTemplate("{{#value}}{{{ value }}}{{/value}}"),
+			NewDataSimpleTrigger(IncomingDataCondition, "org.astarte-platform.genericsensors.Values", 0, "/%{sensor_id}/value").
+				WithValueMatch(GreaterThanOperator, 80.5)),
+		NewTrigger("tagged", NewAMQPAction("astarte_events_test_tags", "tags", false, time.Minute),
+			NewDataSimpleTrigger(IncomingDataCondition, "org.astarte-platform.genericsensors.Values", 0, "/%{sensor_id}/tags").
+				WithValueMatch(ContainsOperator, "faulty"),
+			NewDataSimpleTrigger(ValueChangeCondition, "org.astarte-platform.genericsensors.AvailableSensors", 1, "/*"),
+			NewDeviceSimpleTrigger(DeviceConnectedCondition).ForDevice("1vMeFtaJQF259nMsnis3sw")),
+	}
+	for _, trigger := range validTriggers {
+		if findings := Validate(trigger, ifaces); len(findings) != 0 {
+			t.Errorf("unexpected findings for %s: %v", trigger.Name, findings)
+		}
+	}
+}
+
+func TestValidateInvalidTrigger(t *testing.T) {
+	trigger, err := ParseTrigger([]byte(`{
+		"name": "broken",
+		"action": {"http_url": "example.com/hook", "http_method": "fetch", "template": "{{#value}}{{ value }}"},
+		"simple_triggers": [
+			{"type": "data_trigger", "on": "incoming_data", "interface_name": "org.astarte-platform.genericsensors.Values",
+			 "interface_major": 1, "match_path": "/*", "value_match_operator": "*"},
+			{"type": "data_trigger", "on": "value_change", "interface_name": "org.astarte-platform.genericsensors.Values",
+			 "interface_major": 0, "match_path": "/%{sensor_id}/value", "value_match_operator": ">", "known_value": "80"},
+			{"type": "data_trigger", "on": "incoming_data", "interface_name": "org.astarte-platform.genericsensors.AvailableSensors",
+			 "interface_major": 1, "match_path": "/%{sensor_id}/name", "value_match_operator": "<", "known_value": 3},
+			{"type": "data_trigger", "on": "incoming_data", "interface_name": "org.astarte-platform.genericsensors.AvailableSensors",
+			 "interface_major": 1, "match_path": "/%{sensor_id}/threshold", "value_match_operator": "==", "known_value": 1.5},
+			{"type": "data_trigger", "on": "incoming_data", "interface_name": "org.astarte-platform.genericsensors.AvailableSensors",
+			 "interface_major": 1, "match_path": "/%{sensor_id}/missing", "value_match_operator": "*"},
+			{"type": "device_trigger", "on": "incoming_data"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findings := Validate(trigger, testValidationInterfaces(t))
+	expectedFields := []string{
+		"simple_triggers[0].interface_name",
+		"simple_triggers[1].on",
+		"simple_triggers[1].known_value",
+		"simple_triggers[2].value_match_operator",
+		"simple_triggers[3].known_value",
+		"simple_triggers[4].match_path",
+		"simple_triggers[5].on",
+		"action.http_method",
+		"action.http_url",
+		"action.template_type",
+		"action.template",
+	}
+	if fields := findingFields(findings); !reflect.DeepEqual(fields, expectedFields) {
+		t.Errorf("unexpected findings %v", findings)
+	}
+	if !findings.HasErrors() {
+		t.Error("findings have no errors")
+	}
+}
+
+func TestValidateAMQPAction(t *testing.T) {
+	priority, expiration := 12, -1
+	action := NewAMQPAction("events", "{key}", true, 0)
+	action.AMQPMessagePriority = &priority
+	action.AMQPMessageExpirationMillis = &expiration
+	trigger := NewTrigger("amqp", action, NewDeviceSimpleTrigger(DeviceErrorCondition)).WithPolicy("retry")
+
+	expectedFields := []string{
+		"action.amqp_exchange",
+		"action.amqp_routing_key",
+		"action.amqp_message_expiration_ms",
+		"action.amqp_message_priority",
+		"policy",
+	}
+	if fields := findingFields(Validate(trigger, nil)); !reflect.DeepEqual(fields, expectedFields) {
+		t.Errorf("unexpected findings %v", fields)
+	}
+
+	for exchange, valid := range map[string]bool{"astarte_events_test_exchange": true, "astarte_events_test_": false,
+		"astarte_events__exchange": false, "astarte_events_test": false} {
+		if isValidAMQPExchange(exchange) != valid {
+			t.Errorf("expected %s to be valid: %v", exchange, valid)
+		}
+	}
+}
+
+func TestValidateWarnings(t *testing.T) {
+	trigger := NewTrigger("any", NewHTTPAction("http://localhost:4000", "post"),
+		NewDataSimpleTrigger(IncomingDataCondition, "*", 0, "/*"),
+		NewDeviceSimpleTrigger(DeviceConnectedCondition).WithValueMatch(EqualToOperator, true))
+	trigger.SimpleTriggers[0].InterfaceMajor = nil
+
+	findings := Validate(trigger, nil)
+	if len(findings) != 1 || findings[0].Severity != WarningSeverity || findings[0].Field != "simple_triggers[1]" {
+		t.Errorf("unexpected findings %v", findings)
+	}
+	if findings.HasErrors() {
+		t.Error("warnings are reported as errors")
+	}
+}